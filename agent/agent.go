@@ -8,9 +8,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/drone/drone-exec/archive"
 	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/event"
 	"github.com/drone/drone-exec/yaml"
 	"github.com/drone/drone-exec/yaml/expander"
+	"github.com/drone/drone-exec/yaml/remote"
 	"github.com/drone/drone-exec/yaml/transform"
 	"github.com/drone/drone-go/drone"
 )
@@ -22,15 +26,90 @@ type Logger interface {
 type Agent struct {
 	Update    UpdateFunc
 	Logger    LoggerFunc
+	Report    ReportFunc
+	Record    RecordFunc
+	Events    EventFunc
 	Engine    build.Engine
 	Timeout   time.Duration
 	Platform  string
+	Image     string
 	Namespace string
+	Clone     string
+	Pod       string
 	Disable   []string
 	Escalate  []string
 	Netrc     []string
 	Local     string
 	Pull      bool
+	LineSize  int
+	LineRate  int
+	MaxLines  int
+	Preserve  []string
+	MTU       int
+
+	// CloneRetries defines the number of times the clone step is retried
+	// on failure, waiting CloneBackoff multiplied by the attempt number
+	// between retries.
+	CloneRetries int
+	CloneBackoff time.Duration
+
+	// BuildRetries defines the number of times the entire build is retried
+	// from scratch when it fails due to an infrastructure error, such as an
+	// oom kill or the loss of the docker daemon. Build failures are not
+	// retried.
+	BuildRetries int
+
+	// YamlURL defines a remote location from which the Yaml configuration
+	// is fetched, overriding the configuration in the payload. The url may
+	// reference build environment variables, such as ${DRONE_REPO}.
+	YamlURL      string
+	YamlChecksum string
+
+	// DetachedLogs, if set, defines the directory to which the output of
+	// detached containers is written instead of the build output.
+	DetachedLogs string
+
+	// PruneImages removes the images pulled for the build on teardown,
+	// except images matching the KeepImages patterns.
+	PruneImages bool
+	KeepImages  []string
+
+	// DisableNodes defines the node types disabled for every build, such
+	// as the cache or services. The Yaml may disable further node types.
+	DisableNodes []string
+
+	// StopDetached stops the service containers, except the ambassador, as
+	// soon as a step fails instead of on teardown.
+	StopDetached bool
+
+	// StrictImages requires the images of the Yaml to define an explicit
+	// tag or digest, instead of defaulting to the :latest tag.
+	StrictImages bool
+
+	// StepStats samples the resource usage of each step, recording the peak
+	// memory and cpu usage in the step results.
+	StepStats bool
+
+	// ImageDigests pins the build images to the digests defined for each
+	// image reference, such as the digests of an image lockfile.
+	ImageDigests map[string]string
+
+	// Archiver, if set, archives and uploads the workspace when the build
+	// fails, before the pipeline is torn down.
+	Archiver *archive.Archiver
+
+	// InsecureSkipVerify loads the secrets even if the Yaml configuration
+	// could not be verified against the checksum. This exposes secrets to
+	// modified configurations and is only intended for local testing.
+	InsecureSkipVerify bool
+
+	// Replay indicates the payload was recorded once the Yaml configuration
+	// was resolved, and is not resolved again.
+	Replay bool
+
+	// Explain, if set, is called with each change made to the build steps
+	// by the Yaml transforms, once the Yaml is transformed.
+	Explain ExplainFunc
 }
 
 func (a *Agent) Poll() error {
@@ -50,7 +129,7 @@ func (a *Agent) Run(payload *drone.Payload, cancel <-chan bool) error {
 	payload.Job.Status = drone.StatusRunning
 	payload.Job.Started = time.Now().Unix()
 
-	spec, err := a.prep(payload)
+	spec, unused, err := a.prep(payload)
 	if err != nil {
 		payload.Job.Error = err.Error()
 		payload.Job.ExitCode = 255
@@ -60,7 +139,12 @@ func (a *Agent) Run(payload *drone.Payload, cancel <-chan bool) error {
 		return err
 	}
 	a.Update(payload)
-	err = a.exec(spec, payload, cancel)
+	results, err := a.exec(spec, payload, cancel)
+	for i := 1; i <= a.BuildRetries && build.Retriable(err); i++ {
+		logrus.Warnf("Retrying build %s/%s#%d.%d after infrastructure failure (attempt %d of %d). %s",
+			payload.Repo.Owner, payload.Repo.Name, payload.Build.Number, payload.Job.Number, i, a.BuildRetries, err)
+		results, err = a.exec(spec, payload, cancel)
+	}
 
 	if err != nil {
 		payload.Job.ExitCode = 255
@@ -68,6 +152,9 @@ func (a *Agent) Run(payload *drone.Payload, cancel <-chan bool) error {
 	if exitErr, ok := err.(*build.ExitError); ok {
 		payload.Job.ExitCode = exitErr.Code
 	}
+	if _, ok := err.(*build.LogLimitError); ok {
+		payload.Job.ExitCode = build.LogLimitExitCode
+	}
 
 	payload.Job.Finished = time.Now().Unix()
 
@@ -82,45 +169,85 @@ func (a *Agent) Run(payload *drone.Payload, cancel <-chan bool) error {
 
 	a.Update(payload)
 
+	if len(unused) != 0 {
+		logrus.Warnf("Secrets %s are not used by any step of build %s/%s#%d.%d. Check the secret names and images",
+			strings.Join(unused, ", "),
+			payload.Repo.Owner, payload.Repo.Name, payload.Build.Number, payload.Job.Number)
+	}
+
+	done := &event.Event{Type: event.BuildDone, ExitCode: payload.Job.ExitCode}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	a.emit(payload, done)
+
+	if a.Report != nil {
+		a.Report(payload, results)
+	}
+
 	return err
 }
 
-func (a *Agent) prep(w *drone.Payload) (*yaml.Config, error) {
+// Tree returns the Yaml configuration for the payload once parsed and
+// transformed, without executing the build.
+func (a *Agent) Tree(w *drone.Payload) (*yaml.Config, error) {
+	conf, _, err := a.prep(w)
+	return conf, err
+}
+
+func (a *Agent) prep(w *drone.Payload) (*yaml.Config, []string, error) {
 
 	envs := toEnv(w)
-	w.Yaml = expander.ExpandString(w.Yaml, envs)
 
-	// inject the netrc file into the clone plugin if the repositroy is
-	// private and requires authentication.
+	if !a.Replay {
+		if err := a.resolve(w, envs); err != nil {
+			return nil, nil, err
+		}
+	}
+	if a.Record != nil {
+		a.Record(w)
+	}
+
 	var secrets []*drone.Secret
-	if w.Build.Verified {
-		secrets = append(secrets, w.Secrets...)
+	secrets = append(secrets, transform.VerifiedSecrets(w.Secrets, w.Build.Verified, a.InsecureSkipVerify)...)
+	if !w.Build.Verified && len(w.Secrets) != 0 {
+		if a.InsecureSkipVerify {
+			logrus.Warnf("INSECURE: loading secrets for unverified build %s/%s#%d.%d",
+				w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
+		} else {
+			logrus.Warnf("Secrets are not loaded for unverified build %s/%s#%d.%d",
+				w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
+		}
 	}
 
-	if w.Repo.IsPrivate {
-		secrets = append(secrets, &drone.Secret{
-			Name:   "DRONE_NETRC_USERNAME",
-			Value:  w.Netrc.Login,
-			Images: []string{"*"},
-			Events: []string{"*"},
-		})
-		secrets = append(secrets, &drone.Secret{
-			Name:   "DRONE_NETRC_PASSWORD",
-			Value:  w.Netrc.Password,
-			Images: []string{"*"},
-			Events: []string{"*"},
-		})
-		secrets = append(secrets, &drone.Secret{
-			Name:   "DRONE_NETRC_MACHINE",
-			Value:  w.Netrc.Machine,
-			Images: []string{"*"},
-			Events: []string{"*"},
-		})
+	// secrets without a value could not be decrypted by the server and are
+	// reported, since the build would otherwise run without them silently.
+	if names := transform.EmptySecrets(secrets); len(names) != 0 {
+		logrus.Warnf("Secrets %s are empty for build %s/%s#%d.%d. Check the secrets are decrypted",
+			strings.Join(names, ", "),
+			w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
 	}
 
 	conf, err := yaml.ParseString(w.Yaml)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// the changes made by each transform are recorded for the explanation
+	// of the transformed Yaml, if enabled.
+	var x *transform.Explanation
+	if a.Explain != nil {
+		x = transform.Explain(conf)
+	}
+
+	if err := transform.ImageDefault(conf, a.Image); err != nil {
+		return nil, nil, err
+	}
+	x.Record("ImageDefault")
+	if a.StrictImages {
+		if err := transform.ImageStrict(conf); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	src := "src"
@@ -128,70 +255,240 @@ func (a *Agent) prep(w *drone.Payload) (*yaml.Config, error) {
 		src = filepath.Join(src, url.Host, url.Path)
 	}
 
-	transform.Clone(conf, w.Repo.Kind)
+	// the clone plugin defaults to the repository type (git, hg, etc) unless
+	// the agent is configured with a custom clone image.
+	plugin := w.Repo.Kind
+	if a.Clone != "" {
+		plugin = a.Clone
+	}
+	if err := transform.NodeDisable(conf, a.DisableNodes); err != nil {
+		return nil, nil, err
+	}
+	x.Record("NodeDisable")
+	transform.Clone(conf, plugin)
+	x.Record("Clone")
 	transform.Environ(conf, envs)
+	x.Record("Environ")
 	transform.DefaultFilter(conf)
+	x.Record("DefaultFilter")
+	transform.RepoFilter(conf, w.Repo.FullName)
+	x.Record("RepoFilter")
+	transform.TargetFilter(conf, w.Build.Event, targetBranch(w))
+	x.Record("TargetFilter")
 	if w.BuildLast != nil {
 		transform.ChangeFilter(conf, w.BuildLast.Status)
+		x.Record("ChangeFilter")
 	}
 
+	secrets = transform.BranchSecrets(conf, secrets, w.Build.Branch, w.Build.Event)
 	transform.ImageSecrets(conf, secrets, w.Build.Event)
+	x.Record("ImageSecrets")
+
+	// secrets that are not used by any container are reported at the end of
+	// the build, since they are typically misconfigured. The yaml token is
+	// used by the agent.
+	var unused []string
+	for _, name := range transform.UnusedSecrets(conf, secrets, w.Build.Event) {
+		if name != "DRONE_YAML_TOKEN" || a.YamlURL == "" {
+			unused = append(unused, name)
+		}
+	}
 	transform.Identifier(conf)
-	transform.WorkspaceTransform(conf, "/drone", src)
+	x.Record("Identifier")
+	transform.StepIdentifier(conf)
+	x.Record("StepIdentifier")
+	if err := transform.WorkspaceTransform(conf, "/drone", src); err != nil {
+		return nil, nil, err
+	}
+	x.Record("WorkspaceTransform")
 
 	if err := transform.Check(conf, w.Repo.IsTrusted); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	transform.CommandTransform(conf)
+	x.Record("CommandTransform")
 	transform.ImagePull(conf, a.Pull)
+	x.Record("ImagePull")
 	transform.ImageTag(conf)
+	x.Record("ImageTag")
 	transform.ImageName(conf)
+	x.Record("ImageName")
 	transform.ImageNamespace(conf, a.Namespace)
+	x.Record("ImageNamespace")
+
+	// images built by untrusted repositories are scoped to the build, so
+	// they cannot replace the images used by other builds of the daemon.
+	if !w.Repo.IsTrusted {
+		transform.ImageBuildScope(conf)
+		x.Record("ImageBuildScope")
+	}
+
+	if err := transform.CheckEscalate(conf, a.Escalate, w.Repo.IsTrusted); err != nil {
+		return nil, nil, err
+	}
 	transform.ImageEscalate(conf, a.Escalate)
+	x.Record("ImageEscalate")
+	transform.DockerSocket(conf)
+	x.Record("DockerSocket")
 	transform.PluginParams(conf)
+	x.Record("PluginParams")
+	transform.CloneVerify(conf)
+	x.Record("CloneVerify")
+
+	// inject the netrc credentials into the clone plugin if the repository
+	// is private and requires authentication.
+	if w.Repo.IsPrivate {
+		transform.CloneNetrc(conf, w.Netrc, isFork(w))
+		x.Record("CloneNetrc")
+	}
 
 	if a.Local != "" {
 		transform.PluginDisable(conf, a.Disable)
+		x.Record("PluginDisable")
 		transform.ImageVolume(conf, []string{a.Local + ":" + conf.Workspace.Path})
+		x.Record("ImageVolume")
 	}
 
-	transform.Pod(conf)
+	// cache volumes are scoped to the branch. Pull requests are scoped to the
+	// pull request ref to prevent polluting the cache of the target branch,
+	// and fallback to the cache of the default branch.
+	branch := w.Build.Branch
+	if w.Build.Event == drone.EventPull {
+		branch = w.Build.Ref
+	}
+	transform.Cache(conf, w.Repo.FullName, branch, w.Repo.Branch, a.Pod)
+	x.Record("Cache")
+	transform.StepCache(conf, w.Repo.FullName)
+	x.Record("StepCache")
+	// the workspace of local builds is the host source directory, which must
+	// not be made writable by other users.
+	if a.Local == "" {
+		transform.WorkspacePermissions(conf, a.Pod)
+		x.Record("WorkspacePermissions")
+	}
 
-	return conf, nil
+	// networks defined in the Yaml are not created, since the containers use
+	// pod networking. The build network only configures the network MTU.
+	conf.Networks = nil
+
+	transform.Pod(conf, a.Pod)
+	x.Record("Pod")
+	transform.Network(conf, a.MTU)
+	x.Record("Network")
+	transform.CloneRetry(conf, a.CloneRetries, a.Pod)
+	x.Record("CloneRetry")
+
+	// the images are pinned once the image references are final, which are
+	// the references of the image lockfile.
+	transform.ImageDigest(conf, a.ImageDigests)
+	x.Record("ImageDigest")
+
+	if x != nil {
+		for _, change := range x.Changes {
+			a.Explain(change)
+		}
+	}
+	return conf, unused, nil
 }
 
-func (a *Agent) exec(spec *yaml.Config, payload *drone.Payload, cancel <-chan bool) error {
+// resolve resolves the Yaml configuration, fetching the configuration from
+// the remote location, if defined, and substituting environment variables.
+func (a *Agent) resolve(w *drone.Payload, envs map[string]string) error {
+
+	// fetch the Yaml configuration from the remote location, authenticating
+	// with the yaml token secret if one exists.
+	if a.YamlURL != "" {
+		var token string
+		for _, secret := range w.Secrets {
+			if secret.Name == "DRONE_YAML_TOKEN" {
+				token = secret.Value
+			}
+		}
+		yml, err := remote.Fetch(expander.ExpandString(a.YamlURL, envs), token, a.YamlChecksum)
+		if err != nil {
+			return err
+		}
+		w.Yaml = yml
+	}
+
+	// pull requests only substitute safe variables into the Yaml to prevent
+	// untrusted values, such as the commit message, from altering the
+	// structure of a verified Yaml configuration.
+	if w.Build.Event == drone.EventPull {
+		w.Yaml = expander.ExpandStringSafe(w.Yaml, envs, toSafeEnv(w))
+	} else {
+		w.Yaml = expander.ExpandString(w.Yaml, envs)
+	}
+	return nil
+}
+
+func (a *Agent) exec(spec *yaml.Config, payload *drone.Payload, cancel <-chan bool) ([]*build.Result, error) {
 
 	conf := build.Config{
-		Engine: a.Engine,
-		Buffer: 500,
+		Engine:   a.Engine,
+		Buffer:   500,
+		Backoff:  a.CloneBackoff,
+		LineSize: a.LineSize,
+		LineRate: a.LineRate,
+		MaxLines: a.MaxLines,
+		Preserve: a.Preserve,
+
+		DetachedLogs: a.DetachedLogs,
+		PruneImages:  a.PruneImages,
+		KeepImages:   a.KeepImages,
+		StopDetached: a.StopDetached,
+		Stats:        a.StepStats,
 	}
 
 	pipeline := conf.Pipeline(spec)
-	defer pipeline.Teardown()
+	defer func() {
+		if a.Archiver != nil && pipeline.Failed() {
+			name := fmt.Sprintf("%s_%s_%d.%d.tar.gz",
+				payload.Repo.Owner, payload.Repo.Name, payload.Build.Number, payload.Job.Number)
+			if err := a.Archiver.Archive(name, spec, pipeline.Err()); err != nil {
+				logrus.Warnf("Cannot archive the workspace of the failed build. %s", err)
+			}
+		}
+		pipeline.Teardown()
+		if preserved := pipeline.Preserved(); pipeline.Err() != nil && len(preserved) != 0 {
+			logrus.Warnf("Preserved containers %s of the failed build",
+				strings.Join(preserved, ", "))
+		}
+		for _, path := range pipeline.DetachedLogs() {
+			logrus.Infof("Detached container output written to %s", path)
+		}
+	}()
 
 	// setup the build environment
 	if err := pipeline.Setup(); err != nil {
-		return err
+		return nil, err
 	}
 
 	timeout := time.After(time.Duration(payload.Repo.Timeout) * time.Minute)
 
+	// steps run one at a time, so every step started before the pipeline
+	// signals the next step, or completion, has finished.
+	var finished int
+
 	for {
 		select {
 		case <-pipeline.Done():
-			return pipeline.Err()
+			a.flush(payload, pipeline)
+			a.emitFinished(payload, pipeline.Results(), finished)
+			logrus.Debugf("Pipeline complete. %s", pipeline.Summary())
+			return pipeline.Results(), pipeline.Err()
 		case <-cancel:
 			pipeline.Stop()
-			return fmt.Errorf("termination request received, build cancelled")
+			return pipeline.Results(), fmt.Errorf("termination request received, build cancelled")
 		case <-timeout:
 			pipeline.Stop()
-			return fmt.Errorf("maximum time limit exceeded, build cancelled")
+			return pipeline.Results(), fmt.Errorf("maximum time limit exceeded, build cancelled")
 		case <-time.After(a.Timeout):
 			pipeline.Stop()
-			return fmt.Errorf("terminal inactive for %v, build cancelled", a.Timeout)
+			return pipeline.Results(), fmt.Errorf("terminal inactive for %v, build cancelled", a.Timeout)
 		case <-pipeline.Next():
+			finished = a.emitFinished(payload, pipeline.Results(), finished)
 
 			// TODO(bradrydzewski) this entire block of code should probably get
 			// encapsulated in the pipeline.
@@ -210,12 +507,21 @@ func (a *Agent) exec(spec *yaml.Config, payload *drone.Payload, cancel <-chan bo
 				payload.Build.Branch,
 				status, payload.Job.Environment) { // TODO: fix this whole section
 
+				pipeline.Skip()
+			} else if !pipeline.Head().Constraints.MatchSuccess(pipeline.Succeeded()) {
+				pipeline.Skip()
+			} else if !pipeline.Head().DependsOn.Match(pipeline.Succeeded()) {
 				pipeline.Skip()
 			} else {
+				a.emit(payload, &event.Event{
+					Type: event.StepStarted,
+					Step: pipeline.Head().Step,
+					Name: pipeline.Head().Name,
+				})
 				pipeline.Exec()
 			}
 		case line := <-pipeline.Pipe():
-			a.Logger(line)
+			a.log(payload, line)
 		}
 	}
 }
@@ -246,7 +552,6 @@ func toEnv(w *drone.Payload) map[string]string {
 		"DRONE_BUILD_NUMBER":         fmt.Sprintf("%d", w.Build.Number),
 		"DRONE_BUILD_EVENT":          w.Build.Event,
 		"DRONE_BUILD_STATUS":         w.Build.Status,
-		"DRONE_BUILD_LINK":           fmt.Sprintf("%s/%s/%d", w.System.Link, w.Repo.FullName, w.Build.Number),
 		"DRONE_BUILD_CREATED":        fmt.Sprintf("%d", w.Build.Created),
 		"DRONE_BUILD_STARTED":        fmt.Sprintf("%d", w.Build.Started),
 		"DRONE_BUILD_FINISHED":       fmt.Sprintf("%d", w.Build.Finished),
@@ -257,11 +562,18 @@ func toEnv(w *drone.Payload) map[string]string {
 		"DRONE_VERSION":              w.System.Version,
 	}
 
+	// the build link is only defined if the server link is known, since
+	// plugins, such as notification plugins, render the link as is.
+	if w.System.Link != "" {
+		envs["DRONE_BUILD_LINK"] = fmt.Sprintf("%s/%s/%d", strings.TrimSuffix(w.System.Link, "/"), w.Repo.FullName, w.Build.Number)
+	}
+
 	if w.Build.Event == drone.EventTag {
 		envs["DRONE_TAG"] = strings.TrimPrefix(w.Build.Ref, "refs/tags/")
 	}
 	if w.Build.Event == drone.EventPull {
 		envs["DRONE_PULL_REQUEST"] = pullRegexp.FindString(w.Build.Ref)
+		envs["DRONE_TARGET_BRANCH"] = targetBranch(w)
 	}
 	if w.Build.Event == drone.EventDeploy {
 		envs["DRONE_DEPLOY_TO"] = w.Build.Deploy
@@ -280,4 +592,127 @@ func toEnv(w *drone.Payload) map[string]string {
 	return envs
 }
 
+// toSafeEnv returns the names of the environment variables that are safe to
+// substitute into the Yaml configuration of untrusted builds. These variables
+// are provided by the server and cannot be altered by the commit author.
+func toSafeEnv(w *drone.Payload) []string {
+	safe := []string{
+		"CI",
+		"DRONE",
+		"DRONE_ARCH",
+		"DRONE_REPO",
+		"DRONE_REPO_SCM",
+		"DRONE_REPO_OWNER",
+		"DRONE_REPO_NAME",
+		"DRONE_REPO_LINK",
+		"DRONE_REPO_BRANCH",
+		"DRONE_REPO_PRIVATE",
+		"DRONE_REPO_TRUSTED",
+		"DRONE_REMOTE_URL",
+		"DRONE_COMMIT_SHA",
+		"DRONE_COMMIT_REF",
+		"DRONE_COMMIT_BRANCH",
+		"DRONE_BUILD_NUMBER",
+		"DRONE_BUILD_EVENT",
+		"DRONE_BUILD_STATUS",
+		"DRONE_BUILD_LINK",
+		"DRONE_BUILD_CREATED",
+		"DRONE_BUILD_STARTED",
+		"DRONE_BUILD_FINISHED",
+		"DRONE_YAML_VERIFIED",
+		"DRONE_YAML_SIGNED",
+		"DRONE_BRANCH",
+		"DRONE_COMMIT",
+		"DRONE_VERSION",
+		"DRONE_PULL_REQUEST",
+		"DRONE_PREV_BUILD_STATUS",
+		"DRONE_PREV_BUILD_NUMBER",
+		"DRONE_PREV_COMMIT_SHA",
+	}
+	// matrix values are defined in the Yaml configuration.
+	for key := range w.Job.Environment {
+		safe = append(safe, key)
+	}
+	return safe
+}
+
 var pullRegexp = regexp.MustCompile("\\d+")
+
+// targetBranch returns the branch targeted by the pull request, which is the
+// destination of the refspec, or the build branch if the refspec does not
+// include a destination.
+func targetBranch(w *drone.Payload) string {
+	if parts := strings.SplitN(w.Build.Refspec, ":", 2); len(parts) == 2 && parts[1] != "" {
+		return parts[1]
+	}
+	return w.Build.Branch
+}
+
+// log writes the line of console output to the logger and emits the line as
+// a build event.
+func (a *Agent) log(w *drone.Payload, line *build.Line) {
+	a.Logger(line)
+	a.emit(w, &event.Event{
+		Type: event.StepLine,
+		Step: line.Step,
+		Name: line.Proc,
+		Line: line,
+	})
+}
+
+// flush logs the lines of console output buffered in the pipe once the
+// pipeline is done, such as the failure of the last step.
+func (a *Agent) flush(w *drone.Payload, pipeline *build.Pipeline) {
+	for {
+		select {
+		case line := <-pipeline.Pipe():
+			a.log(w, line)
+		default:
+			return
+		}
+	}
+}
+
+// emit sends the build event to the event handler, if configured.
+func (a *Agent) emit(w *drone.Payload, e *event.Event) {
+	if a.Events == nil {
+		return
+	}
+	e.Job = w.Job.ID
+	e.Time = time.Now().Unix()
+	a.Events(e)
+}
+
+// emitFinished emits a step finished event for each result after the first
+// n results that is finished or skipped, and returns the number of results
+// reported so far.
+func (a *Agent) emitFinished(w *drone.Payload, results []*build.Result, n int) int {
+	for ; n < len(results); n++ {
+		result := results[n]
+		if !result.Skipped && result.Finished.IsZero() {
+			break
+		}
+		e := &event.Event{
+			Type:    event.StepFinished,
+			Step:    result.ID,
+			Name:    result.Name,
+			Skipped: result.Skipped,
+		}
+		if result.Err != nil {
+			e.Error = result.Err.Error()
+		}
+		if exitErr, ok := result.Err.(*build.ExitError); ok {
+			e.ExitCode = exitErr.Code
+		}
+		a.emit(w, e)
+	}
+	return n
+}
+
+// isFork returns true if the build is a pull request opened from a fork,
+// which is cloned from a remote other than the repository.
+func isFork(w *drone.Payload) bool {
+	return w.Build.Event == drone.EventPull &&
+		w.Build.Remote != "" &&
+		w.Build.Remote != w.Repo.Clone
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-exec/yaml/transform"
+	"github.com/drone/drone-go/drone"
+	"github.com/franela/goblin"
+)
+
+func TestTree(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Tree", func() {
+
+		// deploy returns the plugin step of the transformed sample payload.
+		deploy := func(conf *yaml.Config) *yaml.Container {
+			for _, c := range conf.Pipeline {
+				if c.Name == "deploy" {
+					return c
+				}
+			}
+			return nil
+		}
+
+		g.It("should inject the build and commit links into plugin steps", func() {
+			payload := samplePayload()
+			payload.System.Link = "https://drone.example.com/"
+			payload.Build.Link = "https://github.com/octocat/hello-world/commit/762941318ee16e59dabbacb1b4049eec22f0d303"
+			payload.Build.Commit = "762941318ee16e59dabbacb1b4049eec22f0d303"
+			payload.Build.Author = "octocat"
+			payload.Build.Message = "Update the readme"
+
+			a := &Agent{Engine: &mockEngine{}, Replay: true}
+			conf, err := a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+
+			env := deploy(conf).Environment
+			g.Assert(env["DRONE_BUILD_LINK"]).Equal("https://drone.example.com/octocat/hello-world/1")
+			g.Assert(env["DRONE_COMMIT_LINK"]).Equal(payload.Build.Link)
+			g.Assert(env["DRONE_COMMIT_SHA"]).Equal(payload.Build.Commit)
+			g.Assert(env["DRONE_COMMIT_AUTHOR"]).Equal("octocat")
+			g.Assert(env["DRONE_COMMIT_MESSAGE"]).Equal("Update the readme")
+		})
+
+		g.It("should omit unknown links from plugin steps", func() {
+			a := &Agent{Engine: &mockEngine{}, Replay: true}
+			conf, err := a.Tree(samplePayload())
+			g.Assert(err == nil).IsTrue()
+
+			env := deploy(conf).Environment
+			_, ok := env["DRONE_BUILD_LINK"]
+			g.Assert(ok).IsFalse()
+			_, ok = env["DRONE_COMMIT_LINK"]
+			g.Assert(ok).IsFalse()
+			g.Assert(env["DRONE_BUILD_NUMBER"]).Equal("1")
+		})
+
+		g.It("should not set a platform the yaml does not define", func() {
+			a := &Agent{Engine: &mockEngine{}, Replay: true, Platform: "linux/amd64"}
+			conf, err := a.Tree(samplePayload())
+			g.Assert(err == nil).IsTrue()
+			g.Assert(deploy(conf).Platform).Equal("")
+		})
+
+		g.It("should inject branch restricted secrets only on matching branches", func() {
+			payload := samplePayload()
+			payload.Yaml += "secrets:\n  DEPLOY_KEY:\n    branch: master\n"
+			payload.Build.Verified = true
+			payload.Secrets = []*drone.Secret{
+				{Name: "DEPLOY_KEY", Value: "production", Images: []string{"plugins/*"}, Events: []string{"push"}},
+			}
+
+			a := &Agent{Engine: &mockEngine{}, Replay: true}
+			conf, err := a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(deploy(conf).Environment["DEPLOY_KEY"]).Equal("production")
+
+			payload.Build.Branch = "feature/login"
+			conf, err = a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			_, ok := deploy(conf).Environment["DEPLOY_KEY"]
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("should explain the steps disabled by the repository filter", func() {
+			payload := samplePayload()
+			payload.Yaml += "    when:\n      repo: drone/*\n"
+
+			var changes []string
+			a := &Agent{Engine: &mockEngine{}, Replay: true, Explain: func(change *transform.Change) {
+				if change.Step == "deploy" {
+					changes = append(changes, change.String())
+				}
+			}}
+			_, err := a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(changes[0]).Equal("deploy: step disabled by RepoFilter")
+		})
+
+		g.It("should scope the images built by untrusted repositories", func() {
+			payload := samplePayload()
+			payload.Yaml = "pipeline:\n  build:\n    image: golang:1.5\n    image_build: .\n  test:\n    image: golang:1.5\n    commands: [ go test ]\n"
+
+			a := &Agent{Engine: &mockEngine{}, Replay: true}
+			conf, err := a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			var images []string
+			for _, c := range conf.Pipeline {
+				if c.Name == "build" || c.Name == "test" {
+					images = append(images, c.Image)
+				}
+			}
+			g.Assert(images[0] == "golang:1.5").IsFalse()
+			g.Assert(images[1]).Equal(images[0])
+
+			payload.Repo.IsTrusted = true
+			conf, err = a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			for _, c := range conf.Pipeline {
+				if c.Name == "build" {
+					g.Assert(c.Image).Equal("golang:1.5")
+				}
+			}
+		})
+
+		g.It("should not change the workspace permissions of local builds", func() {
+			payload := samplePayload()
+			payload.Yaml = "pipeline:\n  test:\n    image: golang:1.5\n    user: \"1000\"\n    commands: [ go test ]\n"
+
+			hasPermissions := func(conf *yaml.Config) bool {
+				for _, c := range conf.Pipeline {
+					if c.Name == "permissions" {
+						return true
+					}
+				}
+				return false
+			}
+			a := &Agent{Engine: &mockEngine{}, Replay: true}
+			conf, err := a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(hasPermissions(conf)).IsTrue()
+
+			a.Local = "/home/octocat/hello-world"
+			conf, err = a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(hasPermissions(conf)).IsFalse()
+		})
+
+		g.It("should require tagged yaml images in strict mode", func() {
+			payload := samplePayload()
+			a := &Agent{Engine: &mockEngine{}, Replay: true, StrictImages: true}
+			_, err := a.Tree(payload)
+			g.Assert(err.Error()).Equal("Cannot use image golang of step test, strict images require a tag or digest")
+
+			payload.Yaml = "pipeline:\n  test:\n    image: golang:1.5\n    commands: [ go test ]\n"
+			_, err = a.Tree(payload)
+			g.Assert(err == nil).IsTrue()
+		})
+	})
+}
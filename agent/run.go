@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/yaml/matrix"
+	"github.com/drone/drone-go/drone"
+	"golang.org/x/net/context"
+)
+
+// DefaultTimeout is the inactivity timeout of a build run when the agent does
+// not define a timeout.
+const DefaultTimeout = 15 * time.Minute
+
+// Options configures a build run.
+type Options struct {
+	// Agent configures the build agent, which must define the engine. The
+	// updater and logger default to no-ops if not set. The agent is copied
+	// and never modified by the run.
+	Agent *Agent
+}
+
+// Result is the result of a build run.
+type Result struct {
+	Status   string          // build status, such as drone.StatusSuccess
+	ExitCode int             // build exit code
+	Steps    []*build.Result // step results, in order
+	Err      error           // build error, if any
+}
+
+// Run runs the build payload and returns the build result, without exiting
+// the process, for programs that embed the agent. The build is cancelled when
+// the context is done. The result is returned even if the build fails, along
+// with the build error.
+func Run(ctx context.Context, payload *drone.Payload, opts Options) (*Result, error) {
+	if opts.Agent == nil || opts.Agent.Engine == nil {
+		return nil, fmt.Errorf("Cannot run build, no container engine configured")
+	}
+	a := *opts.Agent
+	if a.Update == nil {
+		a.Update = NoopUpdateFunc
+	}
+	if a.Logger == nil {
+		a.Logger = func(*build.Line) {}
+	}
+	if a.Timeout == 0 {
+		a.Timeout = DefaultTimeout
+	}
+
+	result := new(Result)
+	report := a.Report
+	a.Report = func(w *drone.Payload, results []*build.Result) {
+		result.Steps = results
+		if report != nil {
+			report(w, results)
+		}
+	}
+
+	// the build is cancelled using the cancel channel of the agent, which
+	// is signaled once the context is done.
+	cancel := make(chan bool, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel <- true
+		case <-done:
+		}
+	}()
+
+	err := a.Run(payload, cancel)
+	result.Status = payload.Job.Status
+	result.ExitCode = payload.Job.ExitCode
+	result.Err = err
+	return result, err
+}
+
+// RunMatrix runs the build payload once for each matrix combination, with the
+// combination values as the job environment, and returns the result of each
+// combination in order. Up to parallel combinations run at a time. An error is
+// returned if any combination fails.
+func RunMatrix(ctx context.Context, payload *drone.Payload, axes []matrix.Axis, parallel int, opts Options) ([]*Result, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]*Result, len(axes))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for i, axis := range axes {
+		job := *payload.Job
+		job.Number = i + 1
+		job.Environment = axis
+		w := *payload
+		w.Job = &job
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, w *drone.Payload) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], _ = Run(ctx, w, opts)
+		}(i, &w)
+	}
+	wg.Wait()
+
+	var failed int
+	for _, res := range results {
+		if res == nil || res.Err != nil {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return results, fmt.Errorf("%d of %d matrix builds failed", failed, len(axes))
+	}
+	return results, nil
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-exec/yaml/matrix"
+	"github.com/drone/drone-go/drone"
+	"github.com/franela/goblin"
+	"golang.org/x/net/context"
+)
+
+func TestRun(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Run", func() {
+
+		g.It("should return the build result", func() {
+			engine := &mockEngine{exit: map[string]int{}}
+			res, err := Run(context.Background(), samplePayload(), Options{
+				Agent: &Agent{Engine: engine},
+			})
+			g.Assert(err == nil).IsTrue()
+			g.Assert(res.Status).Equal(drone.StatusSuccess)
+			g.Assert(res.ExitCode).Equal(0)
+			g.Assert(len(res.Steps)).Equal(4)
+			g.Assert(res.Steps[0].Name).Equal("ambassador")
+			g.Assert(res.Steps[1].Name).Equal("clone")
+			g.Assert(res.Steps[2].Name).Equal("test")
+			g.Assert(res.Steps[3].Name).Equal("deploy")
+		})
+
+		g.It("should return the failed build result", func() {
+			engine := &mockEngine{exit: map[string]int{"test": 2}}
+			res, err := Run(context.Background(), samplePayload(), Options{
+				Agent: &Agent{Engine: engine},
+			})
+			g.Assert(err != nil).IsTrue()
+			g.Assert(res.Status).Equal(drone.StatusFailure)
+			g.Assert(res.ExitCode).Equal(2)
+			g.Assert(res.Steps[2].Err != nil).IsTrue()
+			g.Assert(res.Steps[3].Skipped).IsTrue()
+		})
+
+		g.It("should return the result of an invalid build", func() {
+			payload := samplePayload()
+			payload.Yaml = "pipeline: [ invalid"
+			res, err := Run(context.Background(), payload, Options{
+				Agent: &Agent{Engine: &mockEngine{}},
+			})
+			g.Assert(err != nil).IsTrue()
+			g.Assert(res.Status).Equal(drone.StatusError)
+			g.Assert(res.ExitCode).Equal(255)
+			g.Assert(len(res.Steps)).Equal(0)
+		})
+
+		g.It("should call the agent reporter", func() {
+			var reported []*build.Result
+			agent := &Agent{
+				Engine: &mockEngine{exit: map[string]int{}},
+				Report: func(_ *drone.Payload, results []*build.Result) {
+					reported = results
+				},
+			}
+			res, _ := Run(context.Background(), samplePayload(), Options{Agent: agent})
+			g.Assert(len(reported)).Equal(len(res.Steps))
+		})
+
+		g.It("should run each matrix combination", func() {
+			engine := &mockEngine{exit: map[string]int{}}
+			axes := []matrix.Axis{{"GO_VERSION": "1.4"}, {"GO_VERSION": "1.5"}, {"GO_VERSION": "1.6"}}
+			results, err := RunMatrix(context.Background(), samplePayload(), axes, 2, Options{
+				Agent: &Agent{Engine: engine},
+			})
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(3)
+			for _, res := range results {
+				g.Assert(res.Status).Equal(drone.StatusSuccess)
+				g.Assert(len(res.Steps)).Equal(4)
+			}
+			g.Assert(len(engine.started)).Equal(12)
+		})
+
+		g.It("should fail the matrix build when a combination fails", func() {
+			engine := &mockEngine{exit: map[string]int{"GO_VERSION=1.5": 1}}
+			axes := []matrix.Axis{{"GO_VERSION": "1.4"}, {"GO_VERSION": "1.5"}}
+			results, err := RunMatrix(context.Background(), samplePayload(), axes, 1, Options{
+				Agent: &Agent{Engine: engine},
+			})
+			g.Assert(err.Error()).Equal("1 of 2 matrix builds failed")
+			g.Assert(results[0].Status).Equal(drone.StatusSuccess)
+			g.Assert(results[1].Status).Equal(drone.StatusFailure)
+			g.Assert(results[1].ExitCode).Equal(1)
+		})
+
+		g.It("should error without an engine", func() {
+			_, err := Run(context.Background(), samplePayload(), Options{Agent: &Agent{}})
+			g.Assert(err.Error()).Equal("Cannot run build, no container engine configured")
+		})
+	})
+}
+
+func samplePayload() *drone.Payload {
+	return &drone.Payload{
+		Yaml: `
+pipeline:
+  test:
+    image: golang
+    commands: [ go test ]
+  deploy:
+    image: plugins/docker
+`,
+		Repo:   &drone.Repo{Owner: "octocat", Name: "hello-world", FullName: "octocat/hello-world", Kind: "git", Timeout: 60},
+		Build:  &drone.Build{Number: 1, Event: drone.EventPush, Branch: "master"},
+		Job:    &drone.Job{Number: 1},
+		System: &drone.System{},
+	}
+}
+
+// mockEngine is a fake container engine. Containers exit with the configured
+// exit code of the step name, or of a KEY=VALUE variable of the container
+// environment of the test step. Started steps are recorded by name.
+type mockEngine struct {
+	sync.Mutex
+	exit    map[string]int
+	codes   map[string]int
+	started []string
+}
+
+func (e *mockEngine) ContainerStart(c *yaml.Container) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.codes == nil {
+		e.codes = map[string]int{}
+	}
+	e.codes[c.ID] = e.exit[c.Name]
+	for k, v := range c.Environment {
+		if code, ok := e.exit[k+"="+v]; ok && c.Name == "test" {
+			e.codes[c.ID] = code
+		}
+	}
+	e.started = append(e.started, c.Name)
+	return c.ID, nil
+}
+
+func (e *mockEngine) ContainerStop(string) error {
+	return nil
+}
+
+func (e *mockEngine) ContainerRemove(string) error {
+	return nil
+}
+
+func (e *mockEngine) ContainerWait(id string) (*build.State, error) {
+	e.Lock()
+	defer e.Unlock()
+	return &build.State{ExitCode: e.codes[id]}, nil
+}
+
+func (e *mockEngine) ContainerLogs(string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func (e *mockEngine) ImageBuild(*yaml.Container) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func (e *mockEngine) ImageRemove(string) error {
+	return nil
+}
+
+func (e *mockEngine) NetworkCreate(*yaml.Network) error {
+	return nil
+}
+
+func (e *mockEngine) NetworkRemove(string) error {
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/drone/drone-exec/build"
 	"github.com/drone/drone-exec/client"
+	"github.com/drone/drone-exec/event"
+	"github.com/drone/drone-exec/yaml/transform"
 	"github.com/drone/drone-go/drone"
 )
 
@@ -19,12 +21,42 @@ type UpdateFunc func(*drone.Payload)
 // LoggerFunc handles buid pipeline logging updates.
 type LoggerFunc func(*build.Line)
 
+// RecordFunc handles recording the resolved build payload.
+type RecordFunc func(*drone.Payload)
+
+// ExplainFunc handles the changes made to the build steps by the Yaml
+// transforms.
+type ExplainFunc func(*transform.Change)
+
+// ReportFunc handles reporting the results of a completed build.
+type ReportFunc func(*drone.Payload, []*build.Result)
+
+// EventFunc handles structured build events.
+type EventFunc func(*event.Event)
+
 var NoopUpdateFunc = func(*drone.Payload) {}
 
 var TermLoggerFunc = func(line *build.Line) {
 	fmt.Println(line)
 }
 
+// NewTermLogger returns a logger that writes the build output to the
+// terminal using the timestamp format, either build.TimeRelative or
+// build.TimeRFC3339.
+func NewTermLogger(format string) LoggerFunc {
+	return func(line *build.Line) {
+		fmt.Println(line.Format(format))
+	}
+}
+
+// NewPrefixLogger returns a logger that writes the build output to the
+// terminal with each line prefixed with the step name in the prefix format.
+func NewPrefixLogger(prefix string) LoggerFunc {
+	return func(line *build.Line) {
+		fmt.Println(line.Prefix(prefix))
+	}
+}
+
 // NewClientUpdater returns an updater that sends updated build details
 // to the drone server.
 func NewClientUpdater(client client.Client) UpdateFunc {
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/yaml"
+)
+
+// archiveImage is the default image used to archive the workspace.
+const archiveImage = "busybox:latest"
+
+// archivePath is the path of the archive in the helper container.
+const archivePath = "/tmp/drone_archive.tar.gz"
+
+// Archiver archives the workspace of a failed build and uploads the archive
+// for post-mortem debugging. The workspace is archived by a helper container
+// that shares the volumes of the pipeline and writes a gzipped tarball to a
+// file, which is copied from the container once the helper exits. The engine
+// must implement build.ContainerCopier, since the container logs do not
+// preserve binary output.
+type Archiver struct {
+	Engine   build.Engine
+	Uploader Uploader
+
+	// Exclude defines the patterns of the workspace files, such as
+	// secret files, excluded from the archive.
+	Exclude []string
+
+	// MaxSize defines the maximum size of the archive in bytes. The upload
+	// fails when the archive exceeds the size. The size is not limited by
+	// default.
+	MaxSize int64
+
+	// Image defines the image of the helper container, which must include
+	// tar and gzip. The default image is used if not set.
+	Image string
+}
+
+// Archive archives the workspace and uploads the archive with the given name
+// if the build failed with an error. Successful builds are not archived. It
+// must be called before the pipeline is torn down.
+func (a *Archiver) Archive(name string, spec *yaml.Config, err error) error {
+	if err == nil || len(spec.Pipeline) == 0 || spec.Workspace == nil {
+		return nil
+	}
+	copier, ok := a.Engine.(build.ContainerCopier)
+	if !ok {
+		return build.ErrContainerCopier
+	}
+	image := a.Image
+	if image == "" {
+		image = archiveImage
+	}
+
+	helper := &yaml.Container{
+		ID:          spec.Pipeline[0].ID + "_archive",
+		Name:        "archive",
+		Image:       image,
+		Entrypoint:  []string{"/bin/sh", "-c"},
+		Command:     []string{command(spec.Workspace.Path, a.Exclude)},
+		VolumesFrom: spec.Pipeline[0].VolumesFrom,
+		Environment: map[string]string{},
+	}
+	id, err := a.Engine.ContainerStart(helper)
+	if err != nil {
+		return err
+	}
+	defer a.Engine.ContainerRemove(id)
+
+	state, err := a.Engine.ContainerWait(id)
+	if err != nil {
+		return err
+	}
+	if state.ExitCode != 0 {
+		return fmt.Errorf("Cannot archive the workspace, exit code %d", state.ExitCode)
+	}
+
+	// the archive is copied from the container as a tar stream, which
+	// holds the archive file as its only entry.
+	rc, err := copier.ContainerCopy(id, archivePath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		return fmt.Errorf("Cannot read the workspace archive. %s", err)
+	}
+	return a.Uploader.Upload(name, &limitReader{r: tr, n: a.MaxSize})
+}
+
+// command returns the shell command that archives the workspace path to the
+// archive file, excluding the files matching the patterns.
+func command(path string, exclude []string) string {
+	parts := []string{"tar", "-czf", archivePath, "-C", quote(path)}
+	for _, pattern := range exclude {
+		parts = append(parts, "--exclude="+quote(pattern))
+	}
+	parts = append(parts, ".", "2>/dev/null")
+	return strings.Join(parts, " ")
+}
+
+// quote returns the string quoted for the shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// limitReader reads from r and returns an error once more than n bytes are
+// read. The size is not limited if n is zero.
+type limitReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.n > 0 && l.read > l.n {
+		return n, fmt.Errorf("Workspace archive exceeds the maximum size of %d bytes", l.n)
+	}
+	return n, err
+}
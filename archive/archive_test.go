@@ -0,0 +1,196 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+func TestArchiver(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Workspace archiver", func() {
+
+		spec := &yaml.Config{
+			Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src/github.com/octocat/hello-world"},
+			Pipeline: []*yaml.Container{
+				{ID: "drone_1", Name: "clone", VolumesFrom: []string{"drone_ambassador"}},
+			},
+		}
+
+		g.It("should archive and upload the workspace on failure", func() {
+			engine := &fakeEngine{archive: "archive contents"}
+			uploader := &fakeUploader{}
+			archiver := &Archiver{Engine: engine, Uploader: uploader, Exclude: []string{"*.pem"}}
+
+			err := archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, errors.New("exit code 1"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(uploader.name).Equal("octocat_hello-world_1.1.tar.gz")
+			g.Assert(uploader.body).Equal("archive contents")
+
+			helper := engine.started
+			g.Assert(helper.Image).Equal("busybox:latest")
+			g.Assert(helper.VolumesFrom).Equal([]string{"drone_ambassador"})
+			g.Assert(helper.Command).Equal([]string{
+				"tar -czf /tmp/drone_archive.tar.gz -C '/drone/src/github.com/octocat/hello-world' --exclude='*.pem' . 2>/dev/null",
+			})
+			g.Assert(engine.copied).Equal("/tmp/drone_archive.tar.gz")
+			g.Assert(engine.removed).Equal("drone_1_archive")
+		})
+
+		g.It("should upload binary archives unchanged", func() {
+			archive := string([]byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe, 0x0a, 0x0d, 0x80, 0x00, 0xc3})
+			engine := &fakeEngine{archive: archive}
+			uploader := &fakeUploader{}
+			archiver := &Archiver{Engine: engine, Uploader: uploader}
+
+			err := archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, errors.New("exit code 1"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert([]byte(uploader.body)).Equal([]byte(archive))
+		})
+
+		g.It("should fail when the archive container fails", func() {
+			engine := &fakeEngine{archive: "archive contents", exit: 2}
+			uploader := &fakeUploader{}
+			archiver := &Archiver{Engine: engine, Uploader: uploader}
+
+			err := archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, errors.New("exit code 1"))
+			g.Assert(err.Error()).Equal("Cannot archive the workspace, exit code 2")
+			g.Assert(uploader.name).Equal("")
+		})
+
+		g.It("should archive the workspace with the configured image", func() {
+			engine := &fakeEngine{archive: "archive contents"}
+			archiver := &Archiver{Engine: engine, Uploader: &fakeUploader{}, Image: "registry.internal/library/busybox:1.25"}
+
+			archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, errors.New("exit code 1"))
+			g.Assert(engine.started.Image).Equal("registry.internal/library/busybox:1.25")
+		})
+
+		g.It("should not archive the workspace on success", func() {
+			engine := &fakeEngine{archive: "archive contents"}
+			uploader := &fakeUploader{}
+			archiver := &Archiver{Engine: engine, Uploader: uploader}
+
+			err := archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, nil)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(engine.started == nil).IsTrue("expects no archive container")
+			g.Assert(uploader.name).Equal("")
+		})
+
+		g.It("should fail when the archive exceeds the maximum size", func() {
+			engine := &fakeEngine{archive: "archive contents"}
+			uploader := &fakeUploader{}
+			archiver := &Archiver{Engine: engine, Uploader: uploader, MaxSize: 4}
+
+			err := archiver.Archive("octocat_hello-world_1.1.tar.gz", spec, errors.New("exit code 1"))
+			g.Assert(err != nil).IsTrue()
+			g.Assert(err.Error()).Equal("Workspace archive exceeds the maximum size of 4 bytes")
+			g.Assert(engine.removed).Equal("drone_1_archive")
+		})
+
+		g.It("should write archives to a local directory", func() {
+			dir, _ := ioutil.TempDir("", "drone_archive_")
+			defer os.RemoveAll(dir)
+
+			err := NewUploader(dir).Upload("build.tar.gz", strings.NewReader("archive contents"))
+			g.Assert(err == nil).IsTrue()
+			out, _ := ioutil.ReadFile(filepath.Join(dir, "build.tar.gz"))
+			g.Assert(string(out)).Equal("archive contents")
+		})
+
+		g.It("should remove partial archives from a local directory", func() {
+			dir, _ := ioutil.TempDir("", "drone_archive_")
+			defer os.RemoveAll(dir)
+
+			r := &limitReader{r: strings.NewReader("archive contents"), n: 4}
+			err := NewUploader(dir).Upload("build.tar.gz", r)
+			g.Assert(err != nil).IsTrue()
+			_, err = os.Stat(filepath.Join(dir, "build.tar.gz"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+	})
+}
+
+// fakeUploader records the uploaded archive.
+type fakeUploader struct {
+	name string
+	body string
+}
+
+func (u *fakeUploader) Upload(name string, r io.Reader) error {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return err
+	}
+	u.name, u.body = name, buf.String()
+	return nil
+}
+
+// fakeEngine records the started and removed container and the path copied
+// from the container, and returns a tar stream of the configured archive.
+type fakeEngine struct {
+	archive string
+	exit    int
+	started *yaml.Container
+	removed string
+	copied  string
+}
+
+func (e *fakeEngine) ContainerStart(c *yaml.Container) (string, error) {
+	e.started = c
+	return c.ID, nil
+}
+
+func (e *fakeEngine) ContainerStop(string) error {
+	return nil
+}
+
+func (e *fakeEngine) ContainerRemove(id string) error {
+	e.removed = id
+	return nil
+}
+
+func (e *fakeEngine) ContainerWait(string) (*build.State, error) {
+	return &build.State{ExitCode: e.exit}, nil
+}
+
+func (e *fakeEngine) ContainerLogs(string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e *fakeEngine) ContainerCopy(id, path string) (io.ReadCloser, error) {
+	e.copied = path
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: filepath.Base(path), Mode: 0644, Size: int64(len(e.archive))})
+	tw.Write([]byte(e.archive))
+	tw.Close()
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (e *fakeEngine) ImageBuild(*yaml.Container) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e *fakeEngine) ImageRemove(string) error {
+	return nil
+}
+
+func (e *fakeEngine) NetworkCreate(*yaml.Network) error {
+	return nil
+}
+
+func (e *fakeEngine) NetworkRemove(string) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Uploader uploads an archive.
+type Uploader interface {
+	// Upload uploads the archive with the given name, reading the contents
+	// until EOF. The upload fails if the reader returns an error.
+	Upload(name string, r io.Reader) error
+}
+
+// NewUploader returns an Uploader for the destination, which is either an
+// http or https url, such as an S3 compatible endpoint, or a local directory.
+func NewUploader(dest string) Uploader {
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		return &httpUploader{url: strings.TrimRight(dest, "/"), client: http.DefaultClient}
+	}
+	return &dirUploader{dir: dest}
+}
+
+// dirUploader writes the archives to a local directory.
+type dirUploader struct {
+	dir string
+}
+
+func (u *dirUploader) Upload(name string, r io.Reader) error {
+	path := filepath.Join(u.dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// httpUploader uploads the archives to an http endpoint using a PUT request
+// to the endpoint url followed by the archive name.
+type httpUploader struct {
+	url    string
+	client *http.Client
+}
+
+func (u *httpUploader) Upload(name string, r io.Reader) error {
+	req, err := http.NewRequest("PUT", u.url+"/"+name, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("archive upload returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ANSI escape sequences used to colorize the banner.
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// Banner returns a summary of the build result for display in a terminal,
+// including the overall status, the names of the failed steps and the total
+// duration, and the peak resource usage of the steps, if sampled. The status
+// is colorized if color is true.
+func Banner(results []*Result, err error, color bool) string {
+	var failed []string
+	var started, finished time.Time
+	for _, result := range results {
+		if result.Skipped {
+			continue
+		}
+		if result.Err != nil {
+			failed = append(failed, result.Name)
+		}
+		if started.IsZero() || result.Started.Before(started) {
+			started = result.Started
+		}
+		if result.Finished.After(finished) {
+			finished = result.Finished
+		}
+	}
+
+	status, code := "SUCCESS", colorGreen
+	if err != nil || len(failed) != 0 {
+		status, code = "FAILURE", colorRed
+	}
+	if color {
+		status = code + status + colorReset
+	}
+
+	var duration time.Duration
+	if !finished.IsZero() {
+		duration = finished.Sub(started)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bannerRule)
+	fmt.Fprintf(&buf, "Build %s\n", status)
+	if len(failed) != 0 {
+		fmt.Fprintf(&buf, "Failed steps: %s\n", strings.Join(failed, ", "))
+	}
+	if err != nil && len(failed) == 0 {
+		fmt.Fprintf(&buf, "Error: %s\n", err)
+	}
+	fmt.Fprintf(&buf, "Duration: %v\n", duration)
+	for _, result := range results {
+		if result.Stats != nil {
+			fmt.Fprintf(&buf, "Peak usage of %s: %dMB memory, %.0f%% cpu\n",
+				result.Name, result.Stats.Memory/1000000, result.Stats.CPU)
+		}
+	}
+	buf.WriteString(bannerRule)
+	return buf.String()
+}
+
+var bannerRule = strings.Repeat("=", 40) + "\n"
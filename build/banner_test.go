@@ -0,0 +1,89 @@
+package build
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+)
+
+func TestBanner(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Banner", func() {
+
+		now := time.Now()
+
+		g.It("should summarize a successful build", func() {
+			results := []*Result{
+				{Name: "clone", Started: now, Finished: now.Add(5 * time.Second)},
+				{Name: "test", Started: now.Add(5 * time.Second), Finished: now.Add(72 * time.Second)},
+				{Name: "deploy", Skipped: true},
+			}
+			g.Assert(Banner(results, nil, false)).Equal(
+				bannerRule +
+					"Build SUCCESS\n" +
+					"Duration: 1m12s\n" +
+					bannerRule,
+			)
+		})
+
+		g.It("should summarize a failed build", func() {
+			err := &ExitError{Name: "test", Code: 1}
+			results := []*Result{
+				{Name: "clone", Started: now, Finished: now.Add(2 * time.Second)},
+				{Name: "test", Started: now.Add(2 * time.Second), Finished: now.Add(10 * time.Second), Err: err},
+				{Name: "lint", Started: now.Add(10 * time.Second), Finished: now.Add(12 * time.Second), Err: err},
+			}
+			g.Assert(Banner(results, err, false)).Equal(
+				bannerRule +
+					"Build FAILURE\n" +
+					"Failed steps: test, lint\n" +
+					"Duration: 12s\n" +
+					bannerRule,
+			)
+		})
+
+		g.It("should summarize the peak resource usage", func() {
+			results := []*Result{
+				{Name: "clone", Started: now, Finished: now.Add(time.Second)},
+				{Name: "test", Started: now.Add(time.Second), Finished: now.Add(2 * time.Second), Stats: &Stats{Memory: 512000000, CPU: 85.2}},
+			}
+			g.Assert(Banner(results, nil, false)).Equal(
+				bannerRule +
+					"Build SUCCESS\n" +
+					"Duration: 2s\n" +
+					"Peak usage of test: 512MB memory, 85% cpu\n" +
+					bannerRule,
+			)
+		})
+
+		g.It("should summarize a cancelled build", func() {
+			err := errors.New("termination request received, build cancelled")
+			g.Assert(Banner(nil, err, false)).Equal(
+				bannerRule +
+					"Build FAILURE\n" +
+					"Error: termination request received, build cancelled\n" +
+					"Duration: 0s\n" +
+					bannerRule,
+			)
+		})
+
+		g.It("should colorize the status", func() {
+			g.Assert(Banner(nil, nil, true)).Equal(
+				bannerRule +
+					"Build \x1b[32mSUCCESS\x1b[0m\n" +
+					"Duration: 0s\n" +
+					bannerRule,
+			)
+			g.Assert(Banner(nil, errors.New("failed"), true)).Equal(
+				bannerRule +
+					"Build \x1b[31mFAILURE\x1b[0m\n" +
+					"Error: failed\n" +
+					"Duration: 0s\n" +
+					bannerRule,
+			)
+		})
+	})
+}
@@ -1,35 +1,140 @@
 package build
 
-import "github.com/drone/drone-exec/yaml"
+import (
+	"bufio"
+	"strings"
+	"time"
+
+	"github.com/drone/drone-exec/yaml"
+	"golang.org/x/net/context"
+)
 
 // Config defines the configuration for creating the Pipeline.
 type Config struct {
 	Engine Engine
 
+	// Context defines the parent context of the pipeline. The pipeline is
+	// cancelled when the context is done, as if stopped. The background
+	// context is used by default.
+	Context context.Context
+
 	// Buffer defines the size of the buffer for the channel to which the
 	// console output is streamed.
 	Buffer uint
+
+	// Backoff defines the base duration to wait before retrying a failed
+	// container. The duration is multiplied by the retry attempt.
+	Backoff time.Duration
+
+	// LineSize defines the maximum size of a line of console output. Longer
+	// lines are truncated. The default size is 64 kilobytes.
+	LineSize int
+
+	// LineRate defines the maximum number of lines of console output per
+	// second for each step. Excess lines are suppressed. The rate limit is
+	// disabled by default.
+	LineRate int
+
+	// MaxLines defines the maximum number of lines of console output for
+	// the entire build. The build is cancelled with a LogLimitError when
+	// the limit is exceeded. The limit is disabled by default.
+	MaxLines int
+
+	// Preserve defines the node types, yaml.NodeServices or yaml.NodeBuild,
+	// whose containers are not removed on teardown when the build fails, so
+	// they can be inspected for debugging.
+	Preserve []string
+
+	// DetachedLogs defines a directory to which the console output of
+	// detached containers is written, in a subdirectory per build with one
+	// file per container, instead of the build output pipe. The output is streamed to the pipe by default.
+	DetachedLogs string
+
+	// PruneImages removes the images pulled by the engine for the build on
+	// teardown, except images matching the KeepImages patterns. Images are
+	// only pruned if the engine implements ImageTracker.
+	PruneImages bool
+	KeepImages  []string
+
+	// StopDetached stops the service containers as soon as a step fails
+	// instead of on teardown, to free their resources. Services whose network
+	// is shared by other containers, such as the ambassador, and detached
+	// pipeline steps are not stopped. The containers are still removed on
+	// teardown. Disabled by default.
+	StopDetached bool
+
+	// Stats samples the resource usage of each step while it runs, and
+	// records the peak memory and cpu usage in the step result. Usage is
+	// only sampled if the engine implements StatsCollector.
+	Stats bool
 }
 
 // Pipeline creates a build Pipeline using the specific configuration for
 // the given Yaml specification.
 func (c *Config) Pipeline(spec *yaml.Config) *Pipeline {
 
+	lineSize := c.LineSize
+	if lineSize <= 0 {
+		lineSize = bufio.MaxScanTokenSize
+	}
+
+	parent := c.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+
 	pipeline := Pipeline{
-		engine: c.Engine,
-		pipe:   make(chan *Line, c.Buffer),
-		next:   make(chan error),
-		done:   make(chan error),
+		ctx:      ctx,
+		cancel:   cancel,
+		conf:     spec,
+		engine:   c.Engine,
+		backoff:  c.Backoff,
+		lineSize: lineSize,
+		lineRate: c.LineRate,
+		maxLines: c.MaxLines,
+		detached: c.DetachedLogs,
+		prune:    c.PruneImages,
+		keep:     c.KeepImages,
+		stop:     c.StopDetached,
+		stats:    c.Stats,
+		usage:    map[*yaml.Container]*Stats{},
+		services: map[string]string{},
+		caches:   map[string]bool{},
+		shared:   map[string]bool{},
+		nodes:    map[*yaml.Container]string{},
+		preserve: map[string]bool{},
+		pipe:     make(chan *Line, c.Buffer),
+		next:     make(chan error),
+		done:     make(chan error),
+		closed:   make(chan struct{}),
+	}
+
+	for _, node := range c.Preserve {
+		pipeline.preserve[node] = true
 	}
 
 	var containers []*yaml.Container
-	containers = append(containers, spec.Services...)
-	containers = append(containers, spec.Pipeline...)
+	for _, c := range spec.Services {
+		pipeline.nodes[c] = yaml.NodeServices
+		containers = append(containers, c)
+	}
+	for _, c := range spec.Pipeline {
+		pipeline.nodes[c] = yaml.NodeBuild
+		containers = append(containers, c)
+	}
+
+	for _, c := range containers {
+		if strings.HasPrefix(c.Network, "container:") {
+			pipeline.shared[strings.TrimPrefix(c.Network, "container:")] = true
+		}
+	}
 
 	for _, c := range containers {
 		if c.Disabled {
 			continue
 		}
+		pipeline.total++
 		next := &element{Container: c}
 		if pipeline.head == nil {
 			pipeline.head = next
@@ -40,9 +145,16 @@ func (c *Config) Pipeline(spec *yaml.Config) *Pipeline {
 		}
 	}
 
-	go func() {
-		pipeline.next <- nil
-	}()
+	go pipeline.signal(pipeline.next, nil)
+	if c.Context != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				pipeline.Stop()
+			case <-pipeline.closed:
+			}
+		}()
+	}
 
 	return &pipeline
 }
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+// defaultRegistry is the server address of the default Docker registry used
+// by the credential helper protocol.
+const defaultRegistry = "https://index.docker.io/v1/"
+
+// CredentialHelper obtains registry credentials from a Docker credential
+// helper, an external program that returns the credentials for a registry.
+// This is used to authenticate to registries with short-lived tokens, such
+// as ECR and GCR. Credentials are cached for the configured duration.
+type CredentialHelper struct {
+	program string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*credentials
+}
+
+// credentials are the cached registry credentials.
+type credentials struct {
+	auth    *dockerclient.AuthConfig
+	expires time.Time
+}
+
+// NewCredentialHelper returns a CredentialHelper that runs the named helper
+// program, docker-credential-<name>, caching the credentials for ttl.
+func NewCredentialHelper(name string, ttl time.Duration) *CredentialHelper {
+	return &CredentialHelper{
+		program: "docker-credential-" + name,
+		ttl:     ttl,
+		cache:   map[string]*credentials{},
+	}
+}
+
+// Get returns the credentials for the registry, or nil if the helper has no
+// credentials for the registry.
+func (h *CredentialHelper) Get(registry string) (*dockerclient.AuthConfig, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c, ok := h.cache[registry]; ok && time.Now().Before(c.expires) {
+		return c.auth, nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(h.program, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// the helper exits with an error when it does not store any
+		// credentials for the registry.
+		if strings.Contains(stdout.String(), "credentials not found") {
+			h.store(registry, nil)
+			return nil, nil
+		}
+		out := strings.TrimSpace(stdout.String() + stderr.String())
+		if out == "" {
+			out = err.Error()
+		}
+		return nil, fmt.Errorf("Error getting %s credentials from %s. %s", registry, h.program, out)
+	}
+
+	v := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		return nil, fmt.Errorf("Error decoding %s credentials from %s. %s", registry, h.program, err)
+	}
+
+	// identity tokens are returned with the <token> username.
+	auth := &dockerclient.AuthConfig{Username: v.Username, Password: v.Secret}
+	if v.Username == "<token>" {
+		auth = &dockerclient.AuthConfig{RegistryToken: v.Secret}
+	}
+	h.store(registry, auth)
+	return auth, nil
+}
+
+func (h *CredentialHelper) store(registry string, auth *dockerclient.AuthConfig) {
+	h.cache[registry] = &credentials{
+		auth:    auth,
+		expires: time.Now().Add(h.ttl),
+	}
+}
+
+// registryHost returns the registry server address for the image. Images
+// without a registry host are pulled from the default registry.
+func registryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return defaultRegistry
+}
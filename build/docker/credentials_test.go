@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// credentialScript is a fake credential helper that returns credentials for
+// the ecr registry and records each invocation.
+const credentialScript = `#!/bin/sh
+read registry
+echo "$registry" >> "$(dirname "$0")/calls"
+if [ "$registry" = "123456789.dkr.ecr.us-east-1.amazonaws.com" ]; then
+  echo '{"ServerURL":"123456789.dkr.ecr.us-east-1.amazonaws.com","Username":"AWS","Secret":"short-lived-token"}'
+  exit 0
+fi
+if [ "$registry" = "gcr.io" ]; then
+  echo '{"ServerURL":"gcr.io","Username":"<token>","Secret":"identity-token"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`
+
+func newCredentialHelper(t *testing.T, ttl time.Duration) (*CredentialHelper, string) {
+	dir, err := ioutil.TempDir("", "drone_credentials_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "docker-credential-fake")
+	if err := ioutil.WriteFile(path, []byte(credentialScript), 0700); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewCredentialHelper("fake", ttl)
+	helper.program = path
+	return helper, dir
+}
+
+// calls returns the registries the fake credential helper was invoked with.
+func calls(dir string) []string {
+	out, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	return strings.Fields(string(out))
+}
+
+func TestCredentialHelper(t *testing.T) {
+	helper, dir := newCredentialHelper(t, time.Hour)
+	defer os.RemoveAll(dir)
+
+	auth, err := helper.Get("123456789.dkr.ecr.us-east-1.amazonaws.com")
+	if err != nil {
+		t.Fatalf("Wanted credentials, got error %q", err)
+	}
+	if auth.Username != "AWS" || auth.Password != "short-lived-token" {
+		t.Errorf("Wanted credentials AWS:short-lived-token, got %s:%s", auth.Username, auth.Password)
+	}
+
+	auth, err = helper.Get("gcr.io")
+	if err != nil {
+		t.Fatalf("Wanted credentials, got error %q", err)
+	}
+	if auth.RegistryToken != "identity-token" || auth.Username != "" {
+		t.Errorf("Wanted identity token, got %+v", auth)
+	}
+
+	auth, err = helper.Get("quay.io")
+	if err != nil {
+		t.Errorf("Wanted no error for unknown registry, got %q", err)
+	}
+	if auth != nil {
+		t.Errorf("Wanted no credentials for unknown registry, got %+v", auth)
+	}
+}
+
+func TestCredentialHelperCache(t *testing.T) {
+	helper, dir := newCredentialHelper(t, time.Hour)
+	defer os.RemoveAll(dir)
+
+	helper.Get("123456789.dkr.ecr.us-east-1.amazonaws.com")
+	helper.Get("123456789.dkr.ecr.us-east-1.amazonaws.com")
+	helper.Get("quay.io")
+	helper.Get("quay.io")
+	if got := len(calls(dir)); got != 2 {
+		t.Errorf("Wanted credentials cached, got %d helper calls", got)
+	}
+
+	helper.ttl = 0
+	helper.cache = map[string]*credentials{}
+	helper.Get("123456789.dkr.ecr.us-east-1.amazonaws.com")
+	helper.Get("123456789.dkr.ecr.us-east-1.amazonaws.com")
+	if got := len(calls(dir)); got != 4 {
+		t.Errorf("Wanted expired credentials refreshed, got %d helper calls", got)
+	}
+}
+
+func TestCredentialHelperPull(t *testing.T) {
+	helper, dir := newCredentialHelper(t, time.Hour)
+	defer os.RemoveAll(dir)
+
+	client := &fakeClient{}
+	engine := NewClient(client, WithCredentialHelper(helper))
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:    "drone_1",
+		Image: "123456789.dkr.ecr.us-east-1.amazonaws.com/octocat/hello-world",
+		Pull:  true,
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if len(client.pulled) != 1 || client.pulled[0] == nil {
+		t.Fatalf("Wanted image pulled with credentials")
+	}
+	if got := client.pulled[0].Password; got != "short-lived-token" {
+		t.Errorf("Wanted image pulled with the helper credentials, got password %q", got)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	var tests = []struct {
+		image string
+		host  string
+	}{
+		{"golang", defaultRegistry},
+		{"octocat/hello-world:latest", defaultRegistry},
+		{"gcr.io/project/image", "gcr.io"},
+		{"localhost/image", "localhost"},
+		{"registry.internal:5000/octocat/image", "registry.internal:5000"},
+	}
+	for _, test := range tests {
+		if got := registryHost(test.image); got != test.host {
+			t.Errorf("Wanted registry %q for image %s, got %q", test.host, test.image, got)
+		}
+	}
+}
@@ -1,8 +1,20 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/drone/drone-exec/build"
 	"github.com/drone/drone-exec/build/docker/internal"
 	"github.com/drone/drone-exec/yaml"
@@ -12,34 +24,530 @@ import (
 
 type dockerEngine struct {
 	client dockerclient.Client
+
+	// retries defines the number of times waiting for a container is
+	// retried when the connection to the daemon is lost, waiting backoff
+	// between retries.
+	retries int
+	backoff time.Duration
+
+	// watchdog defines the interval after which a pending wait request is
+	// checked by inspecting the container, in case the wait request is
+	// stuck. The watchdog is disabled if zero.
+	watchdog time.Duration
+
+	// pullRetries defines the number of times pulling an image is retried
+	// when the image is not found, waiting pullBackoff between retries,
+	// since a recently pushed image may not have propagated to the
+	// registry yet.
+	pullRetries int
+	pullBackoff time.Duration
+
+	// maxImageSize defines the maximum size in bytes of the images pulled
+	// for unprivileged containers. The limit is disabled if zero.
+	maxImageSize int64
+
+	// volumes tracks the secret file volumes created for each container,
+	// by the container ID returned by the daemon, which are removed with
+	// the container.
+	mu      sync.Mutex
+	volumes map[string][]string
+
+	// names maps the names of the containers with secret file volumes to
+	// the container IDs, since containers are also removed by name.
+	names map[string]string
+
+	// creds obtains the registry credentials for images that do not
+	// define credentials, if configured.
+	creds *CredentialHelper
+
+	// mirrors are the registry mirrors from which images are pulled, in
+	// order, when the image cannot be pulled from its registry.
+	mirrors []string
+
+	// pulled maps the images pulled by the engine to the image reference
+	// pulled, which differs when the image is pulled from a mirror.
+	pulled map[string]string
+
+	// pulls records the containers, by ID, whose image did not exist on the
+	// host and was pulled when the container was started. Pulls are tracked
+	// by container, since the engine is shared by concurrent builds.
+	pulls map[string]bool
+
+	// helperImage is the image of the helper containers, which must include
+	// a shell and standard unix utilities.
+	helperImage string
 }
 
+// contextImage is the default image of the helper containers, which archive
+// the image build context and read and write files in the volumes.
+const contextImage = "busybox:latest"
+
+// errSysctls is returned when the container defines sysctls and the client
+// cannot create containers through the daemon API, since the Docker client
+// does not support configuring sysctls.
+var errSysctls = errors.New("Docker engine does not support sysctls")
+
 func (e *dockerEngine) ContainerStart(container *yaml.Container) (string, error) {
+	if _, ok := e.client.(*dockerclient.DockerClient); !ok && len(container.Sysctls) != 0 {
+		return "", errSysctls
+	}
 	conf := toContainerConfig(container)
 	auth := toAuthConfig(container)
+	if auth == nil && e.creds != nil {
+		var err error
+		auth, err = e.creds.Get(registryHost(container.Image))
+		if err != nil {
+			logrus.Warnf("Cannot get registry credentials for %s. %s", container.Image, err)
+		}
+	}
 
 	// pull the image if it does not exists or if the Container
 	// is configured to always pull a new image.
-	_, err := e.client.InspectImage(container.Image)
+	image, err := e.client.InspectImage(container.Image)
+	var pulled, fresh bool
 	if err != nil || container.Pull {
-		e.client.PullImage(container.Image, auth)
+		var perr error
+		conf.Image, perr = e.pull(container.Image, auth)
+		pulled = perr == nil
+
+		// the image is only tracked as pulled for the container when it did
+		// not exist on the host, since existing images may be used by other
+		// builds and are never removed.
+		fresh = pulled && err != nil
+
+		// fail when the image cannot be pulled and does not exist locally,
+		// since the container cannot be created. The local image is used
+		// when it exists.
+		if perr != nil && err != nil {
+			return "", perr
+		}
+		if perr != nil {
+			logrus.Warnf("Cannot pull %s, using the local image. %s", container.Image, perr)
+		}
+
+		// inspect the pulled image when the image details are required
+		// to verify the platform or size, expand the environment or run
+		// an init.
+		if container.Platform != "" || len(container.EnvironRefs) != 0 || container.Init || e.maxImageSize > 0 {
+			image, _ = e.client.InspectImage(conf.Image)
+		}
+
+		// reject pulled images exceeding the maximum size, which protects
+		// shared hosts from running out of disk, and remove them unless they
+		// existed on the host before the pull, since other builds may use
+		// them. Privileged containers, such as escalated plugins, are exempt.
+		if e.maxImageSize > 0 && !container.Privileged && pulled &&
+			image != nil && image.VirtualSize > e.maxImageSize {
+			if fresh {
+				e.ImageRemove(container.Image)
+			}
+			return "", fmt.Errorf("Cannot run %s, image size %d exceeds the maximum of %d bytes",
+				container.Image, image.VirtualSize, e.maxImageSize)
+		}
+	}
+
+	// verify the image matches the platform defined in the Yaml, since the
+	// client cannot select the image variant for the platform. Containers
+	// without a platform run any image the daemon accepts.
+	if container.Platform != "" && image != nil && image.Architecture != "" {
+		if platform := image.Os + "/" + image.Architecture; platform != container.Platform {
+			return "", fmt.Errorf("Cannot run %s on %s, image platform is %s",
+				container.Image, container.Platform, platform)
+		}
+	}
+
+	// expand the environment variables that reference other variables,
+	// which may be inherited from the image environment.
+	if len(container.EnvironRefs) != 0 {
+		var inherited []string
+		if image != nil && image.Config != nil {
+			inherited = image.Config.Env
+		}
+		conf.Env = append(conf.Env, environRefs(container, inherited)...)
+	}
+
+	// load the environment variables from the env file, which is produced
+	// by a prior step. Variables in the Yaml environment take precedence.
+	if container.EnvFile != "" {
+		env, err := e.readEnvFile(container)
+		if err != nil {
+			logrus.Warnf("Cannot load env_file %s for %s. %s", container.EnvFile, container.Name, err)
+		}
+		for _, kv := range env {
+			if _, ok := container.Environment[strings.SplitN(kv, "=", 2)[0]]; !ok {
+				conf.Env = append(conf.Env, kv)
+			}
+		}
+	}
+
+	// run a minimal init as PID 1 that reaps zombie processes.
+	if container.Init {
+		withInit(conf, image)
+	}
+
+	if len(container.Files) != 0 {
+		if err := e.writeFiles(container, conf); err != nil {
+			e.removeVolumes(container.ID)
+			return "", err
+		}
 	}
 
 	// create and start the container and return the Container ID.
-	id, err := e.client.CreateContainer(conf, container.ID, auth)
+	id, err := e.create(container, conf, auth)
 	if err != nil {
+		e.removeVolumes(container.ID)
 		return id, err
 	}
-	err = e.client.StartContainer(id, &conf.HostConfig)
+	e.moveVolumes(container.ID, id)
+	if fresh {
+		e.mu.Lock()
+		e.pulls[id] = true
+		e.mu.Unlock()
+	}
+	err = e.start(container, conf, id)
 	if err != nil {
 
 		// remove the container if it cannot be started
 		e.client.RemoveContainer(id, true, true)
+		e.removeVolumes(id)
 		return id, err
 	}
 	return id, nil
 }
 
+// create creates the container. Containers with sysctls are created through
+// the daemon API, since the Docker client does not support sysctls.
+func (e *dockerEngine) create(container *yaml.Container, conf *dockerclient.ContainerConfig, auth *dockerclient.AuthConfig) (string, error) {
+	if len(container.Sysctls) == 0 {
+		return e.client.CreateContainer(conf, container.ID, auth)
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", err
+	}
+	hostConfig, _ := config["HostConfig"].(map[string]interface{})
+	if hostConfig == nil {
+		hostConfig = map[string]interface{}{}
+		config["HostConfig"] = hostConfig
+	}
+	hostConfig["Sysctls"] = container.Sysctls
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	client := e.client.(*dockerclient.DockerClient)
+	path := "/containers/create?name=" + url.QueryEscape(container.ID)
+	if err := postJSON(client, path, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// start starts the container. Containers with sysctls are started through
+// the daemon API without a host configuration, which would replace the
+// host configuration, including the sysctls, the container was created with.
+func (e *dockerEngine) start(container *yaml.Container, conf *dockerclient.ContainerConfig, id string) error {
+	if len(container.Sysctls) == 0 {
+		return e.client.StartContainer(id, &conf.HostConfig)
+	}
+	client := e.client.(*dockerclient.DockerClient)
+	return postJSON(client, "/containers/"+id+"/start", nil, nil)
+}
+
+// pull pulls the image and returns the image reference pulled. If the image
+// is not found, the pull is retried with a delay when configured. If the image
+// cannot be pulled from its registry, the image is pulled from the first
+// mirror that succeeds, and the reference is rewritten to the mirror. The
+// mirrors are authenticated with the credential helper, if configured, since
+// the image credentials are only valid for its registry. An error is returned
+// if the image cannot be pulled from the registry or the mirrors.
+func (e *dockerEngine) pull(image string, auth *dockerclient.AuthConfig) (string, error) {
+	err := e.client.PullImage(image, auth)
+	for i := 0; i < e.pullRetries && isNotFound(err); i++ {
+		logrus.Warnf("Cannot pull %s, image not found. Retrying in %s", image, e.pullBackoff)
+		time.Sleep(e.pullBackoff)
+		err = e.client.PullImage(image, auth)
+	}
+	if err == nil {
+		e.track(image, image)
+		return image, nil
+	}
+	for _, mirror := range e.mirrors {
+		ref := mirrorImage(mirror, image)
+
+		var mauth *dockerclient.AuthConfig
+		if e.creds != nil {
+			mauth, _ = e.creds.Get(registryHost(ref))
+		}
+		if merr := e.client.PullImage(ref, mauth); merr == nil {
+			logrus.Warnf("Cannot pull %s, pulled %s from mirror instead. %s", image, ref, err)
+			e.track(image, ref)
+			return ref, nil
+		}
+	}
+	if isNotFound(err) {
+		return image, fmt.Errorf("Cannot pull image %s, image not found after %d retries", image, e.pullRetries)
+	}
+	return image, fmt.Errorf("Cannot pull image %s. %s", image, err)
+}
+
+// ImageDigest pulls the image and returns the digest of the image in its
+// registry, or in the mirror the image is pulled from.
+func (e *dockerEngine) ImageDigest(image string) (string, error) {
+	var auth *dockerclient.AuthConfig
+	if e.creds != nil {
+		auth, _ = e.creds.Get(registryHost(image))
+	}
+	ref, err := e.pull(image, auth)
+	if err != nil {
+		return "", err
+	}
+	digests, err := e.repoDigests(ref)
+	if err != nil {
+		return "", fmt.Errorf("Cannot inspect image %s. %s", ref, err)
+	}
+	repo := imageRepo(ref)
+	for _, digest := range digests {
+		if i := strings.LastIndex(digest, "@"); i != -1 && digest[:i] == repo {
+			return digest[i+1:], nil
+		}
+	}
+	if len(digests) != 0 {
+		return digests[0][strings.LastIndex(digests[0], "@")+1:], nil
+	}
+	return "", fmt.Errorf("Cannot resolve the digest of %s, image has no registry digest", image)
+}
+
+// repoDigests returns the registry digests of the image, in repo@digest
+// format. The Docker client does not decode the image digests, and the image
+// is inspected with the default API version of the daemon instead.
+func (e *dockerEngine) repoDigests(image string) ([]string, error) {
+	client, ok := e.client.(*dockerclient.DockerClient)
+	if !ok {
+		return nil, build.ErrImageResolver
+	}
+	resp, err := client.HTTPClient.Get(client.URL.String() + "/images/" + image + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, dockerclient.ErrNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+	var info struct {
+		RepoDigests []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return info.RepoDigests, nil
+}
+
+// VolumeExists returns true if the named volume exists.
+func (e *dockerEngine) VolumeExists(name string) (bool, error) {
+	volumes, err := e.client.ListVolumes()
+	if err != nil {
+		return false, err
+	}
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ContainerCopy returns a tar archive of the path in the container. The Docker
+// client does not support the archive endpoint, and the archive is requested
+// with the default API version of the daemon instead.
+func (e *dockerEngine) ContainerCopy(id, path string) (io.ReadCloser, error) {
+	client, ok := e.client.(*dockerclient.DockerClient)
+	if !ok {
+		return nil, build.ErrContainerCopier
+	}
+	uri := client.URL.String() + "/containers/" + id + "/archive?path=" + url.QueryEscape(path)
+	resp, err := client.HTTPClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 404 {
+		resp.Body.Close()
+		return nil, dockerclient.ErrNotFound
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// ContainerStats streams the memory and cpu usage of the container, sampled
+// by the docker daemon about once a second, until the container exits or the
+// stop channel is closed.
+func (e *dockerEngine) ContainerStats(id string, stop <-chan struct{}) (<-chan *build.Stats, error) {
+	samples, err := e.client.ContainerStats(id, stop)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(chan *build.Stats)
+	go func() {
+		defer close(stats)
+		var prev *dockerclient.Stats
+		for sample := range samples {
+			if sample.Error != nil {
+				continue
+			}
+			cur := sample.Stats
+			stats <- &build.Stats{
+				Memory: cur.MemoryStats.Usage,
+				CPU:    cpuPercent(prev, &cur),
+			}
+			prev = &cur
+		}
+	}()
+	return stats, nil
+}
+
+// ContainerExec executes the entrypoint and command of the container in the
+// running container and streams the command output. The Docker client does not
+// stream the exec output or report the exit code, and the exec is created and
+// started with the default API version of the daemon instead.
+func (e *dockerEngine) ContainerExec(id string, c *yaml.Container) (io.ReadCloser, error) {
+	client, ok := e.client.(*dockerclient.DockerClient)
+	if !ok {
+		return nil, build.ErrContainerExecer
+	}
+	info, err := e.client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	if !info.State.Running {
+		return nil, fmt.Errorf("Cannot exec %s in %s, container is not running", c.Name, id)
+	}
+
+	// the variables that reference other variables are expanded against
+	// the environment of the running container.
+	var inherited []string
+	if info.Config != nil {
+		inherited = info.Config.Env
+	}
+	config := map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          append(append([]string(nil), c.Entrypoint...), c.Command...),
+		"Env":          append(toEnvironmentSlice(c.Environment), environRefs(c, inherited)...),
+		"WorkingDir":   c.WorkingDir,
+		"User":         c.User,
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := postJSON(client, "/containers/"+id+"/exec", config, &created); err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": false})
+	resp, err := client.HTTPClient.Post(client.URL.String()+"/exec/"+created.ID+"/start", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+
+	piper, pipew := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		internal.StdCopy(pipew, pipew, resp.Body)
+
+		var state struct {
+			ExitCode int
+		}
+		r, err := client.HTTPClient.Get(client.URL.String() + "/exec/" + created.ID + "/json")
+		if err != nil {
+			pipew.CloseWithError(err)
+			return
+		}
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			pipew.CloseWithError(err)
+			return
+		}
+		if state.ExitCode != 0 {
+			pipew.CloseWithError(&build.ExitError{Name: c.Name, Code: state.ExitCode})
+			return
+		}
+		pipew.Close()
+	}()
+	return piper, nil
+}
+
+// postJSON posts the value to the daemon endpoint as JSON and decodes the JSON
+// response into out. The request has no body if in is nil, and the response
+// is discarded if out is nil.
+func postJSON(client *dockerclient.DockerClient, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := client.HTTPClient.Post(client.URL.String()+path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return dockerclient.ErrNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// track records the image reference pulled for the image.
+func (e *dockerEngine) track(image, ref string) {
+	e.mu.Lock()
+	e.pulled[image] = ref
+	e.mu.Unlock()
+}
+
+// ContainerPulled returns true if the engine pulled the image when starting
+// the container.
+func (e *dockerEngine) ContainerPulled(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.pulls[id]
+}
+
+func (e *dockerEngine) NetworkCreate(network *yaml.Network) error {
+	_, err := e.client.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:           network.Name,
+		CheckDuplicate: true,
+		Driver:         network.Driver,
+		Options:        network.DriverOpts,
+	})
+	return err
+}
+
+func (e *dockerEngine) NetworkRemove(name string) error {
+	return e.client.RemoveNetwork(name)
+}
+
 func (e *dockerEngine) ContainerStop(id string) error {
 	e.client.StopContainer(id, 1)
 	e.client.KillContainer(id, "9")
@@ -50,25 +558,234 @@ func (e *dockerEngine) ContainerRemove(id string) error {
 	e.client.StopContainer(id, 1)
 	e.client.KillContainer(id, "9")
 	e.client.RemoveContainer(id, true, true)
+	e.removeVolumes(id)
+	e.mu.Lock()
+	delete(e.pulls, id)
+	e.mu.Unlock()
 	return nil
 }
 
-func (e *dockerEngine) ContainerWait(id string) (*build.State, error) {
-	// wait for the container to exit
-	//
-	// TODO(bradrydzewski) we should have a for loop here
-	// to re-connect and wait if this channel returns a
-	// result even though the container is still running.
-	//
+// writeFiles writes the secret files to tmpfs volumes, which are mounted in
+// the container at the directory of each file. The files are written by a
+// helper container, since the volumes are not accessible to the agent.
+func (e *dockerEngine) writeFiles(container *yaml.Container, conf *dockerclient.ContainerConfig) error {
+	var paths []string
+	for path := range container.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	helper := &dockerclient.ContainerConfig{
+		Image:      e.helperImage,
+		Entrypoint: []string{"/bin/sh", "-c"},
+	}
+	var script bytes.Buffer
+	dirs := map[string]string{}
+	for i, path := range paths {
+		dir := filepath.Dir(path)
+		mount, ok := dirs[dir]
+		if !ok {
+			name := fmt.Sprintf("%s_secrets_%d", container.ID, len(dirs))
+			_, err := e.client.CreateVolume(&dockerclient.VolumeCreateRequest{
+				Name:       name,
+				Driver:     "local",
+				DriverOpts: map[string]string{"type": "tmpfs", "device": "tmpfs"},
+			})
+			if err != nil {
+				return err
+			}
+			e.mu.Lock()
+			e.volumes[container.ID] = append(e.volumes[container.ID], name)
+			e.mu.Unlock()
+
+			mount = fmt.Sprintf("/secrets/%d", len(dirs))
+			dirs[dir] = mount
+			helper.HostConfig.Binds = append(helper.HostConfig.Binds, name+":"+mount)
+			conf.HostConfig.Binds = append(conf.HostConfig.Binds, name+":"+dir)
+			conf.Volumes[dir] = struct{}{}
+		}
+		// the file contents are passed in the helper environment so they
+		// are never written to the script.
+		env := fmt.Sprintf("DRONE_SECRET_FILE_%d", i)
+		helper.Env = append(helper.Env, env+"="+container.Files[path])
+		file := mount + "/'" + strings.Replace(filepath.Base(path), "'", `'\''`, -1) + "'"
+		fmt.Fprintf(&script, "printf '%%s' \"$%s\" > %s\n", env, file)
+		fmt.Fprintf(&script, "chmod 0600 %s\n", file)
+		if container.User != "" {
+			fmt.Fprintf(&script, "chown %s %s\n", container.User, file)
+		}
+	}
+	helper.Cmd = []string{script.String()}
+
+	if _, err := e.client.InspectImage(e.helperImage); err != nil {
+		e.client.PullImage(e.helperImage, nil)
+	}
+	id, err := e.client.CreateContainer(helper, container.ID+"_secrets", nil)
+	if err != nil {
+		return err
+	}
+	defer e.client.RemoveContainer(id, true, true)
+
+	if err := e.client.StartContainer(id, &helper.HostConfig); err != nil {
+		return err
+	}
+	<-e.client.Wait(id)
+	v, err := e.client.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	if v.State.ExitCode != 0 {
+		return fmt.Errorf("Cannot write secret files, exit code %d", v.State.ExitCode)
+	}
+	return nil
+}
+
+// readEnvFile reads the env file of the container and returns the variables
+// in key=value format. The env file is stored in the workspace volume, which
+// is not accessible to the agent, and is read by a helper container that
+// shares the workspace volume.
+func (e *dockerEngine) readEnvFile(container *yaml.Container) ([]string, error) {
+	conf := &dockerclient.ContainerConfig{
+		Image:        e.helperImage,
+		Entrypoint:   []string{"/bin/cat"},
+		Cmd:          []string{container.EnvFile},
+		AttachStdout: true,
+		AttachStderr: true,
+		HostConfig: dockerclient.HostConfig{
+			VolumesFrom: container.VolumesFrom,
+		},
+	}
+	if _, err := e.client.InspectImage(e.helperImage); err != nil {
+		e.client.PullImage(e.helperImage, nil)
+	}
+	id, err := e.client.CreateContainer(conf, container.ID+"_env", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer e.client.RemoveContainer(id, true, true)
+
+	rc, err := e.client.AttachContainer(id, &dockerclient.AttachOptions{
+		Stream: true,
+		Stdout: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := e.client.StartContainer(id, &conf.HostConfig); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if _, err := internal.StdCopy(&out, ioutil.Discard, rc); err != nil {
+		return nil, err
+	}
 	<-e.client.Wait(id)
 	v, err := e.client.InspectContainer(id)
 	if err != nil {
 		return nil, err
 	}
-	return &build.State{
-		ExitCode:  v.State.ExitCode,
-		OOMKilled: v.State.OOMKilled,
-	}, nil
+	if v.State.ExitCode != 0 {
+		return nil, fmt.Errorf("Cannot read env file, exit code %d", v.State.ExitCode)
+	}
+	return parseEnvFile(out.String()), nil
+}
+
+// moveVolumes tracks the secret file volumes created for the named container
+// by the container ID returned by the daemon, since containers are removed
+// by ID.
+func (e *dockerEngine) moveVolumes(name, id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if volumes, ok := e.volumes[name]; ok && name != id {
+		e.volumes[id] = volumes
+		e.names[name] = id
+		delete(e.volumes, name)
+	}
+}
+
+// removeVolumes removes the secret file volumes created for the container,
+// which is identified by ID or by name.
+func (e *dockerEngine) removeVolumes(id string) {
+	e.mu.Lock()
+	if name, ok := e.names[id]; ok {
+		delete(e.names, id)
+		id = name
+	}
+	for name, other := range e.names {
+		if other == id {
+			delete(e.names, name)
+		}
+	}
+	volumes := e.volumes[id]
+	delete(e.volumes, id)
+	e.mu.Unlock()
+
+	for _, name := range volumes {
+		e.client.RemoveVolume(name)
+	}
+}
+
+func (e *dockerEngine) ContainerWait(id string) (*build.State, error) {
+	var err error
+	for i := 0; i <= e.retries; i++ {
+		if i > 0 {
+			time.Sleep(e.backoff)
+		}
+
+		// the wait request returns early when the connection to the daemon
+		// is lost, for example when the daemon restarts. The container is
+		// inspected once the daemon is reachable to recover the exit code,
+		// or waited on again if the container is still running.
+		e.wait(id)
+
+		var v *dockerclient.ContainerInfo
+		v, err = e.client.InspectContainer(id)
+		if err == dockerclient.ErrNotFound {
+			return nil, fmt.Errorf("Container %s was removed before it exited", id)
+		}
+		if err != nil {
+			continue
+		}
+		if v.State.Running {
+			err = fmt.Errorf("container is still running")
+			continue
+		}
+		return &build.State{
+			ExitCode:  v.State.ExitCode,
+			OOMKilled: v.State.OOMKilled,
+		}, nil
+	}
+	return nil, &build.EngineError{Err: fmt.Errorf("Cannot wait for container %s. %s", id, err)}
+}
+
+// wait waits for the wait request of the container to return. If the request
+// does not return within the watchdog interval, the container is inspected,
+// and the wait ends if the container has exited. Otherwise the container is
+// waited on for another interval.
+func (e *dockerEngine) wait(id string) {
+	done := e.client.Wait(id)
+	if e.watchdog <= 0 {
+		<-done
+		return
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(e.watchdog):
+		}
+		v, err := e.client.InspectContainer(id)
+		if err == nil && !v.State.Running {
+			logrus.Warnf("Waiting for container %s is stuck, container exited with code %d",
+				id, v.State.ExitCode)
+
+			// the wait result is drained, since the client blocks sending
+			// the result once the wait request returns.
+			go func() { <-done }()
+			return
+		}
+	}
 }
 
 func (e *dockerEngine) ContainerLogs(id string) (io.ReadCloser, error) {
@@ -110,3 +827,103 @@ func (e *dockerEngine) ContainerLogs(id string) (io.ReadCloser, error) {
 	}()
 	return piper, nil
 }
+
+func (e *dockerEngine) ImageBuild(container *yaml.Container) (io.ReadCloser, error) {
+	// the build context is stored in the workspace volume, which is not
+	// accessible to the agent. A helper container that shares the workspace
+	// volume archives the build context, which is streamed to the daemon.
+	conf := &dockerclient.ContainerConfig{
+		Image:        e.helperImage,
+		Entrypoint:   []string{"/bin/tar"},
+		Cmd:          []string{"-c", "-C", container.ImageBuild.Context, "."},
+		AttachStdout: true,
+		AttachStderr: true,
+		HostConfig: dockerclient.HostConfig{
+			VolumesFrom: container.VolumesFrom,
+		},
+	}
+	if _, err := e.client.InspectImage(e.helperImage); err != nil {
+		e.client.PullImage(e.helperImage, nil)
+	}
+	id, err := e.client.CreateContainer(conf, container.ID+"_context", nil)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := e.client.AttachContainer(id, &dockerclient.AttachOptions{
+		Stream: true,
+		Stdout: true,
+	})
+	if err != nil {
+		e.client.RemoveContainer(id, true, true)
+		return nil, err
+	}
+	err = e.client.StartContainer(id, &conf.HostConfig)
+	if err != nil {
+		rc.Close()
+		e.client.RemoveContainer(id, true, true)
+		return nil, err
+	}
+
+	contextr, contextw := io.Pipe()
+	go func() {
+		_, err := internal.StdCopy(contextw, ioutil.Discard, rc)
+		rc.Close()
+		contextw.CloseWithError(err)
+	}()
+
+	out, err := e.client.BuildImage(&dockerclient.BuildImage{
+		Context:        contextr,
+		RepoName:       container.Image,
+		DockerfileName: container.ImageBuild.Dockerfile,
+		BuildArgs:      container.ImageBuild.Args,
+		Remove:         true,
+	})
+	if err != nil {
+		contextr.Close()
+		e.client.RemoveContainer(id, true, true)
+		return nil, err
+	}
+
+	piper, pipew := io.Pipe()
+	go func() {
+		defer e.client.RemoveContainer(id, true, true)
+		defer out.Close()
+		pipew.CloseWithError(decodeBuild(pipew, out))
+	}()
+	return piper, nil
+}
+
+// ImageRemove removes the image, or the image reference pulled for the image
+// if it was pulled from a mirror.
+func (e *dockerEngine) ImageRemove(name string) error {
+	e.mu.Lock()
+	if ref, ok := e.pulled[name]; ok {
+		delete(e.pulled, name)
+		name = ref
+	}
+	e.mu.Unlock()
+	_, err := e.client.RemoveImage(name, true)
+	return err
+}
+
+// decodeBuild decodes the image build output stream and writes the build
+// output to w. It returns an error if the build failed.
+func decodeBuild(w io.Writer, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var message struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		err := dec.Decode(&message)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Error != "" {
+			return errors.New(message.Error)
+		}
+		io.WriteString(w, message.Stream)
+	}
+}
@@ -1 +1,1235 @@
 package docker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/yaml"
+	"github.com/samalba/dockerclient"
+)
+
+func TestContainerStartSysctls(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		Image:   "golang:1.5",
+		Sysctls: map[string]string{"net.core.somaxconn": "1024"},
+	})
+	if err != errSysctls {
+		t.Errorf("Wanted error %q got %v", errSysctls, err)
+	}
+	if len(client.created) != 0 {
+		t.Errorf("Wanted container not created")
+	}
+}
+
+func TestContainerStartSysctlsAPI(t *testing.T) {
+	var created map[string]interface{}
+	var started []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/golang:1.5/json"):
+			io.WriteString(w, `{"Id":"sha256:4e2a6d8c3f1b"}`)
+		case r.Method == "POST" && r.URL.Path == "/containers/create":
+			if name := r.URL.Query().Get("name"); name != "drone_1" {
+				t.Errorf("Wanted container created with name drone_1, got %q", name)
+			}
+			json.NewDecoder(r.Body).Decode(&created)
+			io.WriteString(w, `{"Id":"9f3b7c1d2e4a"}`)
+		case r.Method == "POST" && r.URL.Path == "/containers/9f3b7c1d2e4a/start":
+			started = append(started, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := dockerclient.NewDockerClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewClient(client).ContainerStart(&yaml.Container{
+		ID:      "drone_1",
+		Image:   "golang:1.5",
+		Sysctls: map[string]string{"net.core.somaxconn": "1024"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if id != "9f3b7c1d2e4a" {
+		t.Errorf("Wanted the created container ID, got %q", id)
+	}
+	hostConfig, _ := created["HostConfig"].(map[string]interface{})
+	if got := fmt.Sprint(hostConfig["Sysctls"]); got != "map[net.core.somaxconn:1024]" {
+		t.Errorf("Wanted sysctls in the host configuration, got %s", got)
+	}
+	if created["Image"] != "golang:1.5" {
+		t.Errorf("Wanted container created with the image, got %v", created["Image"])
+	}
+	if len(started) != 1 {
+		t.Errorf("Wanted container started, got %v", started)
+	}
+}
+
+func TestContainerCopy(t *testing.T) {
+	archive := []byte{0x1f, 0x8b, 0xff, 0xfe, 0x0a, 0x00}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/containers/drone_1_archive/archive" {
+			http.NotFound(w, r)
+			return
+		}
+		if path := r.URL.Query().Get("path"); path != "/tmp/drone_archive.tar.gz" {
+			t.Errorf("Wanted the archive path copied, got %q", path)
+		}
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	client, err := dockerclient.NewDockerClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewClient(client).(build.ContainerCopier)
+	rc, err := engine.ContainerCopy("drone_1_archive", "/tmp/drone_archive.tar.gz")
+	if err != nil {
+		t.Fatalf("Wanted archive copied, got error %q", err)
+	}
+	defer rc.Close()
+	out, _ := ioutil.ReadAll(rc)
+	if !bytes.Equal(out, archive) {
+		t.Errorf("Wanted archive bytes unchanged, got %v", out)
+	}
+
+	if _, err := engine.ContainerCopy("drone_2", "/tmp"); err != dockerclient.ErrNotFound {
+		t.Errorf("Wanted not found error, got %v", err)
+	}
+}
+
+func TestContainerCopyClient(t *testing.T) {
+	engine := NewClient(&fakeClient{}).(build.ContainerCopier)
+	if _, err := engine.ContainerCopy("drone_1", "/tmp"); err != build.ErrContainerCopier {
+		t.Errorf("Wanted ErrContainerCopier, got %v", err)
+	}
+}
+
+func TestContainerStartEnvironRefs(t *testing.T) {
+	client := &fakeClient{imageEnv: []string{"PATH=/usr/local/bin:/usr/bin"}}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:          "drone_1",
+		Image:       "golang:1.5",
+		Environment: map[string]string{"GOPATH": "/go"},
+		EnvironRefs: map[string]string{"PATH": "${GOPATH}/bin:${PATH}"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	env := strings.Join(client.created[0].Env, " ")
+	if want := "PATH=/go/bin:/usr/local/bin:/usr/bin"; !strings.Contains(env, want) {
+		t.Errorf("Wanted environment %q, got %q", want, env)
+	}
+}
+
+func TestContainerStartPlatform(t *testing.T) {
+	client := &fakeClient{imageOS: "linux", imageArch: "arm64"}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:       "drone_1",
+		Image:    "golang:1.5",
+		Platform: "linux/arm64",
+	})
+	if err != nil {
+		t.Errorf("Wanted container started on matching platform, got error %q", err)
+	}
+
+	_, err = engine.ContainerStart(&yaml.Container{
+		ID:       "drone_2",
+		Image:    "golang:1.5",
+		Platform: "linux/amd64",
+	})
+	if want := "Cannot run golang:1.5 on linux/amd64, image platform is linux/arm64"; err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+	if len(client.created) != 1 {
+		t.Errorf("Wanted container not created on mismatched platform")
+	}
+}
+
+func TestContainerStartInit(t *testing.T) {
+	client := &fakeClient{
+		imageEntrypoint: []string{"docker-entrypoint.sh"},
+		imageCmd:        []string{"postgres"},
+	}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:    "drone_1",
+		Image: "postgres:9",
+		Init:  true,
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	service := client.created[0]
+	if got := strings.Join(service.Entrypoint, " "); got != "/dev/init -- docker-entrypoint.sh" {
+		t.Errorf("Wanted init prepended to the image entrypoint, got %q", got)
+	}
+	if got := strings.Join(service.Cmd, " "); got != "postgres" {
+		t.Errorf("Wanted image command, got %q", got)
+	}
+	if got := strings.Join(service.HostConfig.Binds, " "); got != "/usr/bin/docker-init:/dev/init:ro" {
+		t.Errorf("Wanted init mounted in the host config, got %q", got)
+	}
+
+	_, err = engine.ContainerStart(&yaml.Container{
+		ID:         "drone_2",
+		Image:      "golang:1.5",
+		Init:       true,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"go test"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	step := client.created[1]
+	if got := strings.Join(step.Entrypoint, " "); got != "/dev/init -- /bin/sh -c" {
+		t.Errorf("Wanted init prepended to the step entrypoint, got %q", got)
+	}
+	if got := strings.Join(step.Cmd, " "); got != "go test" {
+		t.Errorf("Wanted step command, got %q", got)
+	}
+
+	engine.ContainerStart(&yaml.Container{ID: "drone_3", Image: "redis"})
+	if binds := client.created[2].HostConfig.Binds; len(binds) != 0 {
+		t.Errorf("Wanted init disabled by default, got binds %v", binds)
+	}
+}
+
+func TestContainerStartMirror(t *testing.T) {
+	unavailable := errors.New("registry unavailable")
+	client := &fakeClient{
+		pullErrs: map[string]error{
+			"golang:1.5":                           unavailable,
+			"mirror-a.internal/library/golang:1.5": unavailable,
+		},
+	}
+	engine := NewClient(client, WithMirrors([]string{
+		"mirror-a.internal",
+		"mirror-b.internal/",
+		"mirror-c.internal",
+	}))
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:         "drone_1",
+		Image:      "golang:1.5",
+		Pull:       true,
+		AuthConfig: yaml.Auth{Username: "octocat", Password: "password"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started from mirror, got error %q", err)
+	}
+	want := []string{"golang:1.5", "mirror-a.internal/library/golang:1.5", "mirror-b.internal/library/golang:1.5"}
+	if !reflect.DeepEqual(client.images, want) {
+		t.Errorf("Wanted images pulled %v, got %v", want, client.images)
+	}
+	if client.pulled[1] != nil || client.pulled[2] != nil {
+		t.Errorf("Wanted image credentials not sent to the mirrors")
+	}
+	if got := client.created[0].Image; got != "mirror-b.internal/library/golang:1.5" {
+		t.Errorf("Wanted container image rewritten to the mirror, got %q", got)
+	}
+
+	engine.ImageRemove("golang:1.5")
+	if got := client.removedImages; len(got) != 1 || got[0] != "mirror-b.internal/library/golang:1.5" {
+		t.Errorf("Wanted the mirror image removed, got %v", got)
+	}
+}
+
+func TestContainerStartPullRetry(t *testing.T) {
+	client := &fakeClient{
+		notFound: map[string]int{"golang:1.5": 2},
+		missing:  map[string]bool{"golang:1.5": true},
+	}
+	engine := NewClient(client, WithPullRetries(3, time.Millisecond))
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Pull: true})
+	if err != nil {
+		t.Fatalf("Wanted container started after retries, got error %q", err)
+	}
+	if len(client.images) != 3 {
+		t.Errorf("Wanted image pulled 3 times, got %v", client.images)
+	}
+	if !engine.(build.ImageTracker).ContainerPulled("drone_1") {
+		t.Errorf("Wanted pulled image tracked")
+	}
+}
+
+func TestContainerStartPullNotFound(t *testing.T) {
+	client := &fakeClient{
+		notFound:  map[string]int{"golang:1.5": 5},
+		imageErrs: map[string]error{"golang:1.5": dockerclient.ErrNotFound},
+	}
+	engine := NewClient(client, WithPullRetries(2, time.Millisecond))
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5"})
+	want := "Cannot pull image golang:1.5, image not found after 2 retries"
+	if err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+	if len(client.images) != 3 {
+		t.Errorf("Wanted image pulled 3 times, got %v", client.images)
+	}
+	if len(client.created) != 0 {
+		t.Errorf("Wanted no container created")
+	}
+}
+
+func TestContainerStartPullNotFoundLocal(t *testing.T) {
+	client := &fakeClient{notFound: map[string]int{"golang:1.5": 5}}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Pull: true})
+	if err != nil {
+		t.Fatalf("Wanted container started from the local image, got error %q", err)
+	}
+	if len(client.images) != 1 {
+		t.Errorf("Wanted image pull not retried by default, got %v", client.images)
+	}
+}
+
+func TestContainerStartPullError(t *testing.T) {
+	client := &fakeClient{
+		pullErrs:  map[string]error{"golang:1.5": errors.New("unauthorized")},
+		imageErrs: map[string]error{"golang:1.5": dockerclient.ErrNotFound},
+	}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5"})
+	want := "Cannot pull image golang:1.5. unauthorized"
+	if err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+	if len(client.created) != 0 {
+		t.Errorf("Wanted no container created")
+	}
+}
+
+func TestContainerStartPullErrorLocal(t *testing.T) {
+	client := &fakeClient{pullErrs: map[string]error{"golang:1.5": errors.New("unauthorized")}}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Pull: true})
+	if err != nil {
+		t.Fatalf("Wanted container started from the local image, got error %q", err)
+	}
+	if engine.(build.ImageTracker).ContainerPulled("drone_1") {
+		t.Errorf("Wanted local image not tracked as pulled")
+	}
+}
+
+func TestContainerStartMaxImageSize(t *testing.T) {
+	client := &fakeClient{imageSize: 2000, missing: map[string]bool{"golang:1.5": true}}
+	engine := NewClient(client, WithMaxImageSize(1000))
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5"})
+	want := "Cannot run golang:1.5, image size 2000 exceeds the maximum of 1000 bytes"
+	if err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+	if len(client.created) != 0 {
+		t.Errorf("Wanted no container created")
+	}
+	if got := client.removedImages; len(got) != 1 || got[0] != "golang:1.5" {
+		t.Errorf("Wanted the pulled image removed, got %v", got)
+	}
+	if engine.(build.ImageTracker).ContainerPulled("drone_1") {
+		t.Errorf("Wanted removed image not tracked")
+	}
+
+	// privileged containers, such as escalated plugins, are exempt.
+	_, err = engine.ContainerStart(&yaml.Container{ID: "drone_2", Image: "plugins/docker", Pull: true, Privileged: true})
+	if err != nil {
+		t.Errorf("Wanted privileged container started, got error %q", err)
+	}
+
+	// images that are not pulled are not checked.
+	_, err = engine.ContainerStart(&yaml.Container{ID: "drone_3", Image: "redis:3"})
+	if err != nil {
+		t.Errorf("Wanted existing image started, got error %q", err)
+	}
+
+	// images that existed before the pull are rejected, but not removed,
+	// since other builds may use them.
+	_, err = engine.ContainerStart(&yaml.Container{ID: "drone_4", Image: "redis:3", Pull: true})
+	if err == nil {
+		t.Errorf("Wanted oversized image rejected")
+	}
+	if got := client.removedImages; len(got) != 1 {
+		t.Errorf("Wanted the existing image kept, got %v removed", got)
+	}
+}
+
+func TestContainerStartBelowMaxImageSize(t *testing.T) {
+	client := &fakeClient{imageSize: 500}
+	engine := NewClient(client, WithMaxImageSize(1000))
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Pull: true})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if len(client.created) != 1 || len(client.removedImages) != 0 {
+		t.Errorf("Wanted container created and image kept")
+	}
+}
+
+func TestContainerPulled(t *testing.T) {
+	client := &fakeClient{missing: map[string]bool{"golang:1.5": true}}
+	engine := NewClient(client)
+
+	engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5"})
+	engine.ContainerStart(&yaml.Container{ID: "drone_2", Image: "redis:3"})
+
+	// images that already existed on the host are not tracked, even when
+	// the container always pulls the image.
+	engine.ContainerStart(&yaml.Container{ID: "drone_3", Image: "redis:3", Pull: true})
+
+	// the image is only tracked as pulled by the container that pulled it,
+	// since the engine is shared by concurrent builds.
+	engine.ContainerStart(&yaml.Container{ID: "drone_4", Image: "golang:1.5", Pull: true})
+
+	tracker := engine.(build.ImageTracker)
+	if !tracker.ContainerPulled("drone_1") {
+		t.Errorf("Wanted pulled image tracked")
+	}
+	if tracker.ContainerPulled("drone_2") {
+		t.Errorf("Wanted existing image not tracked")
+	}
+	if tracker.ContainerPulled("drone_3") {
+		t.Errorf("Wanted re-pulled existing image not tracked")
+	}
+	if tracker.ContainerPulled("drone_4") {
+		t.Errorf("Wanted image pulled by another container not tracked")
+	}
+	engine.ContainerRemove("drone_1")
+	if tracker.ContainerPulled("drone_1") {
+		t.Errorf("Wanted removed container no longer tracked")
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	var pulled []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/images/create"):
+			pulled = append(pulled, r.URL.Query().Get("fromImage"))
+			io.WriteString(w, `{"status":"Downloaded newer image"}`)
+		case r.URL.Path == "/images/golang:1.5/json":
+			io.WriteString(w, `{"RepoDigests":["mirror.internal/golang@sha256:fedcba9876543210","golang@sha256:0123456789abcdef"]}`)
+		case r.URL.Path == "/images/redis:3/json":
+			io.WriteString(w, `{"RepoDigests":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := dockerclient.NewDockerClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewClient(client).(build.ImageResolver)
+
+	digest, err := resolver.ImageDigest("golang:1.5")
+	if err != nil {
+		t.Fatalf("Wanted image digest resolved, got error %q", err)
+	}
+	if digest != "sha256:0123456789abcdef" {
+		t.Errorf("Wanted the digest of the image repository, got %q", digest)
+	}
+	if len(pulled) != 1 || pulled[0] != "golang:1.5" {
+		t.Errorf("Wanted image pulled, got %v", pulled)
+	}
+
+	_, err = resolver.ImageDigest("redis:3")
+	if want := "Cannot resolve the digest of redis:3, image has no registry digest"; err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+}
+
+func TestImageDigestClient(t *testing.T) {
+	_, err := NewClient(&fakeClient{}).(build.ImageResolver).ImageDigest("golang:1.5")
+	if err == nil || !strings.HasSuffix(err.Error(), build.ErrImageResolver.Error()) {
+		t.Errorf("Wanted image resolver error, got %v", err)
+	}
+}
+
+func TestContainerExec(t *testing.T) {
+	var created map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/drone_1/json"):
+			io.WriteString(w, `{"Id":"drone_1","State":{"Running":true},"Config":{"Env":["PATH=/usr/bin"]}}`)
+		case strings.HasSuffix(r.URL.Path, "/containers/drone_2/json"):
+			io.WriteString(w, `{"Id":"drone_2","State":{"Running":false}}`)
+		case r.Method == "POST" && r.URL.Path == "/containers/drone_1/exec":
+			json.NewDecoder(r.Body).Decode(&created)
+			io.WriteString(w, `{"Id":"exec_1"}`)
+		case r.Method == "POST" && r.URL.Path == "/exec/exec_1/start":
+			out := "CREATE TABLE\n"
+			header := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(header[4:], uint32(len(out)))
+			w.Write(header)
+			io.WriteString(w, out)
+		case r.URL.Path == "/exec/exec_1/json":
+			io.WriteString(w, `{"ExitCode":3}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := dockerclient.NewDockerClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execer := NewClient(client).(build.ContainerExecer)
+
+	c := &yaml.Container{
+		Name:       "migrate",
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"psql -f schema.sql"},
+		WorkingDir: "/drone/src",
+
+		Environment: map[string]string{"PGHOST": "localhost"},
+		EnvironRefs: map[string]string{"PATH": "/drone/bin:${PATH}"},
+	}
+	rc, err := execer.ContainerExec("drone_1", c)
+	if err != nil {
+		t.Fatalf("Wanted command executed, got error %q", err)
+	}
+	out, err := ioutil.ReadAll(rc)
+	if string(out) != "CREATE TABLE\n" {
+		t.Errorf("Wanted command output streamed, got %q", out)
+	}
+	if exit, ok := err.(*build.ExitError); !ok || exit.Code != 3 {
+		t.Errorf("Wanted exit error with code 3, got %v", err)
+	}
+	if created["WorkingDir"] != "/drone/src" || fmt.Sprint(created["Cmd"]) != "[/bin/sh -c psql -f schema.sql]" {
+		t.Errorf("Wanted exec created with the step command, got %v", created)
+	}
+	if got := fmt.Sprint(created["Env"]); got != "[PGHOST=localhost PATH=/drone/bin:/usr/bin]" {
+		t.Errorf("Wanted exec environment expanded, got %s", got)
+	}
+
+	_, err = execer.ContainerExec("drone_2", c)
+	if want := "Cannot exec migrate in drone_2, container is not running"; err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+}
+
+func TestContainerExecClient(t *testing.T) {
+	_, err := NewClient(&fakeClient{}).(build.ContainerExecer).ContainerExec("drone_1", &yaml.Container{})
+	if err != build.ErrContainerExecer {
+		t.Errorf("Wanted container execer error, got %v", err)
+	}
+}
+
+func TestVolumeExists(t *testing.T) {
+	client := &fakeClient{
+		volumes: []*dockerclient.VolumeCreateRequest{{Name: "drone_cache_1"}},
+	}
+	inspector := NewClient(client).(build.VolumeInspector)
+
+	if exists, err := inspector.VolumeExists("drone_cache_1"); err != nil || !exists {
+		t.Errorf("Wanted volume drone_cache_1 to exist, got %v, %v", exists, err)
+	}
+	if exists, err := inspector.VolumeExists("drone_cache_2"); err != nil || exists {
+		t.Errorf("Wanted volume drone_cache_2 not to exist, got %v, %v", exists, err)
+	}
+}
+
+func TestContainerStats(t *testing.T) {
+	sample := func(memory, total, system uint64) dockerclient.StatsOrError {
+		var stats dockerclient.Stats
+		stats.MemoryStats.Usage = memory
+		stats.CpuStats.CpuUsage.TotalUsage = total
+		stats.CpuStats.CpuUsage.PercpuUsage = []uint64{0, 0}
+		stats.CpuStats.SystemUsage = system
+		return dockerclient.StatsOrError{Stats: stats}
+	}
+	client := &fakeClient{
+		stats: []dockerclient.StatsOrError{
+			sample(1000, 100, 1000),
+			{Error: errors.New("unexpected EOF")},
+			sample(3000, 400, 2000),
+		},
+	}
+	collector := NewClient(client).(build.StatsCollector)
+
+	samples, err := collector.ContainerStats("drone_1", nil)
+	if err != nil {
+		t.Fatalf("Wanted container stats, got error %q", err)
+	}
+	var got []build.Stats
+	for s := range samples {
+		got = append(got, *s)
+	}
+	want := []build.Stats{{Memory: 1000}, {Memory: 3000, CPU: 60}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Wanted stats samples %v, got %v", want, got)
+	}
+}
+
+func TestContainerStartNoMirror(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client, WithMirrors([]string{"mirror.internal"}))
+
+	_, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Pull: true})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if len(client.images) != 1 || client.created[0].Image != "golang:1.5" {
+		t.Errorf("Wanted image pulled from its registry, got %v", client.images)
+	}
+}
+
+func TestContainerStartEnvFile(t *testing.T) {
+	client := &fakeClient{attached: "VERSION=1.2.3\nCHANNEL=beta\n"}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:          "drone_1",
+		Image:       "golang:1.5",
+		EnvFile:     "/drone/src/.env",
+		VolumesFrom: []string{"drone_ambassador"},
+		Environment: map[string]string{"CHANNEL": "stable"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if len(client.created) != 2 {
+		t.Fatalf("Wanted env file helper and step containers created, got %d", len(client.created))
+	}
+	if got := strings.Join(client.created[0].Cmd, " "); got != "/drone/src/.env" {
+		t.Errorf("Wanted env file read from the workspace, got command %q", got)
+	}
+	env := strings.Join(client.created[1].Env, " ")
+	if !strings.Contains(env, "VERSION=1.2.3") {
+		t.Errorf("Wanted env file variable in the step environment, got %q", env)
+	}
+	if !strings.Contains(env, "CHANNEL=stable") || strings.Contains(env, "CHANNEL=beta") {
+		t.Errorf("Wanted yaml environment to take precedence, got %q", env)
+	}
+}
+
+func TestContainerStartEnvFileMissing(t *testing.T) {
+	client := &fakeClient{
+		inspects: []fakeInspect{
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{ExitCode: 1}}},
+		},
+	}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:      "drone_1",
+		Image:   "golang:1.5",
+		EnvFile: "/drone/src/.env",
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started without the env file, got error %q", err)
+	}
+	if len(client.created) != 2 {
+		t.Errorf("Wanted step container created, got %d containers", len(client.created))
+	}
+}
+
+func TestNetworkCreate(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client)
+
+	err := engine.NetworkCreate(&yaml.Network{
+		Name:       "drone_network_1",
+		Driver:     "bridge",
+		DriverOpts: map[string]string{"com.docker.network.driver.mtu": "1450"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted network created, got error %q", err)
+	}
+	if len(client.networks) != 1 {
+		t.Fatalf("Wanted network created, got %d networks", len(client.networks))
+	}
+	network := client.networks[0]
+	if network.Name != "drone_network_1" || network.Driver != "bridge" {
+		t.Errorf("Wanted bridge network drone_network_1, got %s network %s", network.Driver, network.Name)
+	}
+	if mtu := network.Options["com.docker.network.driver.mtu"]; mtu != "1450" {
+		t.Errorf("Wanted network mtu 1450, got %q", mtu)
+	}
+}
+
+func TestContainerRemoveCache(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:      "drone_1",
+		Image:   "maven:3",
+		Volumes: []string{"drone_step_cache_1a2b:/root/.m2"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if got := strings.Join(client.created[0].HostConfig.Binds, " "); got != "drone_step_cache_1a2b:/root/.m2" {
+		t.Errorf("Wanted cache volume mounted in the step, got %q", got)
+	}
+
+	engine.ContainerRemove("drone_1")
+	if len(client.removedVolumes) != 0 {
+		t.Errorf("Wanted cache volume kept after teardown, got %v removed", client.removedVolumes)
+	}
+}
+
+func TestContainerStartFiles(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client)
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:    "drone_1",
+		Image: "golang:1.5",
+		Files: map[string]string{
+			"/root/.ssh/id_rsa":   "ssh key",
+			"/root/.gcp/key.json": "service account",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if len(client.volumes) != 2 {
+		t.Fatalf("Wanted a volume for each secret file directory, got %d", len(client.volumes))
+	}
+	for _, volume := range client.volumes {
+		if volume.DriverOpts["type"] != "tmpfs" {
+			t.Errorf("Wanted tmpfs volume, got driver options %v", volume.DriverOpts)
+		}
+	}
+	if len(client.created) != 2 {
+		t.Fatalf("Wanted helper and step containers created, got %d", len(client.created))
+	}
+
+	helper := client.created[0]
+	if got := strings.Join(helper.Env, " "); got != "DRONE_SECRET_FILE_0=service account DRONE_SECRET_FILE_1=ssh key" {
+		t.Errorf("Wanted secret files passed to the helper environment, got %q", got)
+	}
+	if got := helper.Cmd[0]; !strings.Contains(got, `printf '%s' "$DRONE_SECRET_FILE_1" > /secrets/1/'id_rsa'`) {
+		t.Errorf("Wanted secret file written by the helper, got script %q", got)
+	}
+
+	step := client.created[1]
+	if got := strings.Join(step.HostConfig.Binds, " "); got != "drone_1_secrets_0:/root/.gcp drone_1_secrets_1:/root/.ssh" {
+		t.Errorf("Wanted secret volumes mounted in the step, got %q", got)
+	}
+	if len(step.Env) != 0 {
+		t.Errorf("Wanted secret files omitted from the environment, got %v", step.Env)
+	}
+
+	engine.ContainerRemove("drone_1")
+	if got := strings.Join(client.removedVolumes, " "); got != "drone_1_secrets_0 drone_1_secrets_1" {
+		t.Errorf("Wanted secret volumes removed with the container, got %q", got)
+	}
+}
+
+func TestContainerStartFilesHelperImage(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client, WithHelperImage("registry.internal/library/busybox:1.25"))
+
+	_, err := engine.ContainerStart(&yaml.Container{
+		ID:    "drone_1",
+		Image: "golang:1.5",
+		Files: map[string]string{"/root/.ssh/id_rsa": "ssh key"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if got := client.created[0].Image; got != "registry.internal/library/busybox:1.25" {
+		t.Errorf("Wanted helper container run with the configured image, got %q", got)
+	}
+}
+
+func TestContainerRemoveFiles(t *testing.T) {
+	client := &fakeClient{ids: map[string]string{"drone_1": "4e2a6d8c3f1b", "drone_2": "9f3b7c1d2e4a"}}
+	engine := NewClient(client)
+
+	files := map[string]string{"/root/.ssh/id_rsa": "ssh key"}
+	id, err := engine.ContainerStart(&yaml.Container{ID: "drone_1", Image: "golang:1.5", Files: files})
+	if err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	if id != "4e2a6d8c3f1b" {
+		t.Fatalf("Wanted the container ID returned by the daemon, got %q", id)
+	}
+	engine.ContainerRemove(id)
+	if got := strings.Join(client.removedVolumes, " "); got != "drone_1_secrets_0" {
+		t.Errorf("Wanted secret volumes removed with the container ID, got %q", got)
+	}
+
+	client.removedVolumes = nil
+	if _, err := engine.ContainerStart(&yaml.Container{ID: "drone_2", Image: "golang:1.5", Files: files}); err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	engine.ContainerRemove("drone_2")
+	if got := strings.Join(client.removedVolumes, " "); got != "drone_2_secrets_0" {
+		t.Errorf("Wanted secret volumes removed with the container name, got %q", got)
+	}
+	if n := len(engine.(*dockerEngine).volumes); n != 0 {
+		t.Errorf("Wanted no secret volumes tracked after removal, got %d", n)
+	}
+}
+
+func TestContainerRemoveOtherFiles(t *testing.T) {
+	client := &fakeClient{}
+	engine := NewClient(client)
+
+	files := map[string]string{"/root/.ssh/id_rsa": "ssh key"}
+	if _, err := engine.ContainerStart(&yaml.Container{ID: "drone_1_secrets", Image: "golang:1.5", Files: files}); err != nil {
+		t.Fatalf("Wanted container started, got error %q", err)
+	}
+	engine.ContainerRemove("drone_1")
+	if len(client.removedVolumes) != 0 {
+		t.Errorf("Wanted secret volumes of other containers kept, got %v removed", client.removedVolumes)
+	}
+	engine.ContainerRemove("drone_1_secrets")
+	if got := strings.Join(client.removedVolumes, " "); got != "drone_1_secrets_secrets_0" {
+		t.Errorf("Wanted secret volumes removed with the container, got %q", got)
+	}
+}
+
+func TestImageBuild(t *testing.T) {
+	client := &fakeClient{
+		attached: "build context",
+		build: `{"stream":"Step 1 : FROM golang:1.6\n"}` +
+			`{"stream":"Successfully built 4e2a6d8c3f1b\n"}`,
+	}
+	engine := NewClient(client)
+
+	rc, err := engine.ImageBuild(&yaml.Container{
+		ID:          "drone_1",
+		Image:       "octocat/env:latest",
+		VolumesFrom: []string{"drone_ambassador"},
+		ImageBuild: &yaml.ImageBuild{
+			Context:    "/drone/src/github.com/octocat/hello-world/docker",
+			Dockerfile: "Dockerfile.build",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Wanted image build, got error %q", err)
+	}
+	out, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("Wanted build output, got error %q", err)
+	}
+	if want := "Step 1 : FROM golang:1.6\nSuccessfully built 4e2a6d8c3f1b\n"; string(out) != want {
+		t.Errorf("Wanted build output %q, got %q", want, out)
+	}
+
+	if len(client.created) != 1 {
+		t.Fatalf("Wanted build context container created")
+	}
+	if got := strings.Join(client.created[0].Cmd, " "); got != "-c -C /drone/src/github.com/octocat/hello-world/docker ." {
+		t.Errorf("Wanted build context archived from the workspace, got command %q", got)
+	}
+	if got := client.created[0].HostConfig.VolumesFrom; len(got) != 1 || got[0] != "drone_ambassador" {
+		t.Errorf("Wanted build context container to share volumes, got %v", got)
+	}
+	if got := string(client.context); got != "build context" {
+		t.Errorf("Wanted build context %q, got %q", "build context", got)
+	}
+	if client.built.RepoName != "octocat/env:latest" {
+		t.Errorf("Wanted image tag %q, got %q", "octocat/env:latest", client.built.RepoName)
+	}
+	if client.built.DockerfileName != "Dockerfile.build" {
+		t.Errorf("Wanted Dockerfile %q, got %q", "Dockerfile.build", client.built.DockerfileName)
+	}
+}
+
+func TestImageBuildError(t *testing.T) {
+	client := &fakeClient{
+		build: `{"stream":"Step 1 : RUN make\n"}` +
+			`{"error":"The command '/bin/sh -c make' returned a non-zero code: 2"}`,
+	}
+	engine := NewClient(client)
+
+	rc, err := engine.ImageBuild(&yaml.Container{
+		Image:      "octocat/env:latest",
+		ImageBuild: &yaml.ImageBuild{Context: "/drone/src"},
+	})
+	if err != nil {
+		t.Fatalf("Wanted image build, got error %q", err)
+	}
+	_, err = ioutil.ReadAll(rc)
+	if err == nil || err.Error() != "The command '/bin/sh -c make' returned a non-zero code: 2" {
+		t.Errorf("Wanted build error, got %v", err)
+	}
+}
+
+func TestContainerWaitReconnect(t *testing.T) {
+	disconnect := errors.New("connection refused")
+	client := &fakeClient{
+		waits: []dockerclient.WaitResult{
+			{ExitCode: -1, Error: disconnect},
+			{ExitCode: 2},
+		},
+		inspects: []fakeInspect{
+			{err: disconnect},
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{ExitCode: 2}}},
+		},
+	}
+	engine := NewClientRetry(client, 3, 0)
+
+	state, err := engine.ContainerWait("drone_1")
+	if err != nil {
+		t.Fatalf("Wanted exit code recovered, got error %q", err)
+	}
+	if state.ExitCode != 2 {
+		t.Errorf("Wanted exit code 2, got %d", state.ExitCode)
+	}
+}
+
+func TestContainerWaitRunning(t *testing.T) {
+	client := &fakeClient{
+		inspects: []fakeInspect{
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{Running: true}}},
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{ExitCode: 1}}},
+		},
+	}
+	engine := NewClientRetry(client, 3, 0)
+
+	state, err := engine.ContainerWait("drone_1")
+	if err != nil {
+		t.Fatalf("Wanted exit code recovered, got error %q", err)
+	}
+	if state.ExitCode != 1 {
+		t.Errorf("Wanted exit code 1, got %d", state.ExitCode)
+	}
+}
+
+func TestContainerWaitWatchdog(t *testing.T) {
+	client := &fakeClient{
+		stuck: true,
+		inspects: []fakeInspect{
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{Running: true}}},
+			{info: &dockerclient.ContainerInfo{State: &dockerclient.State{ExitCode: 3}}},
+		},
+	}
+	engine := NewClientRetry(client, 3, 0, WithWaitWatchdog(time.Millisecond))
+
+	state, err := engine.ContainerWait("drone_1")
+	if err != nil {
+		t.Fatalf("Wanted exit code recovered, got error %q", err)
+	}
+	if state.ExitCode != 3 {
+		t.Errorf("Wanted exit code 3, got %d", state.ExitCode)
+	}
+	if client.inspected != 3 {
+		t.Errorf("Wanted container inspected until it exited, got %d inspects", client.inspected)
+	}
+}
+
+func TestContainerWaitWatchdogDrain(t *testing.T) {
+	client := &fakeClient{
+		stuck:    true,
+		release:  make(chan struct{}),
+		sent:     make(chan struct{}),
+		inspects: []fakeInspect{{info: &dockerclient.ContainerInfo{State: &dockerclient.State{ExitCode: 3}}}},
+	}
+	engine := NewClient(client, WithWaitWatchdog(time.Millisecond))
+
+	if _, err := engine.ContainerWait("drone_1"); err != nil {
+		t.Fatalf("Wanted exit code recovered, got error %q", err)
+	}
+	close(client.release)
+	select {
+	case <-client.sent:
+	case <-time.After(time.Second):
+		t.Errorf("Wanted the wait result drained after the watchdog fired")
+	}
+}
+
+func TestContainerWaitRemoved(t *testing.T) {
+	client := &fakeClient{
+		inspects: []fakeInspect{
+			{err: dockerclient.ErrNotFound},
+		},
+	}
+	engine := NewClientRetry(client, 3, 0)
+
+	_, err := engine.ContainerWait("drone_1")
+	if want := "Container drone_1 was removed before it exited"; err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+}
+
+func TestContainerWaitGiveUp(t *testing.T) {
+	client := &fakeClient{
+		inspects: []fakeInspect{
+			{err: errors.New("connection refused")},
+		},
+	}
+	engine := NewClientRetry(client, 2, 0)
+
+	_, err := engine.ContainerWait("drone_1")
+	if want := "Cannot wait for container drone_1. connection refused"; err == nil || err.Error() != want {
+		t.Errorf("Wanted error %q, got %v", want, err)
+	}
+	if client.inspected != 3 {
+		t.Errorf("Wanted container inspected 3 times, got %d", client.inspected)
+	}
+}
+
+// fakeClient is a fake Docker client that records the containers it creates
+// and the images it builds. Methods that are not implemented panic.
+type fakeClient struct {
+	dockerclient.Client
+
+	created []*dockerclient.ContainerConfig
+	pulled  []*dockerclient.AuthConfig
+
+	// ids maps the container names to the container IDs returned when
+	// creating a container, which default to the name.
+	ids map[string]string
+
+	// images records the pulled images, and pullErrs the errors returned
+	// when pulling an image.
+	images   []string
+	pullErrs map[string]error
+
+	// notFound is the number of times pulling an image returns not found
+	// before the pull succeeds.
+	notFound map[string]int
+
+	// removedImages records the removed images.
+	removedImages []string
+
+	// attached is written to the attached container stdout, and build is
+	// returned as the image build output.
+	attached string
+	build    string
+	built    *dockerclient.BuildImage
+	context  []byte
+
+	// imageEnv, imageOS and imageArch are returned as the image
+	// environment and platform.
+	imageEnv  []string
+	imageOS   string
+	imageArch string
+
+	// imageSize is returned as the image virtual size.
+	imageSize int64
+
+	// imageEntrypoint and imageCmd are returned as the image entrypoint
+	// and command.
+	imageEntrypoint []string
+	imageCmd        []string
+
+	// imageErrs are the errors returned when inspecting an image, and
+	// missing the images that are not found until pulled.
+	imageErrs map[string]error
+	missing   map[string]bool
+
+	// version is returned as the daemon version, or versionErr if set.
+	version    *dockerclient.Version
+	versionErr error
+
+	// waits and inspects are returned in order when waiting for and
+	// inspecting a container. The last result is repeated. Waiting never
+	// returns if stuck is set, until release is closed, after which the
+	// wait result is sent unbuffered and sent is closed.
+	stuck     bool
+	release   chan struct{}
+	sent      chan struct{}
+	waits     []dockerclient.WaitResult
+	inspects  []fakeInspect
+	inspected int
+
+	// volumes records the created volumes, and removedVolumes the names of
+	// the removed volumes.
+	volumes        []*dockerclient.VolumeCreateRequest
+	removedVolumes []string
+
+	// networks records the created networks.
+	networks []*dockerclient.NetworkCreate
+
+	// stats are streamed as the container stats samples.
+	stats []dockerclient.StatsOrError
+}
+
+// fakeInspect is the result of inspecting a container.
+type fakeInspect struct {
+	info *dockerclient.ContainerInfo
+	err  error
+}
+
+func (c *fakeClient) Wait(id string) <-chan dockerclient.WaitResult {
+	if c.stuck {
+		ch := make(chan dockerclient.WaitResult)
+		if c.release != nil {
+			go func() {
+				<-c.release
+				ch <- dockerclient.WaitResult{}
+				close(c.sent)
+			}()
+		}
+		return ch
+	}
+	ch := make(chan dockerclient.WaitResult, 1)
+	var result dockerclient.WaitResult
+	if len(c.waits) != 0 {
+		result = c.waits[0]
+	}
+	if len(c.waits) > 1 {
+		c.waits = c.waits[1:]
+	}
+	ch <- result
+	return ch
+}
+
+func (c *fakeClient) ContainerStats(id string, stop <-chan struct{}) (<-chan dockerclient.StatsOrError, error) {
+	samples := make(chan dockerclient.StatsOrError, len(c.stats))
+	for _, sample := range c.stats {
+		samples <- sample
+	}
+	close(samples)
+	return samples, nil
+}
+
+func (c *fakeClient) InspectContainer(id string) (*dockerclient.ContainerInfo, error) {
+	c.inspected++
+	if len(c.inspects) == 0 {
+		return &dockerclient.ContainerInfo{State: &dockerclient.State{}}, nil
+	}
+	result := c.inspects[0]
+	if len(c.inspects) > 1 {
+		c.inspects = c.inspects[1:]
+	}
+	return result.info, result.err
+}
+
+func (c *fakeClient) Version() (*dockerclient.Version, error) {
+	return c.version, c.versionErr
+}
+
+func (c *fakeClient) InspectImage(id string) (*dockerclient.ImageInfo, error) {
+	if err := c.imageErrs[id]; err != nil {
+		return nil, err
+	}
+	if c.missing[id] {
+		return nil, dockerclient.ErrNotFound
+	}
+	return &dockerclient.ImageInfo{
+		Id:           id,
+		Os:           c.imageOS,
+		Architecture: c.imageArch,
+		VirtualSize:  c.imageSize,
+		Config: &dockerclient.ContainerConfig{
+			Env:        c.imageEnv,
+			Entrypoint: c.imageEntrypoint,
+			Cmd:        c.imageCmd,
+		},
+	}, nil
+}
+
+func (c *fakeClient) PullImage(name string, auth *dockerclient.AuthConfig) error {
+	c.pulled = append(c.pulled, auth)
+	c.images = append(c.images, name)
+	if c.notFound[name] > 0 {
+		c.notFound[name]--
+		return dockerclient.ErrNotFound
+	}
+	if err := c.pullErrs[name]; err != nil {
+		return err
+	}
+	delete(c.missing, name)
+	return nil
+}
+
+func (c *fakeClient) RemoveImage(name string, force bool) ([]*dockerclient.ImageDelete, error) {
+	c.removedImages = append(c.removedImages, name)
+	return nil, nil
+}
+
+func (c *fakeClient) CreateContainer(config *dockerclient.ContainerConfig, name string, auth *dockerclient.AuthConfig) (string, error) {
+	c.created = append(c.created, config)
+	if id, ok := c.ids[name]; ok {
+		return id, nil
+	}
+	return name, nil
+}
+
+func (c *fakeClient) StartContainer(id string, config *dockerclient.HostConfig) error {
+	return nil
+}
+
+func (c *fakeClient) AttachContainer(id string, options *dockerclient.AttachOptions) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	header := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(c.attached)))
+	buf.Write(header)
+	buf.WriteString(c.attached)
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (c *fakeClient) StopContainer(id string, timeout int) error {
+	return nil
+}
+
+func (c *fakeClient) KillContainer(id, signal string) error {
+	return nil
+}
+
+func (c *fakeClient) RemoveContainer(id string, force, volumes bool) error {
+	return nil
+}
+
+func (c *fakeClient) ListVolumes() ([]*dockerclient.Volume, error) {
+	var volumes []*dockerclient.Volume
+	for _, request := range c.volumes {
+		volumes = append(volumes, &dockerclient.Volume{Name: request.Name})
+	}
+	return volumes, nil
+}
+
+func (c *fakeClient) CreateVolume(request *dockerclient.VolumeCreateRequest) (*dockerclient.Volume, error) {
+	c.volumes = append(c.volumes, request)
+	return &dockerclient.Volume{Name: request.Name}, nil
+}
+
+func (c *fakeClient) CreateNetwork(config *dockerclient.NetworkCreate) (*dockerclient.NetworkCreateResponse, error) {
+	c.networks = append(c.networks, config)
+	return &dockerclient.NetworkCreateResponse{ID: config.Name}, nil
+}
+
+func (c *fakeClient) RemoveVolume(name string) error {
+	c.removedVolumes = append(c.removedVolumes, name)
+	return nil
+}
+
+func (c *fakeClient) BuildImage(image *dockerclient.BuildImage) (io.ReadCloser, error) {
+	c.built = image
+	c.context, _ = ioutil.ReadAll(image.Context)
+	return ioutil.NopCloser(strings.NewReader(c.build)), nil
+}
@@ -1,13 +1,109 @@
 package docker
 
 import (
+	"time"
+
 	"github.com/drone/drone-exec/build"
 	"github.com/samalba/dockerclient"
 )
 
+// Default retry policy used when waiting for a container and the connection
+// to the daemon is lost.
+const (
+	DefaultWaitRetries  = 5
+	DefaultWaitBackoff  = 5 * time.Second
+	DefaultWaitWatchdog = 5 * time.Minute
+)
+
+// Default retry policy used when pulling an image that is not found.
+const (
+	DefaultPullRetries = 0
+	DefaultPullBackoff = 5 * time.Second
+)
+
+// Option configures the Docker engine.
+type Option func(*dockerEngine)
+
+// WithCredentialHelper returns an Option that obtains the registry
+// credentials from the credential helper when pulling images that do not
+// define credentials in the Yaml.
+func WithCredentialHelper(helper *CredentialHelper) Option {
+	return func(e *dockerEngine) {
+		e.creds = helper
+	}
+}
+
+// WithMirrors returns an Option that pulls images from the registry mirrors,
+// tried in order, when an image cannot be pulled from its registry.
+func WithMirrors(mirrors []string) Option {
+	return func(e *dockerEngine) {
+		e.mirrors = mirrors
+	}
+}
+
+// WithWaitWatchdog returns an Option that inspects a container when waiting
+// for the container does not return within the interval, recovering the exit
+// code if the container exited while the wait request is stuck.
+func WithWaitWatchdog(interval time.Duration) Option {
+	return func(e *dockerEngine) {
+		e.watchdog = interval
+	}
+}
+
+// WithPullRetries returns an Option that retries pulling an image that is not
+// found, waiting backoff between retries, for images that have not propagated
+// to the registry yet.
+func WithPullRetries(retries int, backoff time.Duration) Option {
+	return func(e *dockerEngine) {
+		e.pullRetries = retries
+		e.pullBackoff = backoff
+	}
+}
+
+// WithMaxImageSize returns an Option that fails to start unprivileged
+// containers when the pulled image exceeds the size in bytes, removing the
+// image.
+func WithMaxImageSize(size int64) Option {
+	return func(e *dockerEngine) {
+		e.maxImageSize = size
+	}
+}
+
+// WithHelperImage returns an Option that runs the helper containers, which
+// archive the image build context and read and write files in the volumes,
+// with the image instead of the default image. The image must include a shell
+// and standard unix utilities.
+func WithHelperImage(image string) Option {
+	return func(e *dockerEngine) {
+		if image != "" {
+			e.helperImage = image
+		}
+	}
+}
+
 // NewClient returns a new Docker engine using the provided Docker client.
-func NewClient(client dockerclient.Client) build.Engine {
-	return &dockerEngine{client}
+func NewClient(client dockerclient.Client, opts ...Option) build.Engine {
+	return NewClientRetry(client, DefaultWaitRetries, DefaultWaitBackoff, opts...)
+}
+
+// NewClientRetry returns a new Docker engine using the provided Docker client,
+// retrying to wait for a container when the connection to the daemon is lost.
+func NewClientRetry(client dockerclient.Client, retries int, backoff time.Duration, opts ...Option) build.Engine {
+	e := &dockerEngine{
+		client:  client,
+		retries: retries,
+		backoff: backoff,
+		volumes: map[string][]string{},
+		names:   map[string]string{},
+		pulled:  map[string]string{},
+		pulls:   map[string]bool{},
+
+		helperImage: contextImage,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // New returns a new Docker engine from the provided DOCKER_HOST and
@@ -0,0 +1,29 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultHost is the address of the Docker daemon socket.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Host returns the address of the Docker daemon. The explicit address takes
+// precedence, followed by the address from the DOCKER_HOST environment
+// variable, the rootless Docker socket in the user runtime directory if the
+// socket exists, and the default socket.
+func Host(explicit, env, runtimeDir string) string {
+	switch {
+	case explicit != "":
+		return explicit
+	case env != "":
+		return env
+	}
+	if runtimeDir != "" {
+		socket := filepath.Join(runtimeDir, "docker.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return DefaultHost
+}
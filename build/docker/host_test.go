@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	rootless, err := ioutil.TempDir("", "drone_runtime_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootless)
+	socket := filepath.Join(rootless, "docker.sock")
+	ioutil.WriteFile(socket, nil, 0600)
+
+	missing, err := ioutil.TempDir("", "drone_runtime_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(missing)
+
+	tests := []struct {
+		explicit, env, runtimeDir string
+		want                      string
+	}{
+		{"tcp://10.0.0.1:2376", "tcp://10.0.0.2:2376", rootless, "tcp://10.0.0.1:2376"},
+		{"", "tcp://10.0.0.2:2376", rootless, "tcp://10.0.0.2:2376"},
+		{"", "", rootless, "unix://" + socket},
+		{"", "", missing, DefaultHost},
+		{"", "", "", DefaultHost},
+	}
+	for _, test := range tests {
+		got := Host(test.explicit, test.env, test.runtimeDir)
+		if got != test.want {
+			t.Errorf("Host(%q, %q, %q) = %q, want %q",
+				test.explicit, test.env, test.runtimeDir, got, test.want)
+		}
+	}
+}
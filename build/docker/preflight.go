@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/samalba/dockerclient"
+)
+
+// MinAPIVersion is the minimum supported Docker daemon API version.
+const MinAPIVersion = "1.21"
+
+// Preflight checks the connection to the Docker daemon at the given host
+// and returns an error describing the cause if the daemon is unreachable
+// or the daemon API version is not supported.
+func Preflight(client dockerclient.Client, host string) error {
+	version, err := client.Version()
+	if err != nil {
+		switch cause(err) {
+		case syscall.ENOENT:
+			return fmt.Errorf("Docker socket %s does not exist. Is the Docker daemon installed?", host)
+		case syscall.EACCES, syscall.EPERM:
+			return fmt.Errorf("Permission denied connecting to the Docker socket %s. Is the agent user in the docker group?", host)
+		case syscall.ECONNREFUSED:
+			return fmt.Errorf("Docker daemon is not running at %s", host)
+		default:
+			return fmt.Errorf("Cannot connect to the Docker daemon at %s. %s", host, err)
+		}
+	}
+	if !versionAtLeast(version.ApiVersion, MinAPIVersion) {
+		return fmt.Errorf("Docker daemon API version %s is not supported, requires %s or later",
+			version.ApiVersion, MinAPIVersion)
+	}
+	return nil
+}
+
+// cause returns the underlying cause of the connection error.
+func cause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// versionAtLeast returns true if the major.minor version is greater than or
+// equal to the minimum major.minor version.
+func versionAtLeast(version, min string) bool {
+	v := parseVersion(version)
+	m := parseVersion(min)
+	if v[0] != m[0] {
+		return v[0] > m[0]
+	}
+	return v[1] >= m[1]
+}
+
+func parseVersion(version string) [2]int {
+	var out [2]int
+	for i, part := range strings.SplitN(version, ".", 2) {
+		out[i], _ = strconv.Atoi(part)
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestPreflight(t *testing.T) {
+	host := "unix:///var/run/docker.sock"
+	dial := func(errno error) error {
+		return &url.Error{
+			Op:  "Get",
+			URL: "http://unix.sock/v1.15/version",
+			Err: &net.OpError{
+				Op:  "dial",
+				Net: "unix",
+				Err: os.NewSyscallError("connect", errno),
+			},
+		}
+	}
+
+	tests := []struct {
+		version *dockerclient.Version
+		err     error
+		want    string
+	}{
+		{
+			err:  dial(syscall.ENOENT),
+			want: "Docker socket unix:///var/run/docker.sock does not exist. Is the Docker daemon installed?",
+		},
+		{
+			err:  dial(syscall.EACCES),
+			want: "Permission denied connecting to the Docker socket unix:///var/run/docker.sock. Is the agent user in the docker group?",
+		},
+		{
+			err:  dial(syscall.ECONNREFUSED),
+			want: "Docker daemon is not running at unix:///var/run/docker.sock",
+		},
+		{
+			err:  errors.New("unexpected EOF"),
+			want: "Cannot connect to the Docker daemon at unix:///var/run/docker.sock. unexpected EOF",
+		},
+		{
+			version: &dockerclient.Version{ApiVersion: "1.20"},
+			want:    "Docker daemon API version 1.20 is not supported, requires 1.21 or later",
+		},
+		{
+			version: &dockerclient.Version{ApiVersion: "1.21"},
+		},
+		{
+			version: &dockerclient.Version{ApiVersion: "1.9"},
+			want:    "Docker daemon API version 1.9 is not supported, requires 1.21 or later",
+		},
+		{
+			version: &dockerclient.Version{ApiVersion: "2.0"},
+		},
+	}
+
+	for _, test := range tests {
+		client := &fakeClient{version: test.version, versionErr: test.err}
+		err := Preflight(client, host)
+		if test.want == "" && err != nil {
+			t.Errorf("Wanted no error, got %q", err)
+		}
+		if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("Wanted error %q, got %v", test.want, err)
+		}
+	}
+}
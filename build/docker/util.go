@@ -1,13 +1,40 @@
 package docker
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/drone/drone-exec/yaml"
 	"github.com/samalba/dockerclient"
 )
 
+// initPath is the path of the init binary on the docker host, which is
+// mounted in the container at initTarget.
+const (
+	initPath   = "/usr/bin/docker-init"
+	initTarget = "/dev/init"
+)
+
+// withInit configures the container to run the init binary of the docker
+// host as PID 1, in front of the container or image entrypoint. The Docker
+// client does not support the host config init option, so the init is
+// mounted and prepended to the entrypoint instead, as the daemon would.
+func withInit(conf *dockerclient.ContainerConfig, image *dockerclient.ImageInfo) {
+	entrypoint, cmd := conf.Entrypoint, conf.Cmd
+	if len(entrypoint) == 0 && image != nil && image.Config != nil {
+		entrypoint = image.Config.Entrypoint
+		if len(cmd) == 0 {
+			cmd = image.Config.Cmd
+		}
+	}
+	conf.Entrypoint = append([]string{initTarget, "--"}, entrypoint...)
+	conf.Cmd = cmd
+	conf.HostConfig.Binds = append(conf.HostConfig.Binds, initPath+":"+initTarget+":ro")
+}
+
 // helper function that converts the Continer data structure to the exepcted
 // dockerclient.ContainerConfig.
 func toContainerConfig(c *yaml.Container) *dockerclient.ContainerConfig {
@@ -17,10 +44,12 @@ func toContainerConfig(c *yaml.Container) *dockerclient.ContainerConfig {
 		Cmd:        c.Command,
 		Entrypoint: c.Entrypoint,
 		WorkingDir: c.WorkingDir,
+		User:       c.User,
 		HostConfig: dockerclient.HostConfig{
 			Privileged:       c.Privileged,
 			NetworkMode:      c.Network,
 			Memory:           c.MemLimit,
+			MemorySwap:       c.MemSwapLimit,
 			CpuShares:        c.CPUShares,
 			CpuQuota:         c.CPUQuota,
 			CpusetCpus:       c.CPUSet,
@@ -29,6 +58,9 @@ func toContainerConfig(c *yaml.Container) *dockerclient.ContainerConfig {
 		},
 	}
 
+	if c.MemSwappiness != nil {
+		config.HostConfig.MemorySwappiness = *c.MemSwappiness
+	}
 	if len(config.Entrypoint) == 0 {
 		config.Entrypoint = nil
 	}
@@ -38,6 +70,9 @@ func toContainerConfig(c *yaml.Container) *dockerclient.ContainerConfig {
 	if len(c.ExtraHosts) > 0 {
 		config.HostConfig.ExtraHosts = c.ExtraHosts
 	}
+	if len(c.Links) != 0 {
+		config.HostConfig.Links = c.Links
+	}
 	if len(c.DNS) != 0 {
 		config.HostConfig.Dns = c.DNS
 	}
@@ -98,3 +133,171 @@ func toEnvironmentSlice(env map[string]string) []string {
 	}
 	return envs
 }
+
+// helper function that returns the expanded environment variables of the
+// container that reference other variables, in sorted order. Variables defined
+// in the container environment take precedence.
+func environRefs(c *yaml.Container, inherited []string) []string {
+	var env []string
+	for k, v := range expandEnviron(c.EnvironRefs, c.Environment, inherited) {
+		if _, ok := c.Environment[k]; !ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// helper function that expands the environment variables that reference
+// other variables with the ${NAME} syntax. References resolve to the other
+// referencing variables, the container environment and the inherited image
+// environment, in order. A self-reference, such as PATH=${PATH}:/go/bin,
+// resolves to the inherited value. Undefined references expand to an empty
+// string.
+func expandEnviron(refs, env map[string]string, image []string) map[string]string {
+	inherited := map[string]string{}
+	for _, kv := range image {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			inherited[parts[0]] = parts[1]
+		}
+	}
+	for k, v := range env {
+		inherited[k] = v
+	}
+
+	out := map[string]string{}
+	visiting := map[string]bool{}
+	var resolve func(key string) string
+	resolve = func(key string) string {
+		if v, ok := out[key]; ok {
+			return v
+		}
+		visiting[key] = true
+		v := expand(refs[key], func(name string) string {
+			if _, ok := refs[name]; ok && !visiting[name] {
+				return resolve(name)
+			}
+			if v, ok := inherited[name]; ok {
+				return v
+			}
+			logrus.Debugf("Environment variable %s references undefined variable %s", key, name)
+			return ""
+		})
+		delete(visiting, key)
+		out[key] = v
+		return v
+	}
+
+	// the variables are resolved in sorted order so that circular references
+	// resolve consistently.
+	var keys []string
+	for k := range refs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resolve(k)
+	}
+	return out
+}
+
+// helper function that replaces the ${NAME} references in the string with the
+// mapped values. A $$ is replaced with a literal $, which escapes references,
+// and any other $ is kept as is.
+func expand(s string, mapping func(string) string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			buf.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end <= 0 {
+				buf.WriteByte('$')
+				continue
+			}
+			buf.WriteString(mapping(s[i+2 : i+2+end]))
+			i += end + 2
+		default:
+			buf.WriteByte('$')
+		}
+	}
+	return buf.String()
+}
+
+// isNotFound returns true if the image pull error reports the image is not
+// found, either as a 404 response or as an error in the pull progress, for
+// example when the manifest of the image tag does not exist.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == dockerclient.ErrNotFound ||
+		strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+// cpuPercent returns the cpu usage between the samples as a percentage of one
+// cpu, which is zero for the first sample.
+func cpuPercent(prev, cur *dockerclient.Stats) float64 {
+	if prev == nil {
+		return 0
+	}
+	usage := float64(cur.CpuStats.CpuUsage.TotalUsage) - float64(prev.CpuStats.CpuUsage.TotalUsage)
+	system := float64(cur.CpuStats.SystemUsage) - float64(prev.CpuStats.SystemUsage)
+	if usage <= 0 || system <= 0 {
+		return 0
+	}
+	return usage / system * float64(len(cur.CpuStats.CpuUsage.PercpuUsage)) * 100
+}
+
+// mirrorImage rewrites the image reference to the registry mirror, removing
+// the registry host from the image. Images of the default registry without
+// a namespace are in the library namespace.
+func mirrorImage(mirror, image string) string {
+	path := image
+	if registryHost(image) != defaultRegistry {
+		path = strings.SplitN(image, "/", 2)[1]
+	} else if !strings.Contains(image, "/") {
+		path = "library/" + image
+	}
+	return strings.TrimRight(mirror, "/") + "/" + path
+}
+
+// imageRepo returns the image reference without the tag. The registry port is
+// not mistaken for the tag.
+func imageRepo(image string) string {
+	i := strings.LastIndex(image, "/") + 1
+	if j := strings.Index(image[i:], ":"); j != -1 {
+		return image[:i+j]
+	}
+	return image
+}
+
+// parseEnvFile parses the env file variables in key=value format, one per
+// line. Blank lines and comments are ignored, and values may be quoted.
+func parseEnvFile(data string) []string {
+	var env []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), parts[1]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	return env
+}
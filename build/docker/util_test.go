@@ -1,11 +1,136 @@
 package docker
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/drone/drone-exec/yaml"
 )
 
 func Test_toContainerConfig(t *testing.T) {
-	t.Skip()
+	swappiness := int64(0)
+	c := &yaml.Container{
+		MemLimit:      1024,
+		MemSwapLimit:  2048,
+		MemSwappiness: &swappiness,
+	}
+	config := toContainerConfig(c)
+	if got, want := config.HostConfig.Memory, int64(1024); got != want {
+		t.Errorf("Wanted memory limit %d got %d", want, got)
+	}
+	if got, want := config.HostConfig.MemorySwap, int64(2048); got != want {
+		t.Errorf("Wanted memory swap limit %d got %d", want, got)
+	}
+	if got, want := config.HostConfig.MemorySwappiness, int64(0); got != want {
+		t.Errorf("Wanted memory swappiness %d got %d", want, got)
+	}
+
+	// memory swappiness defaults to the daemon default when unset.
+	config = toContainerConfig(&yaml.Container{})
+	if got, want := config.HostConfig.MemorySwappiness, int64(-1); got != want {
+		t.Errorf("Wanted default memory swappiness %d got %d", want, got)
+	}
+}
+
+func Test_toContainerConfigExtraHosts(t *testing.T) {
+	c := &yaml.Container{
+		ExtraHosts: []string{"database:127.0.0.1"},
+	}
+	config := toContainerConfig(c)
+	if got := config.HostConfig.ExtraHosts; len(got) != 1 || got[0] != "database:127.0.0.1" {
+		t.Errorf("Wanted extra hosts [database:127.0.0.1] got %v", got)
+	}
+}
+
+func Test_toContainerConfigCPUSet(t *testing.T) {
+	c := &yaml.Container{
+		CPUSet: "0-2",
+	}
+	config := toContainerConfig(c)
+	if got, want := config.HostConfig.CpusetCpus, "0-2"; got != want {
+		t.Errorf("Wanted cpuset %q got %q", want, got)
+	}
+}
+
+func Test_toContainerConfigLinks(t *testing.T) {
+	c := &yaml.Container{
+		Links: []string{"drone_postgres:database"},
+	}
+	config := toContainerConfig(c)
+	if got := config.HostConfig.Links; len(got) != 1 || got[0] != "drone_postgres:database" {
+		t.Errorf("Wanted links [drone_postgres:database] got %v", got)
+	}
+}
+
+func Test_toContainerConfigUser(t *testing.T) {
+	c := &yaml.Container{
+		User: "1000:1000",
+	}
+	config := toContainerConfig(c)
+	if got, want := config.User, "1000:1000"; got != want {
+		t.Errorf("Wanted user %q got %q", want, got)
+	}
+}
+
+func Test_expandEnviron(t *testing.T) {
+	image := []string{"PATH=/usr/local/bin:/usr/bin", "HOME=/home/octocat"}
+	env := map[string]string{"GOPATH": "/go", "HOME": "/root"}
+
+	var tests = []struct {
+		refs map[string]string
+		want map[string]string
+	}{
+		// self-reference resolves to the inherited image value.
+		{
+			refs: map[string]string{"PATH": "${PATH}:/x"},
+			want: map[string]string{"PATH": "/usr/local/bin:/usr/bin:/x"},
+		},
+		// container environment overrides the image environment.
+		{
+			refs: map[string]string{"CACHE": "${HOME}/.cache"},
+			want: map[string]string{"CACHE": "/root/.cache"},
+		},
+		// chained references resolve to the expanded value.
+		{
+			refs: map[string]string{
+				"GOBIN": "${GOPATH}/bin",
+				"TOOLS": "${GOBIN}/tools",
+				"PATH":  "${TOOLS}:${GOBIN}:${PATH}",
+			},
+			want: map[string]string{
+				"GOBIN": "/go/bin",
+				"TOOLS": "/go/bin/tools",
+				"PATH":  "/go/bin/tools:/go/bin:/usr/local/bin:/usr/bin",
+			},
+		},
+		// undefined references expand to empty.
+		{
+			refs: map[string]string{"FLAGS": "-v ${UNDEFINED}"},
+			want: map[string]string{"FLAGS": "-v "},
+		},
+		// $$ escapes a literal $, and other $ characters are kept.
+		{
+			refs: map[string]string{"PASSWORD": "pa$$w$rd-$${HOME}-${HOME}", "REGEX": "^a$|${GOPATH}"},
+			want: map[string]string{"PASSWORD": "pa$w$rd-${HOME}-/root", "REGEX": "^a$|/go"},
+		},
+		// unterminated references are kept.
+		{
+			refs: map[string]string{"FLAGS": "${HOME"},
+			want: map[string]string{"FLAGS": "${HOME"},
+		},
+		// circular references resolve to the inherited value.
+		{
+			refs: map[string]string{"A": "a${B}", "B": "b${A}"},
+			want: map[string]string{"A": "ab", "B": "b"},
+		},
+	}
+
+	for _, test := range tests {
+		got := expandEnviron(test.refs, env, image)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wanted expanded environment %v got %v", test.want, got)
+		}
+	}
 }
 
 func Test_toAuthConfig(t *testing.T) {
@@ -22,3 +147,44 @@ func Test_toEnvironmentSlice(t *testing.T) {
 		t.Errorf("Wanted envar %s got %s", want, got)
 	}
 }
+
+func Test_mirrorImage(t *testing.T) {
+	tests := []struct {
+		image, mirror, want string
+	}{
+		{"golang:1.5", "mirror.internal", "mirror.internal/library/golang:1.5"},
+		{"octocat/hello-world", "mirror.internal/", "mirror.internal/octocat/hello-world"},
+		{"gcr.io/octocat/hello-world:1.0", "mirror.internal/gcr", "mirror.internal/gcr/octocat/hello-world:1.0"},
+		{"localhost:5000/hello-world", "mirror.internal", "mirror.internal/hello-world"},
+	}
+	for _, test := range tests {
+		if got := mirrorImage(test.mirror, test.image); got != test.want {
+			t.Errorf("Wanted image %s rewritten to %q, got %q", test.image, test.want, got)
+		}
+	}
+}
+
+func Test_imageRepo(t *testing.T) {
+	tests := []struct {
+		image, want string
+	}{
+		{"golang:1.5", "golang"},
+		{"golang", "golang"},
+		{"localhost:5000/hello-world:1.0", "localhost:5000/hello-world"},
+		{"localhost:5000/hello-world", "localhost:5000/hello-world"},
+	}
+	for _, test := range tests {
+		if got := imageRepo(test.image); got != test.want {
+			t.Errorf("Wanted image %s repository %q, got %q", test.image, test.want, got)
+		}
+	}
+}
+
+func Test_parseEnvFile(t *testing.T) {
+	data := "# generated by the build step\nVERSION=1.2.3\n\nexport CHANNEL=\"beta\"\nNAME='hello world'\ninvalid\n"
+	got := parseEnvFile(data)
+	want := []string{"VERSION=1.2.3", "CHANNEL=beta", "NAME=hello world"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wanted env %v, got %v", want, got)
+	}
+}
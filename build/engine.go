@@ -6,11 +6,94 @@ import (
 	"github.com/drone/drone-exec/yaml"
 )
 
-// Engine defines the container runtime engine.
+// Engine defines the container runtime engine. The pipeline relies only on
+// this interface, allowing alternate container runtimes to be used in place
+// of Docker. Pod networking is expressed in the container configuration
+// itself, using a detached ambassador container from which every other
+// container inherits its volumes and network.
 type Engine interface {
+	// ContainerStart creates and starts the container, pulling the image if
+	// necessary, and returns the container identifier.
 	ContainerStart(*yaml.Container) (string, error)
+
+	// ContainerStop stops the container.
 	ContainerStop(string) error
+
+	// ContainerRemove stops and removes the container and its volumes.
 	ContainerRemove(string) error
+
+	// ContainerWait blocks until the container exits and returns its state.
 	ContainerWait(string) (*State, error)
+
+	// ContainerLogs returns a stream of the container stdout and stderr.
 	ContainerLogs(string) (io.ReadCloser, error)
+
+	// ImageBuild builds the image from the container build context and tags
+	// it with the container image name. It returns a stream of the build
+	// output, which returns an error when read if the build fails.
+	ImageBuild(*yaml.Container) (io.ReadCloser, error)
+
+	// ImageRemove removes the image.
+	ImageRemove(string) error
+
+	// NetworkCreate creates the network.
+	NetworkCreate(*yaml.Network) error
+
+	// NetworkRemove removes the named network.
+	NetworkRemove(string) error
+}
+
+// ImageTracker is implemented by engines that track the images pulled when
+// starting containers, allowing the images pulled by a build to be removed
+// on teardown.
+type ImageTracker interface {
+	// ContainerPulled returns true if the engine pulled the image when
+	// starting the container, and the image did not exist on the host
+	// before. Pulls are tracked for each container, since the engine may be
+	// shared by concurrent builds.
+	ContainerPulled(string) bool
+}
+
+// StatsCollector is implemented by engines that sample the resource usage of
+// running containers, allowing the peak usage of each step to be recorded.
+type StatsCollector interface {
+	// ContainerStats streams samples of the container resource usage until
+	// the container exits or the stop channel is closed, and then closes
+	// the returned channel.
+	ContainerStats(id string, stop <-chan struct{}) (<-chan *Stats, error)
+}
+
+// ContainerExecer is implemented by engines that execute a command in a
+// running container, allowing a step to run in a service container.
+type ContainerExecer interface {
+	// ContainerExec executes the entrypoint and command of the container
+	// configuration, with its environment and working directory, in the
+	// running container. It returns a stream of the command output, which
+	// returns an ExitError when read if the command exits with a non-zero
+	// exit code.
+	ContainerExec(id string, c *yaml.Container) (io.ReadCloser, error)
+}
+
+// VolumeInspector is implemented by engines that check whether a named volume
+// exists, allowing the pipeline to report whether each cache was restored.
+type VolumeInspector interface {
+	// VolumeExists returns true if the named volume exists.
+	VolumeExists(string) (bool, error)
+}
+
+// ContainerCopier is implemented by engines that copy files out of a
+// container, allowing the workspace archive to be read byte for byte instead
+// of through the container logs.
+type ContainerCopier interface {
+	// ContainerCopy returns a tar archive of the file or directory at the
+	// path in the container.
+	ContainerCopy(id, path string) (io.ReadCloser, error)
+}
+
+// ImageResolver is implemented by engines that resolve an image reference to
+// the digest of the image in its registry, allowing builds to pin images.
+type ImageResolver interface {
+	// ImageDigest pulls the image and returns its digest, for example
+	// sha256:4e2a6d8c3f1b.
+	ImageDigest(string) (string, error)
 }
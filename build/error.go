@@ -3,6 +3,8 @@ package build
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
 )
 
 var (
@@ -13,6 +15,26 @@ var (
 	// ErrTerm is used as a return value when the runner should terminate
 	// execution and exit. It is not returned as an error by any function.
 	ErrTerm = errors.New("Terminate")
+
+	// ErrImageResolver is returned when resolving an image digest with an
+	// engine that does not implement ImageResolver.
+	ErrImageResolver = errors.New("Engine cannot resolve image digests")
+
+	// ErrStatsCollector is returned when sampling the resource usage of a
+	// container with an engine that does not implement StatsCollector.
+	ErrStatsCollector = errors.New("Engine cannot sample container stats")
+
+	// ErrContainerExecer is returned when executing a step in a service
+	// with an engine that does not implement ContainerExecer.
+	ErrContainerExecer = errors.New("Engine cannot execute commands in containers")
+
+	// ErrVolumeInspector is returned when checking a cache volume with an
+	// engine that does not implement VolumeInspector.
+	ErrVolumeInspector = errors.New("Engine cannot inspect volumes")
+
+	// ErrContainerCopier is returned when copying files from a container
+	// with an engine that does not implement ContainerCopier.
+	ErrContainerCopier = errors.New("Engine cannot copy files from containers")
 )
 
 // An ExitError reports an unsuccessful exit.
@@ -35,3 +57,57 @@ type OomError struct {
 func (e *OomError) Error() string {
 	return fmt.Sprintf("%s : received oom kill", e.Name)
 }
+
+// An EngineError reports the container engine failed to run a step, such as
+// when the connection to the docker daemon is lost.
+type EngineError struct {
+	Err error
+}
+
+// Error reteurns the error message in string format.
+func (e *EngineError) Error() string {
+	return e.Err.Error()
+}
+
+// engineError returns the error as an EngineError if it reports a failure of
+// the container engine, such as a lost connection to the daemon. Other errors,
+// such as a missing image or an invalid configuration, are failures of the
+// build and returned unchanged, since retrying the build cannot fix them.
+func engineError(err error) error {
+	switch err.(type) {
+	case *EngineError:
+		return err
+	case net.Error:
+		return &EngineError{err}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &EngineError{err}
+	}
+	return err
+}
+
+// Retriable returns true if the error is an infrastructure failure, an oom
+// kill or an engine error, rather than a failure of the build itself. The
+// build may be retried from scratch on infrastructure failures.
+func Retriable(err error) bool {
+	switch err.(type) {
+	case *OomError, *EngineError:
+		return true
+	}
+	return false
+}
+
+// LogLimitExitCode is the exit code reported when the build is cancelled for
+// exceeding the maximum number of lines of console output.
+const LogLimitExitCode = 254
+
+// A LogLimitError reports the build exceeded the maximum number of lines of
+// console output, which often indicates a runaway process.
+type LogLimitError struct {
+	Limit int
+}
+
+// Error reteurns the error message in string format.
+func (e *LogLimitError) Error() string {
+	return fmt.Sprintf("maximum log lines exceeded (%d), build cancelled", e.Limit)
+}
@@ -1,6 +1,9 @@
 package build
 
 import (
+	"errors"
+	"io"
+	"net/url"
 	"testing"
 
 	"github.com/franela/goblin"
@@ -22,5 +25,33 @@ func TestErrors(t *testing.T) {
 			got, want := err.Error(), "golang : exit code 255"
 			g.Assert(got).Equal(want)
 		})
+
+		g.It("should include Engine error details", func() {
+			err := EngineError{errors.New("Cannot connect to the Docker daemon")}
+			got, want := err.Error(), "Cannot connect to the Docker daemon"
+			g.Assert(got).Equal(want)
+		})
+	})
+
+	g.Describe("Retriable errors", func() {
+
+		g.It("should retry infrastructure failures", func() {
+			g.Assert(Retriable(&OomError{Name: "golang"})).IsTrue()
+			g.Assert(Retriable(&EngineError{errors.New("EOF")})).IsTrue()
+		})
+
+		g.It("should not retry build failures", func() {
+			g.Assert(Retriable(nil)).IsFalse()
+			g.Assert(Retriable(&ExitError{Name: "golang", Code: 1})).IsFalse()
+			g.Assert(Retriable(&LogLimitError{Limit: 10})).IsFalse()
+			g.Assert(Retriable(errors.New("maximum time limit exceeded, build cancelled"))).IsFalse()
+		})
+
+		g.It("should only wrap connection failures as engine errors", func() {
+			refused := &url.Error{Op: "Post", URL: "unix:///var/run/docker.sock", Err: errors.New("connection refused")}
+			g.Assert(Retriable(engineError(refused))).IsTrue()
+			g.Assert(Retriable(engineError(io.ErrUnexpectedEOF))).IsTrue()
+			g.Assert(Retriable(engineError(errors.New("Cannot run golang:1.5 on linux/arm64, image platform is linux/amd64")))).IsFalse()
+		})
 	})
 }
@@ -0,0 +1,41 @@
+package build
+
+import "time"
+
+// limiter limits the number of log lines per second. Lines exceeding the
+// rate are suppressed and counted, so that the number of suppressed lines
+// can be reported when the next window starts.
+type limiter struct {
+	rate       int
+	window     time.Time
+	count      int
+	suppressed int
+}
+
+// allow returns true if the line written at the given time is within the
+// rate limit. It also returns the number of lines suppressed in the prior
+// window when a new window starts. A zero rate disables the limit.
+func (l *limiter) allow(now time.Time) (bool, int) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	var suppressed int
+	if now.Sub(l.window) >= time.Second {
+		suppressed = l.flush()
+		l.window = now
+		l.count = 0
+	}
+	if l.count >= l.rate {
+		l.suppressed++
+		return false, suppressed
+	}
+	l.count++
+	return true, suppressed
+}
+
+// flush returns the number of suppressed lines not yet reported.
+func (l *limiter) flush() int {
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed
+}
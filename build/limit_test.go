@@ -0,0 +1,45 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+)
+
+func TestLimiter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Log rate limiter", func() {
+
+		g.It("should allow all lines when disabled", func() {
+			l := &limiter{}
+			now := time.Now()
+			for i := 0; i < 1000; i++ {
+				ok, _ := l.allow(now)
+				g.Assert(ok).IsTrue()
+			}
+			g.Assert(l.flush()).Equal(0)
+		})
+
+		g.It("should suppress lines exceeding the rate", func() {
+			l := &limiter{rate: 2}
+			now := time.Now()
+
+			ok, _ := l.allow(now)
+			g.Assert(ok).IsTrue()
+			ok, _ = l.allow(now.Add(time.Millisecond))
+			g.Assert(ok).IsTrue()
+			ok, _ = l.allow(now.Add(time.Millisecond * 2))
+			g.Assert(ok).IsFalse()
+			ok, _ = l.allow(now.Add(time.Millisecond * 3))
+			g.Assert(ok).IsFalse()
+
+			// the suppressed lines are reported when the next window starts.
+			ok, suppressed := l.allow(now.Add(time.Second))
+			g.Assert(ok).IsTrue()
+			g.Assert(suppressed).Equal(2)
+			g.Assert(l.flush()).Equal(0)
+		})
+	})
+}
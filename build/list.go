@@ -0,0 +1,27 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// List writes the name, node type and image of each container in the Yaml,
+// one per line in aligned columns, in the order the containers are started.
+// Disabled containers are omitted.
+func List(w io.Writer, spec *yaml.Config) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	write := func(containers []*yaml.Container, node string) {
+		for _, c := range containers {
+			if c.Disabled {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, node, c.Image)
+		}
+	}
+	write(spec.Services, yaml.NodeServices)
+	write(spec.Pipeline, yaml.NodeBuild)
+	return tw.Flush()
+}
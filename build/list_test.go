@@ -0,0 +1,49 @@
+package build
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+func TestList(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("List steps", func() {
+
+		g.It("should list the containers in execution order", func() {
+			spec, err := yaml.ParseString(listYaml)
+			if err != nil {
+				g.Fail(err)
+			}
+			spec.Pipeline[2].Disabled = true
+
+			var buf bytes.Buffer
+			err = List(&buf, spec)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(buf.String()).Equal(
+				"database  services  mysql:5.6\n" +
+					"test      build     golang:1.6\n" +
+					"publish   build     plugins/docker\n",
+			)
+		})
+	})
+}
+
+var listYaml = `
+pipeline:
+  test:
+    image: golang:1.6
+    commands: [ go test ]
+  publish:
+    image: plugins/docker
+    repo: octocat/hello-world
+  notify:
+    image: slack
+
+services:
+  database:
+    image: mysql:5.6
+`
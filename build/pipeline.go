@@ -2,11 +2,23 @@ package build
 
 import (
 	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/drone/drone-exec/yaml"
+	"golang.org/x/net/context"
 )
 
+// logFlush is the maximum time to wait for the remaining output of a failed
+// step once the step exits.
+const logFlush = time.Second
+
 // element represents a link in the linked list.
 type element struct {
 	*yaml.Container
@@ -15,19 +27,76 @@ type element struct {
 
 // Pipeline represents a build pipeline.
 type Pipeline struct {
-	conf *yaml.Config
-	head *element
-	tail *element
-	pipe chan (*Line)
-	next chan (error)
-	done chan (error)
-	err  error
+	conf  *yaml.Config
+	head  *element
+	tail  *element
+	total int
+	pipe  chan (*Line)
+	next  chan (error)
+	done  chan (error)
+	err   error
+
+	// ctx is cancelled when the pipeline is stopped, which stops the step
+	// in progress, and closed is closed on teardown.
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed chan struct{}
 
 	containers []string
+	preserved  []string
+	images     []string
 	volumes    []string
 	networks   []string
 
-	engine Engine
+	mu      sync.Mutex
+	results []*Result
+	lines   int
+	pos     int
+
+	engine   Engine
+	backoff  time.Duration
+	lineSize int
+	lineRate int
+	maxLines int
+
+	// nodes maps each container to its node type, and preserve is the set
+	// of node types preserved on teardown when the build fails.
+	nodes    map[*yaml.Container]string
+	preserve map[string]bool
+
+	// detached is the directory of the detached container log files, which
+	// are written to a subdirectory created for the build, and files the
+	// paths of the files written. Teardown waits for the writers.
+	detached string
+	logdir   string
+	files    []string
+	writers  sync.WaitGroup
+
+	// prune removes the images pulled for the build on teardown, except
+	// the images matching the keep patterns.
+	prune bool
+	keep  []string
+
+	// stop stops the background containers, the running services, once the
+	// build fails. Services sharing their network with other containers,
+	// such as the ambassador, keep running, since the later steps, such as
+	// the failure notifications, cannot start without them.
+	stop       bool
+	background []string
+	shared     map[string]bool
+
+	// stats samples the resource usage of the steps, and usage records the
+	// peak usage of each step.
+	stats bool
+	usage map[*yaml.Container]*Stats
+
+	// services maps the names of the started services to the container
+	// names, in which the exec_in steps are executed.
+	services map[string]string
+
+	// caches records the cache volumes checked by the steps, which are
+	// reported by the first step that mounts them.
+	caches map[string]bool
 }
 
 // Done returns when the process is done executing.
@@ -37,9 +106,17 @@ func (p *Pipeline) Done() <-chan error {
 
 // Err returns the error for the current process.
 func (p *Pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.err
 }
 
+// Failed returns true if a step failed or the pipeline was stopped with an
+// error. It is safe to call concurrently.
+func (p *Pipeline) Failed() bool {
+	return p.Err() != nil
+}
+
 // Next returns the next step in the process.
 func (p *Pipeline) Next() <-chan error {
 	return p.next
@@ -47,20 +124,79 @@ func (p *Pipeline) Next() <-chan error {
 
 // Exec executes the current step.
 func (p *Pipeline) Exec() {
+	c := p.Head()
+	result := p.record(&Result{
+		ID:      c.Step,
+		Name:    c.Name,
+		Started: time.Now(),
+	})
 	go func() {
-		err := p.exec(p.head.Container)
-		if err != nil {
-			p.err = err
+		cache := p.cache(c)
+		err := p.exec(c)
+
+		// a step stopped with the pipeline does not replace the error the
+		// pipeline was stopped with, such as a LogLimitError.
+		if err != nil && (err != ErrTerm || !p.Failed()) {
+			p.fail(err)
 		}
+
+		// the failure of a step that exited or was oom killed is reported
+		// as the final line of the step output.
+		if f := failure(err); f != nil {
+			p.pipe <- &Line{
+				Proc:    c.Name,
+				Step:    c.Step,
+				Out:     fmt.Sprintf("[%s]", err),
+				Failure: f,
+				Date:    time.Now(),
+			}
+		}
+		p.mu.Lock()
+		if usage, ok := p.usage[c]; ok {
+			stats := *usage
+			result.Stats = &stats
+		}
+		result.Cache = cache
+		p.mu.Unlock()
+		p.finish(result, err)
 		p.step()
 	}()
 }
 
 // Skip skips the current step.
 func (p *Pipeline) Skip() {
+	c := p.Head()
+	p.record(&Result{
+		ID:      c.Step,
+		Name:    c.Name,
+		Skipped: true,
+	})
 	p.step()
 }
 
+// Results returns the results of the steps executed or skipped so far.
+func (p *Pipeline) Results() []*Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := make([]*Result, len(p.results))
+	for i, result := range p.results {
+		r := *result
+		results[i] = &r
+	}
+	return results
+}
+
+// Succeeded returns the names of the steps that have run and succeeded.
+func (p *Pipeline) Succeeded() map[string]bool {
+	succeeded := map[string]bool{}
+	for _, result := range p.Results() {
+		if !result.Skipped && !result.Finished.IsZero() && result.Err == nil {
+			succeeded[result.Name] = true
+		}
+	}
+	return succeeded
+}
+
 // Pipe returns the build output pipe.
 func (p *Pipeline) Pipe() <-chan *Line {
 	return p.pipe
@@ -68,6 +204,8 @@ func (p *Pipeline) Pipe() <-chan *Line {
 
 // Head returns the head item in the list.
 func (p *Pipeline) Head() *yaml.Container {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.head.Container
 }
 
@@ -76,25 +214,70 @@ func (p *Pipeline) Tail() *yaml.Container {
 	return p.tail.Container
 }
 
-// Stop stops the pipeline.
+// Stop stops the pipeline, cancelling the step in progress.
 func (p *Pipeline) Stop() {
-	go func() {
-		p.done <- ErrTerm
-	}()
+	p.cancel()
+	go p.signal(p.done, ErrTerm)
 }
 
-// Setup prepares the build pipeline environment.
+// signal sends the error to the channel, unless the pipeline is torn down
+// before the channel is received.
+func (p *Pipeline) signal(ch chan error, err error) {
+	select {
+	case ch <- err:
+	case <-p.closed:
+	}
+}
+
+// Setup prepares the build pipeline environment, creating the networks
+// defined in the Yaml, which are removed on teardown.
 func (p *Pipeline) Setup() error {
+	if p.conf == nil {
+		return nil
+	}
+	for _, network := range p.conf.Networks {
+		if err := p.engine.NetworkCreate(network); err != nil {
+			return engineError(err)
+		}
+		p.mu.Lock()
+		p.networks = append(p.networks, network.Name)
+		p.mu.Unlock()
+	}
 	return nil
 }
 
-// Teardown removes the pipeline environment.
+// Preserved returns the containers that are preserved on teardown when the
+// build fails.
+func (p *Pipeline) Preserved() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.preserved...)
+}
+
+// Teardown removes the pipeline environment. Containers of the preserved
+// node types are not removed when the build failed.
 func (p *Pipeline) Teardown() {
-	for _, id := range p.containers {
+	p.mu.Lock()
+	containers := append([]string(nil), p.containers...)
+	if p.err == nil {
+		containers = append(containers, p.preserved...)
+	}
+	images := p.images
+	networks := p.networks
+	p.mu.Unlock()
+
+	for _, id := range containers {
 		p.engine.ContainerRemove(id)
 	}
-	close(p.next)
-	close(p.done)
+	p.writers.Wait()
+	for _, image := range images {
+		p.engine.ImageRemove(image)
+	}
+	for _, network := range networks {
+		p.engine.NetworkRemove(network)
+	}
+	p.cancel()
+	close(p.closed)
 
 	// TODO we have a race condition here where the program can try to async
 	// write to a closed pipe channel. This package, in general, needs to be
@@ -102,53 +285,309 @@ func (p *Pipeline) Teardown() {
 	// close(p.pipe)
 }
 
+// DetachedLogs returns the paths of the files to which the console output of
+// detached containers is written.
+func (p *Pipeline) DetachedLogs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.files...)
+}
+
+// Progress returns the number of steps executed or skipped so far, the
+// total number of steps, and the name of the current step. The step name is
+// empty once every step is done. It is safe to call concurrently.
+func (p *Pipeline) Progress() (current, total int, stepName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.head != nil && p.pos < p.total {
+		stepName = p.head.Name
+	}
+	return p.pos, p.total, stepName
+}
+
 // step steps through the pipeline to head.next
 func (p *Pipeline) step() {
-	if p.head == p.tail {
-		go func() {
-			p.done <- nil
-		}()
+	p.mu.Lock()
+	p.pos++
+	last := p.head == p.tail
+	p.mu.Unlock()
+
+	if last {
+		go p.signal(p.done, nil)
 	} else {
 		go func() {
+			p.mu.Lock()
 			p.head = p.head.next
-			p.next <- nil
+			p.mu.Unlock()
+			p.signal(p.next, nil)
 		}()
 	}
 }
 
+// Summary returns a summary of the steps executed, skipped and failed. The
+// summary is complete once the pipeline is done.
+func (p *Pipeline) Summary() *Summary {
+	summary := &Summary{
+		Total: p.total,
+		Err:   p.Err(),
+	}
+	for _, result := range p.Results() {
+		switch {
+		case result.Skipped:
+			summary.Skipped++
+		case result.Err != nil:
+			summary.Run++
+			summary.Failed++
+		default:
+			summary.Run++
+		}
+	}
+	return summary
+}
+
+// record appends the step result to the list of results.
+func (p *Pipeline) record(result *Result) *Result {
+	p.mu.Lock()
+	p.results = append(p.results, result)
+	p.mu.Unlock()
+	return result
+}
+
+// fail sets the pipeline error.
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	first := p.err == nil
+	p.err = err
+	background := append([]string(nil), p.background...)
+	p.mu.Unlock()
+
+	// the services are stopped on the first failure, if enabled,
+	// instead of running until teardown.
+	if first && p.stop {
+		for _, name := range background {
+			p.engine.ContainerStop(name)
+		}
+	}
+}
+
+// finish marks the step result as finished.
+func (p *Pipeline) finish(result *Result, err error) {
+	p.mu.Lock()
+	result.Finished = time.Now()
+	result.Err = err
+	p.mu.Unlock()
+}
+
 // close closes open channels and signals the pipeline is done.
 func (p *Pipeline) close(err error) {
-	go func() {
-		p.done <- err
-	}()
+	go p.signal(p.done, err)
+}
+
+// count counts a line of console output across all steps and returns false
+// if the line exceeds the maximum number of lines. The pipeline is stopped
+// with a LogLimitError once the limit is exceeded.
+func (p *Pipeline) count(c *yaml.Container) bool {
+	if p.maxLines <= 0 {
+		return true
+	}
+	p.mu.Lock()
+	p.lines++
+	lines := p.lines
+	p.mu.Unlock()
+
+	if lines <= p.maxLines {
+		return true
+	}
+	if lines == p.maxLines+1 {
+		p.fail(&LogLimitError{p.maxLines})
+		p.pipe <- &Line{
+			Proc: c.Name,
+			Step: c.Step,
+			Out:  fmt.Sprintf("[build exceeded the maximum of %d log lines]", p.maxLines),
+		}
+		p.Stop()
+	}
+	return false
 }
 
 func (p *Pipeline) exec(c *yaml.Container) error {
-	name, err := p.engine.ContainerStart(c)
+	if c.ImageBuild != nil {
+		return p.build(c)
+	}
+	run := p.run
+	if c.ExecIn != "" {
+		run = p.execIn
+	}
+	err := run(c)
+	for i := 1; i <= c.Retries; i++ {
+		if _, ok := err.(*ExitError); !ok {
+			break
+		}
+		backoff := p.backoff * time.Duration(i)
+		p.pipe <- &Line{
+			Proc: c.Name,
+			Step: c.Step,
+			Out:  fmt.Sprintf("%s, retry in %v (attempt %d of %d)", err, backoff, i, c.Retries),
+		}
+		select {
+		case <-time.After(backoff):
+		case <-p.ctx.Done():
+			return ErrTerm
+		}
+
+		if rerr := p.reset(c); rerr != nil {
+			return rerr
+		}
+		err = run(c)
+	}
+	return err
+}
+
+// reset removes the failed container and runs the reset container, if
+// defined, to prepare the environment for a retry.
+func (p *Pipeline) reset(c *yaml.Container) error {
+	p.engine.ContainerRemove(c.ID)
+	if c.Reset == nil {
+		return nil
+	}
+	defer p.engine.ContainerRemove(c.Reset.ID)
+	return p.run(c.Reset)
+}
+
+// build builds the container image and streams the build output. The image
+// is removed on teardown unless it is configured to be kept.
+func (p *Pipeline) build(c *yaml.Container) error {
+	rc, err := p.engine.ImageBuild(c)
 	if err != nil {
 		return err
 	}
-	p.containers = append(p.containers, name)
+	defer rc.Close()
+	defer p.closeOnStop(rc)()
+
+	if !c.ImageBuild.Keep {
+		p.mu.Lock()
+		p.images = append(p.images, c.Image)
+		p.mu.Unlock()
+	}
+	return p.logs(c, rc)
+}
+
+// cache reports whether each cache volume of the step was restored, from the
+// volume or the fallback branch cache, or starts empty, before the step
+// starts and creates the volume. Each volume is only reported once, by the
+// first step that mounts it, and only if the engine implements
+// VolumeInspector.
+func (p *Pipeline) cache(c *yaml.Container) map[string]bool {
+	inspector, ok := p.engine.(VolumeInspector)
+	if !ok || len(c.CacheVolumes) == 0 {
+		return nil
+	}
+	var restored map[string]bool
+	for _, volume := range c.CacheVolumes {
+		p.mu.Lock()
+		checked := p.caches[volume]
+		p.caches[volume] = true
+		p.mu.Unlock()
+		if checked {
+			continue
+		}
+
+		exists, err := inspector.VolumeExists(volume)
+		if err != nil {
+			continue
+		}
+		if restored == nil {
+			restored = map[string]bool{}
+		}
+
+		// an empty branch cache is restored from the cache of the fallback
+		// branch by the cache restore step, if the fallback cache exists.
+		out := fmt.Sprintf("[cache hit, restored %s]", volume)
+		if fallback, ok := c.CacheFallbacks[volume]; ok && !exists {
+			exists, _ = inspector.VolumeExists(fallback)
+			out = fmt.Sprintf("[cache hit, restored %s from %s]", volume, fallback)
+		}
+		if !exists {
+			out = fmt.Sprintf("[cache miss, %s starts empty]", volume)
+		}
+		restored[volume] = exists
+		p.pipe <- &Line{
+			Proc: c.Name,
+			Step: c.Step,
+			Out:  out,
+			Date: time.Now(),
+		}
+	}
+	return restored
+}
+
+// execIn executes the step in the running service container named by the
+// exec_in setting, instead of starting a container for the step, and streams
+// the command output.
+func (p *Pipeline) execIn(c *yaml.Container) error {
+	execer, ok := p.engine.(ContainerExecer)
+	if !ok {
+		return ErrContainerExecer
+	}
+	p.mu.Lock()
+	name, ok := p.services[c.ExecIn]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("Cannot exec %s in %s, service is not running", c.Name, c.ExecIn)
+	}
+
+	rc, err := execer.ContainerExec(name, c)
+	if err != nil {
+		return engineError(err)
+	}
+	defer rc.Close()
+	defer p.closeOnStop(rc)()
+
+	err = p.logs(c, rc)
+	if p.ctx.Err() != nil {
+		return ErrTerm
+	}
+	return err
+}
+
+func (p *Pipeline) run(c *yaml.Container) error {
+	name, err := p.engine.ContainerStart(c)
+	if err != nil {
+		return engineError(err)
+	}
+	p.mu.Lock()
+	if p.preserve[p.nodes[c]] {
+		p.preserved = append(p.preserved, name)
+	} else {
+		p.containers = append(p.containers, name)
+	}
+	if p.pruned(c.Image, name) {
+		p.images = append(p.images, c.Image)
+	}
+	if c.Detached && p.nodes[c] == yaml.NodeServices && !p.shared[c.ID] {
+		p.background = append(p.background, name)
+	}
+	if c.Detached && p.nodes[c] == yaml.NodeServices {
+		p.services[c.Name] = name
+	}
+	p.mu.Unlock()
 
+	// the output of detached containers is written to a file, if configured,
+	// to keep it from mixing with the output of the build steps.
+	if c.Detached && p.detached != "" {
+		return p.detach(c, name)
+	}
+
+	logged := make(chan struct{})
 	go func() {
+		defer close(logged)
 		rc, rerr := p.engine.ContainerLogs(name)
 		if rerr != nil {
 			return
 		}
 		defer rc.Close()
-
-		num := 0
-		now := time.Now().UTC()
-		scanner := bufio.NewScanner(rc)
-		for scanner.Scan() {
-			p.pipe <- &Line{
-				Proc: c.Name,
-				Time: int64(time.Since(now).Seconds()),
-				Pos:  num,
-				Out:  scanner.Text(),
-			}
-			num++
-		}
+		defer p.closeOnStop(rc)()
+		p.logs(c, rc)
 	}()
 
 	// exit when running container in detached mode in background
@@ -156,9 +595,22 @@ func (p *Pipeline) exec(c *yaml.Container) error {
 		return nil
 	}
 
-	state, err := p.engine.ContainerWait(name)
-	if err != nil {
+	stop := p.sample(c, name)
+	state, err := p.wait(name)
+	stop()
+	if err == ErrTerm {
 		return err
+	} else if err != nil {
+		return engineError(err)
+	}
+
+	// wait briefly for the remaining output of a failed step, so the failure
+	// is reported after the step output.
+	if state.OOMKilled || state.ExitCode != 0 {
+		select {
+		case <-logged:
+		case <-time.After(logFlush):
+		}
 	}
 	if state.OOMKilled {
 		return &OomError{c.Name}
@@ -167,3 +619,214 @@ func (p *Pipeline) exec(c *yaml.Container) error {
 	}
 	return nil
 }
+
+// sample samples the resource usage of the running container, if enabled,
+// recording the peak usage of the step. The returned function stops sampling
+// once the container exits.
+func (p *Pipeline) sample(c *yaml.Container, name string) func() {
+	collector, ok := p.engine.(StatsCollector)
+	if !p.stats || !ok {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	samples, err := collector.ContainerStats(name, stop)
+	if err != nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sample := range samples {
+			p.peak(c, sample)
+		}
+	}()
+	return func() {
+		close(stop)
+		select {
+		case <-done:
+		case <-time.After(logFlush):
+		}
+	}
+}
+
+// peak records the resource usage sample if it exceeds the peak usage of
+// the step.
+func (p *Pipeline) peak(c *yaml.Container, sample *Stats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage, ok := p.usage[c]
+	if !ok {
+		usage = new(Stats)
+		p.usage[c] = usage
+	}
+	if sample.Memory > usage.Memory {
+		usage.Memory = sample.Memory
+	}
+	if sample.CPU > usage.CPU {
+		usage.CPU = sample.CPU
+	}
+}
+
+// wait waits for the container to exit and returns its state. If the pipeline
+// is stopped first, the container is stopped and ErrTerm is returned without
+// waiting for the container to exit.
+func (p *Pipeline) wait(name string) (*State, error) {
+	type result struct {
+		state *State
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		state, err := p.engine.ContainerWait(name)
+		ch <- result{state, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.state, r.err
+	case <-p.ctx.Done():
+		p.engine.ContainerStop(name)
+		return nil, ErrTerm
+	}
+}
+
+// closeOnStop closes the stream when the pipeline is stopped, which ends a
+// read of the stream in progress. The returned function releases the stream
+// once it is no longer read.
+func (p *Pipeline) closeOnStop(c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// pruned returns true if the image was pulled for the build when starting the
+// named container, and is removed on teardown. The caller must hold the lock.
+func (p *Pipeline) pruned(image, name string) bool {
+	if !p.prune {
+		return false
+	}
+	tracker, ok := p.engine.(ImageTracker)
+	if !ok || !tracker.ContainerPulled(name) {
+		return false
+	}
+	for _, pattern := range p.keep {
+		if match, _ := filepath.Match(pattern, image); match {
+			return false
+		}
+	}
+	for _, name := range p.images {
+		if name == image {
+			return false
+		}
+	}
+	return true
+}
+
+// detach writes the output of the detached container to a log file in the
+// build subdirectory of the detached logs directory, named after the
+// container. The subdirectory keeps the files of concurrent builds apart.
+func (p *Pipeline) detach(c *yaml.Container, name string) error {
+	if strings.ContainsAny(c.Name, `/\`) {
+		return fmt.Errorf("Cannot write the output of %s, invalid container name", c.Name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.logdir == "" {
+		if err := os.MkdirAll(p.detached, 0755); err != nil {
+			return err
+		}
+		dir, err := ioutil.TempDir(p.detached, "build_")
+		if err != nil {
+			return err
+		}
+		p.logdir = dir
+	}
+	path := filepath.Join(p.logdir, c.Name+".log")
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	p.files = append(p.files, path)
+
+	p.writers.Add(1)
+	go func() {
+		defer p.writers.Done()
+		defer file.Close()
+		rc, rerr := p.engine.ContainerLogs(name)
+		if rerr != nil {
+			return
+		}
+		defer rc.Close()
+		io.Copy(file, rc)
+	}()
+	return nil
+}
+
+// logs writes each line of the container output to the pipe. Lines longer
+// than the maximum line size are truncated, followed by a truncation notice.
+// Lines exceeding the maximum line rate are suppressed, followed by a notice
+// with the number of suppressed lines.
+func (p *Pipeline) logs(c *yaml.Container, r io.Reader) error {
+	num := 0
+	now := time.Now().UTC()
+	send := func(out string) {
+		if !p.count(c) {
+			return
+		}
+		p.pipe <- &Line{
+			Proc: c.Name,
+			Step: c.Step,
+			Time: int64(time.Since(now).Seconds()),
+			Pos:  num,
+			Out:  out,
+			Date: time.Now(),
+		}
+		num++
+	}
+
+	limit := &limiter{rate: p.lineRate}
+	write := func(out string) {
+		ok, suppressed := limit.allow(time.Now())
+		if suppressed != 0 {
+			send(fmt.Sprintf("[%d lines suppressed]", suppressed))
+		}
+		if ok {
+			send(out)
+		}
+	}
+	defer func() {
+		if suppressed := limit.flush(); suppressed != 0 {
+			send(fmt.Sprintf("[%d lines suppressed]", suppressed))
+		}
+	}()
+
+	reader := bufio.NewReaderSize(r, p.lineSize)
+	for {
+		line, prefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		write(string(line))
+		if !prefix {
+			continue
+		}
+
+		// discard the remainder of the line.
+		for prefix && err == nil {
+			_, prefix, err = reader.ReadLine()
+		}
+		write(fmt.Sprintf("[line truncated to %d bytes]", p.lineSize))
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -1,5 +1,1275 @@
 package build
 
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+	"golang.org/x/net/context"
+)
+
+func TestPipeline(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Pipeline", func() {
+
+		g.It("should record step results", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "test"},
+					{Name: "deploy"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, func(c *yaml.Container) bool {
+				return c.Name == "deploy"
+			})
+			g.Assert(err != nil).IsTrue("expects exit error")
+
+			results := pipeline.Results()
+			g.Assert(len(results)).Equal(3)
+			g.Assert(results[0].Name).Equal("clone")
+			g.Assert(results[0].Err == nil).IsTrue()
+			g.Assert(results[0].Finished.IsZero()).IsFalse()
+			g.Assert(results[1].Name).Equal("test")
+			g.Assert(results[1].Err.Error()).Equal("test : exit code 1")
+			g.Assert(results[2].Name).Equal("deploy")
+			g.Assert(results[2].Skipped).IsTrue()
+			g.Assert(results[2].Duration()).Equal(time.Duration(0))
+		})
+
+		g.It("should skip steps when a named prior step failed", func() {
+			engine := newMockEngine()
+			engine.exit["unit"] = 1
+
+			integration := &yaml.Container{Name: "integration"}
+			integration.Constraints.SuccessOf.Include = []string{"unit"}
+			deploy := &yaml.Container{Name: "deploy"}
+			deploy.Constraints.SuccessOf.Include = []string{"lint"}
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "lint"},
+					{Name: "unit"},
+					integration,
+					deploy,
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			run(pipeline, func(c *yaml.Container) bool {
+				return !c.Constraints.MatchSuccess(pipeline.Succeeded())
+			})
+
+			results := pipeline.Results()
+			g.Assert(results[1].Err == nil).IsFalse()
+			g.Assert(results[2].Name).Equal("integration")
+			g.Assert(results[2].Skipped).IsTrue()
+			g.Assert(results[3].Name).Equal("deploy")
+			g.Assert(results[3].Skipped).IsFalse()
+			g.Assert(pipeline.Succeeded()).Equal(map[string]bool{"lint": true, "deploy": true})
+		})
+
+		g.It("should evaluate dependency edges against step results", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "test", DependsOn: yaml.Dependencies{"clone": yaml.DependSuccess}},
+					{Name: "deploy", DependsOn: yaml.Dependencies{"test": yaml.DependSuccess}},
+					{Name: "cleanup", DependsOn: yaml.Dependencies{"test": yaml.DependAlways}},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			run(pipeline, func(c *yaml.Container) bool {
+				return !c.DependsOn.Match(pipeline.Succeeded())
+			})
+
+			results := pipeline.Results()
+			g.Assert(results[1].Name).Equal("test")
+			g.Assert(results[1].Err == nil).IsFalse()
+			g.Assert(results[2].Name).Equal("deploy")
+			g.Assert(results[2].Skipped).IsTrue()
+			g.Assert(results[3].Name).Equal("cleanup")
+			g.Assert(results[3].Skipped).IsFalse()
+			g.Assert(engine.started).Equal([]string{"clone", "test", "cleanup"})
+		})
+
+		g.It("should record step identifiers", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "clone", Step: "pipeline_0_clone"},
+					{Name: "deploy", Step: "pipeline_1_deploy"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			run(pipeline, func(c *yaml.Container) bool {
+				return c.Name == "deploy"
+			})
+
+			results := pipeline.Results()
+			g.Assert(results[0].ID).Equal("pipeline_0_clone")
+			g.Assert(results[1].ID).Equal("pipeline_1_deploy")
+		})
+
+		g.It("should summarize executed and skipped steps", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{Name: "database", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "test"},
+					{Name: "deploy"},
+					{Name: "notify"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, func(c *yaml.Container) bool {
+				return c.Name == "deploy"
+			})
+
+			summary := pipeline.Summary()
+			g.Assert(summary.Total).Equal(5)
+			g.Assert(summary.Run).Equal(4)
+			g.Assert(summary.Skipped).Equal(1)
+			g.Assert(summary.Failed).Equal(1)
+			g.Assert(summary.Err).Equal(err)
+			g.Assert(summary.String()).Equal("5 steps, 4 run, 1 skipped, 1 failed")
+		})
+
+		g.It("should report progress as steps advance", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{Name: "database", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			var progress []string
+			report := func() {
+				current, total, name := pipeline.Progress()
+				progress = append(progress, fmt.Sprintf("%d/%d %s", current, total, name))
+			}
+			report()
+		loop:
+			for {
+				select {
+				case <-pipeline.Done():
+					break loop
+				case <-pipeline.Next():
+					report()
+					if pipeline.Head().Name == "test" {
+						pipeline.Skip()
+					} else {
+						pipeline.Exec()
+					}
+				case <-pipeline.Pipe():
+				}
+			}
+			report()
+
+			g.Assert(progress).Equal([]string{
+				"0/3 database",
+				"0/3 database",
+				"1/3 clone",
+				"2/3 test",
+				"3/3 ",
+			})
+		})
+
+		g.It("should record results and failures concurrently", func() {
+			conf := Config{Engine: newMockEngine()}
+			pipeline := conf.Pipeline(&yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}},
+			})
+			defer pipeline.Teardown()
+			<-pipeline.Next()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					result := pipeline.record(&Result{Name: fmt.Sprint(i)})
+					var err error
+					if i%2 == 0 {
+						err = &ExitError{fmt.Sprint(i), 1}
+						pipeline.fail(err)
+					}
+					pipeline.finish(result, err)
+				}(i)
+				go func() {
+					defer wg.Done()
+					pipeline.Failed()
+					pipeline.Results()
+					pipeline.Summary()
+				}()
+			}
+			wg.Wait()
+
+			g.Assert(pipeline.Failed()).IsTrue()
+			g.Assert(len(pipeline.Results())).Equal(10)
+			g.Assert(pipeline.Summary().Failed).Equal(5)
+		})
+
+		g.It("should report oom killed steps", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 137
+			engine.oom["test"] = true
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "test", MemLimit: 1024, MemSwapLimit: 1024},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			_, ok := err.(*OomError)
+			g.Assert(ok).IsTrue("expects oom error")
+		})
+
+		g.It("should report the failure of exited and oom killed steps", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 2
+			engine.exit["build"] = 137
+			engine.oom["build"] = true
+
+			for _, name := range []string{"test", "build"} {
+				spec := &yaml.Config{
+					Pipeline: []*yaml.Container{{Name: name, Step: "pipeline_0_" + name}},
+				}
+				conf := Config{Engine: engine, Buffer: 10}
+				pipeline := conf.Pipeline(spec)
+				<-pipeline.Next()
+				pipeline.Exec()
+				<-pipeline.Done()
+
+				g.Assert(len(pipeline.pipe)).Equal(1)
+				line := <-pipeline.Pipe()
+				g.Assert(line.Proc).Equal(name)
+				g.Assert(line.Step).Equal("pipeline_0_" + name)
+				if name == "test" {
+					g.Assert(line.Out).Equal("[test : exit code 2]")
+					g.Assert(*line.Failure).Equal(Failure{Kind: FailureExit, ExitCode: 2})
+				} else {
+					g.Assert(line.Out).Equal("[build : received oom kill]")
+					g.Assert(*line.Failure).Equal(Failure{Kind: FailureOom})
+				}
+				pipeline.Teardown()
+			}
+		})
+
+		g.It("should not report a failure for successful steps", func() {
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}},
+			}
+			conf := Config{Engine: newMockEngine(), Buffer: 10}
+			pipeline := conf.Pipeline(spec)
+			<-pipeline.Next()
+			pipeline.Exec()
+			<-pipeline.Done()
+			g.Assert(len(pipeline.pipe)).Equal(0)
+			pipeline.Teardown()
+		})
+
+		g.It("should report engine failures as retriable", func() {
+			engine := newMockEngine()
+			engine.fail["test"] = io.ErrUnexpectedEOF
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			_, ok := err.(*EngineError)
+			g.Assert(ok).IsTrue("expects engine error")
+			g.Assert(err.Error()).Equal("unexpected EOF")
+			g.Assert(Retriable(err)).IsTrue()
+		})
+
+		g.It("should not report build failures of the engine as retriable", func() {
+			engine := newMockEngine()
+			engine.startErrs = map[string]error{
+				"test": errors.New("Cannot pull image golang:1.5, image not found after 0 retries"),
+			}
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			g.Assert(err != nil).IsTrue("expects start error")
+			g.Assert(Retriable(err)).IsFalse()
+		})
+
+		g.It("should not report exit codes as retriable", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			g.Assert(err != nil).IsTrue("expects exit error")
+			g.Assert(Retriable(err)).IsFalse()
+		})
+	})
+}
+
+func TestPipelineLogs(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Pipeline logs", func() {
+
+		g.It("should truncate lines longer than the default buffer", func() {
+			long := strings.Repeat("x", bufio.MaxScanTokenSize+100)
+			in := strings.NewReader("first\n" + long + "\nlast\n")
+
+			conf := Config{Engine: newMockEngine(), Buffer: 10}
+			pipeline := conf.Pipeline(&yaml.Config{})
+
+			err := pipeline.logs(&yaml.Container{Name: "build", Step: "pipeline_0_build"}, in)
+			g.Assert(err == nil).IsTrue("expects logs to be read")
+			g.Assert(len(pipeline.pipe)).Equal(4)
+			g.Assert((<-pipeline.pipe).Out).Equal("first")
+			g.Assert((<-pipeline.pipe).Out).Equal(long[:bufio.MaxScanTokenSize])
+			g.Assert((<-pipeline.pipe).Out).Equal("[line truncated to 65536 bytes]")
+			line := <-pipeline.pipe
+			g.Assert(line.Out).Equal("last")
+			g.Assert(line.Proc).Equal("build")
+			g.Assert(line.Step).Equal("pipeline_0_build")
+			g.Assert(line.Pos).Equal(3)
+		})
+
+		g.It("should suppress lines exceeding the configured rate", func() {
+			in := strings.NewReader(strings.Repeat("flood\n", 100))
+
+			conf := Config{Engine: newMockEngine(), Buffer: 20, LineRate: 10}
+			pipeline := conf.Pipeline(&yaml.Config{})
+
+			err := pipeline.logs(&yaml.Container{Name: "build"}, in)
+			g.Assert(err == nil).IsTrue("expects logs to be read")
+			g.Assert(len(pipeline.pipe)).Equal(11)
+			for i := 0; i < 10; i++ {
+				g.Assert((<-pipeline.pipe).Out).Equal("flood")
+			}
+			g.Assert((<-pipeline.pipe).Out).Equal("[90 lines suppressed]")
+		})
+
+		g.It("should truncate lines longer than the configured size", func() {
+			in := strings.NewReader("0123456789abcdefghij")
+
+			conf := Config{Engine: newMockEngine(), Buffer: 10, LineSize: 16}
+			pipeline := conf.Pipeline(&yaml.Config{})
+
+			err := pipeline.logs(&yaml.Container{Name: "build"}, in)
+			g.Assert(err == nil).IsTrue("expects logs to be read")
+			g.Assert(len(pipeline.pipe)).Equal(2)
+			g.Assert((<-pipeline.pipe).Out).Equal("0123456789abcdef")
+			g.Assert((<-pipeline.pipe).Out).Equal("[line truncated to 16 bytes]")
+		})
+
+		g.It("should cancel the build when the log exceeds the maximum lines", func() {
+			conf := Config{Engine: newMockEngine(), Buffer: 20, MaxLines: 15}
+			pipeline := conf.Pipeline(&yaml.Config{})
+			<-pipeline.Next()
+
+			pipeline.logs(&yaml.Container{Name: "test"}, strings.NewReader(strings.Repeat("loop\n", 10)))
+			g.Assert(pipeline.Err() == nil).IsTrue("expects the limit not exceeded")
+			pipeline.logs(&yaml.Container{Name: "build"}, strings.NewReader(strings.Repeat("loop\n", 10)))
+
+			g.Assert(len(pipeline.pipe)).Equal(16)
+			for i := 0; i < 15; i++ {
+				<-pipeline.pipe
+			}
+			line := <-pipeline.pipe
+			g.Assert(line.Proc).Equal("build")
+			g.Assert(line.Out).Equal("[build exceeded the maximum of 15 log lines]")
+
+			g.Assert(<-pipeline.Done()).Equal(ErrTerm)
+			err, ok := pipeline.Err().(*LogLimitError)
+			g.Assert(ok).IsTrue("expects log limit error")
+			g.Assert(err.Error()).Equal("maximum log lines exceeded (15), build cancelled")
+		})
+
+		g.It("should stop the step in progress when the context is cancelled", func() {
+			engine := newMockEngine()
+			engine.block["test"] = make(chan struct{})
+			defer close(engine.block["test"])
+
+			ctx, cancel := context.WithCancel(context.Background())
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{Name: "test"}, {Name: "deploy"}},
+			}
+			conf := Config{Engine: engine, Context: ctx}
+			pipeline := conf.Pipeline(spec)
+			<-pipeline.Next()
+			pipeline.Exec()
+			<-engine.block["test"]
+
+			cancel()
+			g.Assert(<-pipeline.Done()).Equal(ErrTerm)
+			<-pipeline.Next()
+			g.Assert(pipeline.Err()).Equal(ErrTerm)
+			g.Assert(pipeline.Results()[0].Err).Equal(ErrTerm)
+			g.Assert(engine.stopped).Equal([]string{"test"})
+
+			pipeline.Teardown()
+			g.Assert(engine.removed).Equal([]string{"test"})
+		})
+
+		g.It("should close the log stream when stopped", func() {
+			conf := Config{Engine: newMockEngine()}
+			pipeline := conf.Pipeline(&yaml.Config{})
+			<-pipeline.Next()
+
+			r, w := io.Pipe()
+			defer w.Close()
+			release := pipeline.closeOnStop(r)
+			defer release()
+
+			pipeline.Stop()
+			_, err := r.Read(make([]byte, 1))
+			g.Assert(err).Equal(io.ErrClosedPipe)
+			g.Assert(<-pipeline.Done()).Equal(ErrTerm)
+			pipeline.Teardown()
+		})
+	})
+}
+
+func TestPipelineEngine(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Pipeline engine", func() {
+
+		g.It("should run pod containers behind the engine interface", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "ambassador", Name: "ambassador", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", VolumesFrom: []string{"ambassador"}, Network: "container:ambassador"},
+					{ID: "test", Name: "test", VolumesFrom: []string{"ambassador"}, Network: "container:ambassador"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(engine.started).Equal([]string{"ambassador", "clone", "test"})
+			g.Assert(engine.waited).Equal([]string{"clone", "test"})
+			g.Assert(engine.removed).Equal([]string{"ambassador", "clone", "test"})
+		})
+
+		g.It("should preserve service containers when the build fails", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "ambassador", Name: "ambassador", Detached: true},
+					{ID: "postgres", Name: "postgres", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone"},
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine, Preserve: []string{yaml.NodeServices}}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err != nil).IsTrue("expects pipeline to fail")
+			g.Assert(engine.removed).Equal([]string{"clone", "test"})
+			g.Assert(pipeline.Preserved()).Equal([]string{"ambassador", "postgres"})
+		})
+
+		g.It("should stop services on the first failure", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			pod := "container:ambassador"
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "ambassador", Name: "ambassador", Detached: true},
+					{ID: "postgres", Name: "postgres", Detached: true, Network: pod},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", Network: pod},
+					{ID: "selenium", Name: "selenium", Detached: true, Network: pod},
+					{ID: "test", Name: "test", Network: pod},
+					{ID: "deploy", Name: "deploy", Network: pod},
+					{ID: "notify", Name: "notify", Network: pod},
+				},
+			}
+			conf := Config{Engine: engine, StopDetached: true}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, func(c *yaml.Container) bool {
+				return c.Name == "deploy"
+			})
+
+			g.Assert(err != nil).IsTrue("expects pipeline to fail")
+			g.Assert(engine.stopped).Equal([]string{"postgres"})
+			pipeline.Teardown()
+			g.Assert(engine.removed).Equal([]string{"ambassador", "postgres", "clone", "selenium", "test", "notify"})
+		})
+
+		g.It("should not stop detached containers by default", func() {
+			engine := newMockEngine()
+			engine.exit["test"] = 1
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "postgres", Name: "postgres", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err != nil).IsTrue("expects pipeline to fail")
+			g.Assert(len(engine.stopped)).Equal(0)
+		})
+
+		g.It("should remove preserved node types when the build succeeds", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "postgres", Name: "postgres", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine, Preserve: []string{yaml.NodeServices}}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(engine.removed).Equal([]string{"test", "postgres"})
+		})
+
+		g.It("should record the peak resource usage of each step", func() {
+			engine := newMockEngine()
+			engine.stats["test"] = []*Stats{
+				{Memory: 100, CPU: 12.5},
+				{Memory: 300, CPU: 50},
+				{Memory: 200, CPU: 90},
+			}
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone"},
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine, Stats: true}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			results := pipeline.Results()
+			g.Assert(results[0].Stats == nil).IsTrue("expects no samples for the clone step")
+			g.Assert(*results[1].Stats).Equal(Stats{Memory: 300, CPU: 90})
+		})
+
+		g.It("should not record resource usage by default", func() {
+			engine := newMockEngine()
+			engine.stats["test"] = []*Stats{{Memory: 100, CPU: 12.5}}
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{ID: "test", Name: "test"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(pipeline.Results()[0].Stats == nil).IsTrue()
+		})
+
+		g.It("should exec steps in the named service", func() {
+			engine := newMockEngine()
+			engine.output["migrate"] = "CREATE TABLE\n"
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "postgres", Name: "postgres", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "migrate", Name: "migrate", ExecIn: "postgres", Commands: []string{"psql -f schema.sql"}},
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			var lines []string
+			err := runLines(pipeline, &lines)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(engine.execs).Equal([]string{"postgres:migrate"})
+			g.Assert(engine.started).Equal([]string{"postgres", "test"})
+			g.Assert(lines).Equal([]string{"CREATE TABLE"})
+		})
+
+		g.It("should fail the step when the exec command fails", func() {
+			engine := newMockEngine()
+			engine.exit["migrate"] = 3
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "postgres", Name: "postgres", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "migrate", Name: "migrate", ExecIn: "postgres", Commands: []string{"psql -f schema.sql"}},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err.Error()).Equal((&ExitError{"migrate", 3}).Error())
+		})
+
+		g.It("should not exec steps in a service that is not running", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "postgres", Name: "postgres", Detached: true, Disabled: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "migrate", Name: "migrate", ExecIn: "postgres", Commands: []string{"psql -f schema.sql"}},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err.Error()).Equal("Cannot exec migrate in postgres, service is not running")
+			g.Assert(len(engine.execs)).Equal(0)
+		})
+
+		g.It("should report cache hits and misses", func() {
+			engine := newMockEngine()
+			engine.caches["drone_cache_1"] = true
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", CacheVolumes: []string{"drone_cache_1"}},
+					{ID: "test", Name: "test", CacheVolumes: []string{"drone_cache_1", "drone_step_cache_1"}},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			var lines []string
+			err := runLines(pipeline, &lines)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(lines).Equal([]string{
+				"[cache hit, restored drone_cache_1]",
+				"[cache miss, drone_step_cache_1 starts empty]",
+			})
+			results := pipeline.Results()
+			g.Assert(results[0].Cache).Equal(map[string]bool{"drone_cache_1": true})
+			g.Assert(results[1].Cache).Equal(map[string]bool{"drone_step_cache_1": false})
+		})
+
+		g.It("should report caches restored from the fallback branch", func() {
+			engine := newMockEngine()
+			engine.caches["drone_cache_master"] = true
+
+			fallbacks := map[string]string{
+				"drone_cache_feature": "drone_cache_master",
+				"drone_cache_other":   "drone_cache_develop",
+			}
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", CacheVolumes: []string{"drone_cache_feature", "drone_cache_other"}, CacheFallbacks: fallbacks},
+					{ID: "cache", Name: "cache", CacheVolumes: []string{"drone_cache_feature", "drone_cache_other"}, CacheFallbacks: fallbacks},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			var lines []string
+			err := runLines(pipeline, &lines)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(lines).Equal([]string{
+				"[cache hit, restored drone_cache_feature from drone_cache_master]",
+				"[cache miss, drone_cache_other starts empty]",
+			})
+			results := pipeline.Results()
+			g.Assert(results[0].Cache).Equal(map[string]bool{"drone_cache_feature": true, "drone_cache_other": false})
+			g.Assert(results[1].Cache == nil).IsTrue()
+		})
+
+		g.It("should not report caches of steps without cache volumes", func() {
+			engine := newMockEngine()
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(&yaml.Config{
+				Pipeline: []*yaml.Container{{ID: "test", Name: "test"}},
+			})
+
+			var lines []string
+			runLines(pipeline, &lines)
+			pipeline.Teardown()
+
+			g.Assert(len(lines)).Equal(0)
+			g.Assert(pipeline.Results()[0].Cache == nil).IsTrue()
+		})
+
+		g.It("should create and remove the build networks", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Networks: []*yaml.Network{{Name: "drone_network_1", Driver: "bridge"}},
+				Pipeline: []*yaml.Container{{ID: "test", Name: "test", Network: "drone_network_1"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			g.Assert(pipeline.Setup() == nil).IsTrue()
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(len(engine.networks)).Equal(1)
+			g.Assert(engine.networks[0].Name).Equal("drone_network_1")
+			g.Assert(engine.removedNetworks).Equal([]string{"drone_network_1"})
+		})
+
+		g.It("should write detached output to a file", func() {
+			dir, err := ioutil.TempDir("", "drone_detached")
+			if err != nil {
+				g.Fail(err)
+			}
+			defer os.RemoveAll(dir)
+
+			engine := newMockEngine()
+			engine.output["postgres"] = "database system is ready\n"
+			engine.output["test"] = "PASS\n"
+
+			conf := Config{Engine: engine, Buffer: 10, DetachedLogs: dir}
+			pipeline := conf.Pipeline(&yaml.Config{})
+			<-pipeline.Next()
+
+			g.Assert(pipeline.run(&yaml.Container{ID: "postgres", Name: "postgres", Detached: true}) == nil).IsTrue()
+			g.Assert(pipeline.run(&yaml.Container{ID: "test", Name: "test"}) == nil).IsTrue()
+			line := <-pipeline.Pipe()
+			g.Assert(line.Proc).Equal("test")
+			g.Assert(line.Out).Equal("PASS")
+			pipeline.Teardown()
+			g.Assert(len(pipeline.pipe)).Equal(0)
+
+			logs := pipeline.DetachedLogs()
+			g.Assert(len(logs)).Equal(1)
+			g.Assert(filepath.Base(logs[0])).Equal("postgres.log")
+			g.Assert(filepath.Dir(filepath.Dir(logs[0]))).Equal(dir)
+			out, _ := ioutil.ReadFile(logs[0])
+			g.Assert(string(out)).Equal("database system is ready\n")
+		})
+
+		g.It("should write detached output of each build to its own directory", func() {
+			dir, err := ioutil.TempDir("", "drone_detached")
+			if err != nil {
+				g.Fail(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var logs []string
+			for i := 0; i < 2; i++ {
+				conf := Config{Engine: newMockEngine(), Buffer: 10, DetachedLogs: dir}
+				pipeline := conf.Pipeline(&yaml.Config{})
+				<-pipeline.Next()
+				g.Assert(pipeline.run(&yaml.Container{ID: "postgres", Name: "postgres", Detached: true}) == nil).IsTrue()
+				pipeline.Teardown()
+				logs = append(logs, pipeline.DetachedLogs()...)
+			}
+			g.Assert(len(logs)).Equal(2)
+			g.Assert(logs[0] != logs[1]).IsTrue("expects a file per build")
+		})
+
+		g.It("should not write detached output outside of the directory", func() {
+			dir, err := ioutil.TempDir("", "drone_detached")
+			if err != nil {
+				g.Fail(err)
+			}
+			defer os.RemoveAll(dir)
+
+			conf := Config{Engine: newMockEngine(), Buffer: 10, DetachedLogs: dir}
+			pipeline := conf.Pipeline(&yaml.Config{})
+			<-pipeline.Next()
+			err = pipeline.run(&yaml.Container{ID: "auth", Name: "../../auth", Detached: true})
+			pipeline.Teardown()
+			g.Assert(err != nil).IsTrue("expects an invalid name to fail")
+			g.Assert(len(pipeline.DetachedLogs())).Equal(0)
+		})
+
+		g.It("should prune the images pulled for the build", func() {
+			engine := newMockEngine()
+			engine.pulled["golang:1.6"] = true
+			engine.pulled["plugins/docker"] = true
+			engine.pulled["busybox:latest"] = true
+
+			spec := &yaml.Config{
+				Services: []*yaml.Container{
+					{ID: "ambassador", Name: "ambassador", Image: "busybox:latest", Detached: true},
+				},
+				Pipeline: []*yaml.Container{
+					{ID: "test", Name: "test", Image: "golang:1.6"},
+					{ID: "vet", Name: "vet", Image: "golang:1.6"},
+					{ID: "lint", Name: "lint", Image: "golang:lint"},
+					{ID: "publish", Name: "publish", Image: "plugins/docker"},
+				},
+			}
+			conf := Config{Engine: engine, PruneImages: true, KeepImages: []string{"busybox:*"}}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(engine.images).Equal([]string{"golang:1.6", "plugins/docker"})
+		})
+
+		g.It("should not prune images by default", func() {
+			engine := newMockEngine()
+			engine.pulled["golang:1.6"] = true
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{{ID: "test", Name: "test", Image: "golang:1.6"}},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			run(pipeline, nil)
+			pipeline.Teardown()
+			g.Assert(len(engine.images)).Equal(0)
+		})
+
+		g.It("should build images for subsequent steps", func() {
+			engine := newMockEngine()
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone"},
+					{ID: "env", Name: "env", Image: "octocat/env:latest", ImageBuild: &yaml.ImageBuild{
+						Context: "/drone/src/github.com/octocat/hello-world/docker",
+					}},
+					{ID: "base", Name: "base", Image: "octocat/base:latest", ImageBuild: &yaml.ImageBuild{
+						Context: "/drone/src/github.com/octocat/hello-world",
+						Keep:    true,
+					}},
+					{ID: "test", Name: "test", Image: "octocat/env:latest"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+
+			err := run(pipeline, nil)
+			pipeline.Teardown()
+
+			g.Assert(err == nil).IsTrue("expects pipeline to succeed")
+			g.Assert(engine.built).Equal(map[string]string{
+				"octocat/env:latest":  "/drone/src/github.com/octocat/hello-world/docker",
+				"octocat/base:latest": "/drone/src/github.com/octocat/hello-world",
+			})
+			g.Assert(engine.started).Equal([]string{"clone", "test"})
+			g.Assert(engine.images).Equal([]string{"octocat/env:latest"})
+		})
+	})
+}
+
+func TestPipelineRetry(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Pipeline retry", func() {
+
+		g.It("should retry and reset a flaky step", func() {
+			engine := newMockEngine()
+			engine.flaky["clone"] = 2
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{
+						ID:      "clone",
+						Name:    "clone",
+						Retries: 3,
+						Reset:   &yaml.Container{ID: "clone_reset", Name: "clone"},
+					},
+					{ID: "test", Name: "test"},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			g.Assert(err == nil).IsTrue("expects retry to succeed")
+			g.Assert(engine.started).Equal([]string{
+				"clone",
+				"clone_reset",
+				"clone",
+				"clone_reset",
+				"clone",
+				"test",
+			})
+			g.Assert(engine.removed).Equal([]string{
+				"clone",
+				"clone_reset",
+				"clone",
+				"clone_reset",
+			})
+		})
+
+		g.It("should fail when retries are exhausted", func() {
+			engine := newMockEngine()
+			engine.flaky["clone"] = 3
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", Retries: 2},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			g.Assert(err != nil).IsTrue("expects retries to be exhausted")
+			g.Assert(len(engine.started)).Equal(3)
+		})
+
+		g.It("should not retry oom killed steps", func() {
+			engine := newMockEngine()
+			engine.exit["clone"] = 137
+			engine.oom["clone"] = true
+
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", Retries: 2},
+				},
+			}
+			conf := Config{Engine: engine}
+			pipeline := conf.Pipeline(spec)
+			defer pipeline.Teardown()
+
+			err := run(pipeline, nil)
+			_, ok := err.(*OomError)
+			g.Assert(ok).IsTrue("expects oom error")
+			g.Assert(len(engine.started)).Equal(1)
+		})
+	})
+}
+
+// run is a helper function that runs the pipeline to completion, skipping
+// steps for which the skip function returns true.
+func run(pipeline *Pipeline, skip func(*yaml.Container) bool) error {
+	for {
+		select {
+		case <-pipeline.Done():
+			return pipeline.Err()
+		case <-pipeline.Next():
+			if skip != nil && skip(pipeline.Head()) {
+				pipeline.Skip()
+			} else {
+				pipeline.Exec()
+			}
+		case <-pipeline.Pipe():
+		}
+	}
+}
+
+// runLines runs the pipeline like run, and records the output lines.
+func runLines(pipeline *Pipeline, lines *[]string) error {
+	for {
+		select {
+		case <-pipeline.Done():
+			return pipeline.Err()
+		case <-pipeline.Next():
+			pipeline.Exec()
+		case line := <-pipeline.Pipe():
+			*lines = append(*lines, line.Out)
+		}
+	}
+}
+
+var _ Engine = (*mockEngine)(nil)
+
+// mockEngine is a fake container engine. Containers are identified by the
+// container ID, or the step name if the ID is empty, and exit with the
+// configured exit code, or the configured wait error. Flaky containers exit with code 1 the configured
+// number of times before exiting with the configured exit code. Built images
+// are recorded by tag with the build context path. Containers write the
+// configured console output. Images are reported as pulled if configured, and
+// cache volumes as existing if configured.
+// Waiting for a blocked container signals the block channel, then waits until
+// the channel is closed.
+type mockEngine struct {
+	sync.Mutex
+	exit    map[string]int
+	oom     map[string]bool
+	fail    map[string]error
+	flaky   map[string]int
+	started []string
+	waited  []string
+	removed []string
+	built   map[string]string
+	images  []string
+	output  map[string]string
+	pulled  map[string]bool
+	block   map[string]chan struct{}
+	stopped []string
+	stats   map[string][]*Stats
+	execs   []string
+	caches  map[string]bool
+
+	networks        []*yaml.Network
+	removedNetworks []string
+
+	// pulls maps the started containers to their image, which is reported
+	// as pulled when starting the container if configured as pulled.
+	pulls map[string]string
+
+	// startErrs are the errors returned when starting the containers.
+	startErrs map[string]error
+}
+
+func newMockEngine() *mockEngine {
+	return &mockEngine{
+		exit:   map[string]int{},
+		oom:    map[string]bool{},
+		fail:   map[string]error{},
+		flaky:  map[string]int{},
+		built:  map[string]string{},
+		output: map[string]string{},
+		pulled: map[string]bool{},
+		caches: map[string]bool{},
+		pulls:  map[string]string{},
+		block:  map[string]chan struct{}{},
+		stats:  map[string][]*Stats{},
+	}
+}
+
+func (e *mockEngine) ContainerStart(c *yaml.Container) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	id := c.ID
+	if id == "" {
+		id = c.Name
+	}
+	if err := e.startErrs[id]; err != nil {
+		return "", err
+	}
+	e.started = append(e.started, id)
+	if e.pulls != nil {
+		e.pulls[id] = c.Image
+	}
+	return id, nil
+}
+
+func (e *mockEngine) ContainerStop(id string) error {
+	e.Lock()
+	defer e.Unlock()
+	e.stopped = append(e.stopped, id)
+	return nil
+}
+
+func (e *mockEngine) ContainerRemove(id string) error {
+	e.Lock()
+	defer e.Unlock()
+	e.removed = append(e.removed, id)
+	return nil
+}
+
+func (e *mockEngine) ContainerWait(id string) (*State, error) {
+	e.Lock()
+	defer e.Unlock()
+	e.waited = append(e.waited, id)
+	if block := e.block[id]; block != nil {
+		e.Unlock()
+		block <- struct{}{}
+		<-block
+		e.Lock()
+	}
+	if err := e.fail[id]; err != nil {
+		return nil, err
+	}
+	if e.flaky[id] > 0 {
+		e.flaky[id]--
+		return &State{ExitCode: 1}, nil
+	}
+	return &State{
+		ExitCode:  e.exit[id],
+		OOMKilled: e.oom[id],
+	}, nil
+}
+
+func (e *mockEngine) ContainerStats(id string, stop <-chan struct{}) (<-chan *Stats, error) {
+	e.Lock()
+	defer e.Unlock()
+	samples := make(chan *Stats, len(e.stats[id]))
+	for _, sample := range e.stats[id] {
+		samples <- sample
+	}
+	go func() {
+		<-stop
+		close(samples)
+	}()
+	return samples, nil
+}
+
+func (e *mockEngine) ContainerExec(id string, c *yaml.Container) (io.ReadCloser, error) {
+	e.Lock()
+	defer e.Unlock()
+	e.execs = append(e.execs, id+":"+c.Name)
+	output, code := e.output[c.Name], e.exit[c.Name]
+
+	r, w := io.Pipe()
+	go func() {
+		io.WriteString(w, output)
+		if code != 0 {
+			w.CloseWithError(&ExitError{c.Name, code})
+		} else {
+			w.Close()
+		}
+	}()
+	return r, nil
+}
+
+func (e *mockEngine) VolumeExists(name string) (bool, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.caches[name], nil
+}
+
+func (e *mockEngine) ContainerLogs(id string) (io.ReadCloser, error) {
+	e.Lock()
+	defer e.Unlock()
+	return ioutil.NopCloser(strings.NewReader(e.output[id])), nil
+}
+
+func (e *mockEngine) ImageBuild(c *yaml.Container) (io.ReadCloser, error) {
+	e.Lock()
+	defer e.Unlock()
+	e.built[c.Image] = c.ImageBuild.Context
+	return ioutil.NopCloser(strings.NewReader("Successfully built 4e2a6d8c3f1b\n")), nil
+}
+
+func (e *mockEngine) ImageRemove(image string) error {
+	e.Lock()
+	defer e.Unlock()
+	e.images = append(e.images, image)
+	return nil
+}
+
+func (e *mockEngine) ContainerPulled(id string) bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.pulled[e.pulls[id]]
+}
+
+func (e *mockEngine) NetworkCreate(n *yaml.Network) error {
+	e.Lock()
+	defer e.Unlock()
+	e.networks = append(e.networks, n)
+	return nil
+}
+
+func (e *mockEngine) NetworkRemove(name string) error {
+	e.Lock()
+	defer e.Unlock()
+	e.removedNetworks = append(e.removedNetworks, name)
+	return nil
+}
+
 var sampleYaml = `
 image: hello-world
 build:
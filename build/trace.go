@@ -0,0 +1,164 @@
+package build
+
+import (
+	"io"
+	"time"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// Trace returns an Engine that logs every operation of the engine, with the
+// container, image or network name and the duration of the operation, using
+// the log function.
+func Trace(engine Engine, logf func(string, ...interface{})) Engine {
+	return &traceEngine{engine: engine, logf: logf}
+}
+
+type traceEngine struct {
+	engine Engine
+	logf   func(string, ...interface{})
+}
+
+// trace logs the operation on the named object, started at the given time.
+func (e *traceEngine) trace(op, name string, start time.Time, err error) {
+	if err != nil {
+		e.logf("trace: %s %s failed after %v. %s", op, name, time.Since(start), err)
+		return
+	}
+	e.logf("trace: %s %s took %v", op, name, time.Since(start))
+}
+
+func (e *traceEngine) ContainerStart(c *yaml.Container) (string, error) {
+	start := time.Now()
+	id, err := e.engine.ContainerStart(c)
+	e.trace("container start", c.ID+" ("+c.Image+")", start, err)
+	return id, err
+}
+
+func (e *traceEngine) ContainerStop(id string) error {
+	start := time.Now()
+	err := e.engine.ContainerStop(id)
+	e.trace("container stop", id, start, err)
+	return err
+}
+
+func (e *traceEngine) ContainerRemove(id string) error {
+	start := time.Now()
+	err := e.engine.ContainerRemove(id)
+	e.trace("container remove", id, start, err)
+	return err
+}
+
+func (e *traceEngine) ContainerWait(id string) (*State, error) {
+	start := time.Now()
+	state, err := e.engine.ContainerWait(id)
+	e.trace("container wait", id, start, err)
+	return state, err
+}
+
+func (e *traceEngine) ContainerLogs(id string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := e.engine.ContainerLogs(id)
+	e.trace("container logs", id, start, err)
+	return rc, err
+}
+
+func (e *traceEngine) ImageBuild(c *yaml.Container) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := e.engine.ImageBuild(c)
+	e.trace("image build", c.Image, start, err)
+	return rc, err
+}
+
+func (e *traceEngine) ImageRemove(name string) error {
+	start := time.Now()
+	err := e.engine.ImageRemove(name)
+	e.trace("image remove", name, start, err)
+	return err
+}
+
+func (e *traceEngine) NetworkCreate(n *yaml.Network) error {
+	start := time.Now()
+	err := e.engine.NetworkCreate(n)
+	e.trace("network create", n.Name, start, err)
+	return err
+}
+
+func (e *traceEngine) NetworkRemove(name string) error {
+	start := time.Now()
+	err := e.engine.NetworkRemove(name)
+	e.trace("network remove", name, start, err)
+	return err
+}
+
+// ContainerPulled returns true if the traced engine implements ImageTracker
+// and pulled the image when starting the container.
+func (e *traceEngine) ContainerPulled(id string) bool {
+	tracker, ok := e.engine.(ImageTracker)
+	return ok && tracker.ContainerPulled(id)
+}
+
+// ContainerStats samples the container resource usage with the traced
+// engine, if the engine implements StatsCollector.
+func (e *traceEngine) ContainerStats(id string, stop <-chan struct{}) (<-chan *Stats, error) {
+	collector, ok := e.engine.(StatsCollector)
+	if !ok {
+		return nil, ErrStatsCollector
+	}
+	start := time.Now()
+	samples, err := collector.ContainerStats(id, stop)
+	e.trace("container stats", id, start, err)
+	return samples, err
+}
+
+// ContainerExec executes the step in the container with the traced engine,
+// if the engine implements ContainerExecer.
+func (e *traceEngine) ContainerExec(id string, c *yaml.Container) (io.ReadCloser, error) {
+	execer, ok := e.engine.(ContainerExecer)
+	if !ok {
+		return nil, ErrContainerExecer
+	}
+	start := time.Now()
+	rc, err := execer.ContainerExec(id, c)
+	e.trace("container exec", id, start, err)
+	return rc, err
+}
+
+// VolumeExists checks the volume with the traced engine, if the engine
+// implements VolumeInspector.
+func (e *traceEngine) VolumeExists(name string) (bool, error) {
+	inspector, ok := e.engine.(VolumeInspector)
+	if !ok {
+		return false, ErrVolumeInspector
+	}
+	start := time.Now()
+	exists, err := inspector.VolumeExists(name)
+	e.trace("volume exists", name, start, err)
+	return exists, err
+}
+
+// ContainerCopy copies the path from the container with the traced engine, if
+// the engine implements ContainerCopier.
+func (e *traceEngine) ContainerCopy(id, path string) (io.ReadCloser, error) {
+	copier, ok := e.engine.(ContainerCopier)
+	if !ok {
+		return nil, ErrContainerCopier
+	}
+	start := time.Now()
+	rc, err := copier.ContainerCopy(id, path)
+	e.trace("container copy", id, start, err)
+	return rc, err
+}
+
+// ImageDigest resolves the image digest with the traced engine, if the engine
+// implements ImageResolver.
+func (e *traceEngine) ImageDigest(image string) (string, error) {
+	resolver, ok := e.engine.(ImageResolver)
+	if !ok {
+		return "", ErrImageResolver
+	}
+	start := time.Now()
+	digest, err := resolver.ImageDigest(image)
+	e.trace("image digest", image, start, err)
+	return digest, err
+}
@@ -0,0 +1,76 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+// durations matches the operation durations in the trace output.
+var durations = regexp.MustCompile(`(took|failed after) [0-9.]+[a-zµ]+`)
+
+func TestTrace(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Trace engine", func() {
+
+		g.It("should trace each container operation", func() {
+			var mu sync.Mutex
+			var traces []string
+			logf := func(format string, args ...interface{}) {
+				mu.Lock()
+				traces = append(traces, fmt.Sprintf(format, args...))
+				mu.Unlock()
+			}
+
+			engine := newMockEngine()
+			engine.fail["test"] = errors.New("unexpected EOF")
+			spec := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{ID: "clone", Name: "clone", Image: "git"},
+					{ID: "test", Name: "test", Image: "golang:1.6"},
+				},
+			}
+			conf := Config{Engine: Trace(engine, logf)}
+			pipeline := conf.Pipeline(spec)
+
+			run(pipeline, nil)
+			pipeline.Teardown()
+
+			mu.Lock()
+			defer mu.Unlock()
+			var ops []string
+			for _, trace := range traces {
+				if strings.HasPrefix(trace, "trace: container logs") {
+					continue // logs are streamed concurrently
+				}
+				ops = append(ops, durations.ReplaceAllString(trace, "$1"))
+			}
+			g.Assert(ops).Equal([]string{
+				"trace: container start clone (git) took",
+				"trace: container wait clone took",
+				"trace: container start test (golang:1.6) took",
+				"trace: container wait test failed after. unexpected EOF",
+				"trace: container remove clone took",
+				"trace: container remove test took",
+			})
+		})
+
+		g.It("should trace the container logs", func() {
+			var traces []string
+			logf := func(format string, args ...interface{}) {
+				traces = append(traces, fmt.Sprintf(format, args...))
+			}
+			engine := Trace(newMockEngine(), logf)
+			engine.ContainerLogs("test")
+			g.Assert(len(traces)).Equal(1)
+			g.Assert(durations.ReplaceAllString(traces[0], "$1")).Equal("trace: container logs test took")
+		})
+	})
+}
@@ -1,22 +1,129 @@
 package build
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Line is a line of console output.
 type Line struct {
 	Proc string `json:"proc,omitempty"`
+	Step string `json:"step,omitempty"`
 	Time int64  `json:"time,omitempty"`
 	Type int    `json:"type,omitempty"`
 	Pos  int    `json:"pos,omityempty"`
 	Out  string `json:"out,omitempty"`
+
+	// Failure describes why the step failed. It is only set for the final
+	// line of a step that exited with a non-zero exit code or was oom
+	// killed.
+	Failure *Failure `json:"failure,omitempty"`
+
+	// Date is the wall-clock time the line was written. It is not sent
+	// to the server, which uses the time relative to the step start.
+	Date time.Time `json:"-"`
+}
+
+// Failure kinds.
+const (
+	FailureExit = "exit" // the step exited with a non-zero exit code
+	FailureOom  = "oom"  // the step received an oom kill
+)
+
+// Failure describes why a step failed.
+type Failure struct {
+	Kind     string `json:"kind"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// failure returns the failure of the step error, or nil if the error is not
+// an exit or oom error.
+func failure(err error) *Failure {
+	switch err := err.(type) {
+	case *ExitError:
+		return &Failure{Kind: FailureExit, ExitCode: err.Code}
+	case *OomError:
+		return &Failure{Kind: FailureOom}
+	}
+	return nil
 }
 
+// Timestamp formats for the text output of a Line.
+const (
+	TimeRelative = "relative" // seconds since the step started
+	TimeRFC3339  = "rfc3339"  // wall-clock time in RFC3339 format
+)
+
 func (l *Line) String() string {
 	return fmt.Sprintf("[%s:L%v:%vs] %s", l.Proc, l.Pos, l.Time, l.Out)
 }
 
+// Format returns the text output of the line using the timestamp format,
+// either TimeRelative or TimeRFC3339. The relative format is the default.
+func (l *Line) Format(format string) string {
+	if format != TimeRFC3339 {
+		return l.String()
+	}
+	return fmt.Sprintf("[%s:L%v:%s] %s", l.Proc, l.Pos, l.Date.UTC().Format(time.RFC3339), l.Out)
+}
+
+// DefaultPrefix is the default format of the step name prefix of a Line.
+const DefaultPrefix = "[%s] "
+
+// Prefix returns the text output of the line prefixed with the step name in
+// the prefix format, such as DefaultPrefix, instead of the line metadata.
+func (l *Line) Prefix(format string) string {
+	return fmt.Sprintf(format, l.Proc) + l.Out
+}
+
 // State defines the state of the container.
 type State struct {
 	ExitCode  int  // container exit code
 	OOMKilled bool // container exited due to oom error
 }
+
+// Stats defines the resource usage of a container.
+type Stats struct {
+	Memory uint64  // memory usage in bytes
+	CPU    float64 // cpu usage as a percentage of one cpu
+}
+
+// Result defines the result of an individual pipeline step.
+type Result struct {
+	ID       string    // stable step identifier
+	Name     string    // step name
+	Started  time.Time // time the step started
+	Finished time.Time // time the step finished
+	Skipped  bool      // step was skipped
+	Err      error     // step error, if any
+
+	// Stats defines the peak resource usage of the step, if sampled.
+	Stats *Stats
+
+	// Cache maps the cache volumes first mounted by the step to true if
+	// the cache was restored (hit), including from the fallback branch
+	// cache, or false if it started empty (miss).
+	Cache map[string]bool
+}
+
+// Duration returns the step execution time.
+func (r *Result) Duration() time.Duration {
+	if r.Finished.IsZero() {
+		return 0
+	}
+	return r.Finished.Sub(r.Started)
+}
+
+// Summary summarizes the execution of the pipeline.
+type Summary struct {
+	Total   int   // total steps in the pipeline
+	Run     int   // steps executed
+	Skipped int   // steps skipped
+	Failed  int   // steps failed
+	Err     error // pipeline error, if any
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("%d steps, %d run, %d skipped, %d failed",
+		s.Total, s.Run, s.Skipped, s.Failed)
+}
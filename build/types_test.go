@@ -2,6 +2,7 @@ package build
 
 import (
 	"testing"
+	"time"
 
 	"github.com/franela/goblin"
 )
@@ -19,5 +20,36 @@ func TestLine(t *testing.T) {
 			}
 			g.Assert(line.String()).Equal("[redis:L1:60s] starting redis server")
 		})
+
+		g.It("should format relative and absolute timestamps", func() {
+			line := Line{
+				Proc: "redis",
+				Time: 60,
+				Pos:  1,
+				Out:  "starting redis server",
+				Date: time.Date(2016, 1, 2, 15, 4, 5, 0, time.FixedZone("PST", -8*3600)),
+			}
+			g.Assert(line.Format(TimeRelative)).Equal("[redis:L1:60s] starting redis server")
+			g.Assert(line.Format(TimeRFC3339)).Equal("[redis:L1:2016-01-02T23:04:05Z] starting redis server")
+		})
+
+		g.It("should prefix lines with the step name", func() {
+			lines := []Line{
+				{Proc: "redis", Pos: 1, Time: 2, Out: "starting redis server"},
+				{Proc: "test", Pos: 1, Time: 3, Out: "go test ./..."},
+			}
+			g.Assert(lines[0].Prefix(DefaultPrefix)).Equal("[redis] starting redis server")
+			g.Assert(lines[1].Prefix(DefaultPrefix)).Equal("[test] go test ./...")
+			g.Assert(lines[1].Prefix("%s | ")).Equal("test | go test ./...")
+		})
+
+		g.It("should not prefix lines by default", func() {
+			lines := []Line{
+				{Proc: "redis", Pos: 1, Time: 2, Out: "starting redis server"},
+				{Proc: "test", Pos: 1, Time: 3, Out: "go test ./..."},
+			}
+			g.Assert(lines[0].Format(TimeRelative)).Equal("[redis:L1:2s] starting redis server")
+			g.Assert(lines[1].Format(TimeRelative)).Equal("[test:L1:3s] go test ./...")
+		})
 	})
 }
@@ -0,0 +1,69 @@
+package control
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Watch watches the control file at path and closes the returned channel
+// when cancellation is requested, until done is closed. Cancellation is
+// requested when the file is created, or when it contains the word cancel,
+// after which the file is removed. If the file is a named pipe, cancellation
+// is requested when the word cancel is written to the pipe.
+func Watch(path string, interval time.Duration, done <-chan struct{}) <-chan struct{} {
+	cancel := make(chan struct{})
+	info, err := os.Stat(path)
+	if err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		go watchPipe(path, cancel, done)
+	} else {
+		go watchFile(path, err == nil, interval, cancel, done)
+	}
+	return cancel
+}
+
+// watchFile polls the control file at the given interval.
+func watchFile(path string, exists bool, interval time.Duration, cancel chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+
+		out, err := ioutil.ReadFile(path)
+		if err != nil {
+			exists = false
+			continue
+		}
+		if !exists || strings.Contains(string(out), "cancel") {
+			os.Remove(path)
+			close(cancel)
+			return
+		}
+	}
+}
+
+// watchPipe reads lines from the named pipe. The pipe is opened for reading
+// and writing so that opening the pipe does not block until a writer opens
+// the pipe, and reading does not end when a writer closes the pipe.
+func watchPipe(path string, cancel chan struct{}, done <-chan struct{}) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	go func() {
+		<-done
+		f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "cancel" {
+			close(cancel)
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+)
+
+func TestWatch(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Control file", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_control_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		interval := time.Millisecond * 10
+
+		g.It("should cancel when the file is created", func() {
+			path := filepath.Join(dir, "created")
+			done := make(chan struct{})
+			defer close(done)
+
+			cancel := Watch(path, interval, done)
+			ioutil.WriteFile(path, nil, 0644)
+			g.Assert(cancelled(cancel)).IsTrue("expects cancellation")
+
+			_, err := os.Stat(path)
+			g.Assert(os.IsNotExist(err)).IsTrue("expects control file removed")
+		})
+
+		g.It("should cancel when the file contains cancel", func() {
+			path := filepath.Join(dir, "contains")
+			ioutil.WriteFile(path, []byte("running\n"), 0644)
+			done := make(chan struct{})
+			defer close(done)
+
+			cancel := Watch(path, interval, done)
+			g.Assert(cancelled(cancel)).IsFalse("expects no cancellation")
+
+			ioutil.WriteFile(path, []byte("cancel\n"), 0644)
+			g.Assert(cancelled(cancel)).IsTrue("expects cancellation")
+		})
+
+		g.It("should cancel when cancel is written to a named pipe", func() {
+			path := filepath.Join(dir, "pipe")
+			if err := syscall.Mkfifo(path, 0644); err != nil {
+				g.Fail(err)
+			}
+			done := make(chan struct{})
+			defer close(done)
+
+			cancel := Watch(path, interval, done)
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				g.Fail(err)
+			}
+			f.WriteString("status\n")
+			g.Assert(cancelled(cancel)).IsFalse("expects no cancellation")
+			f.WriteString("cancel\n")
+			f.Close()
+			g.Assert(cancelled(cancel)).IsTrue("expects cancellation")
+		})
+
+		g.It("should stop watching when done", func() {
+			path := filepath.Join(dir, "done")
+			done := make(chan struct{})
+
+			cancel := Watch(path, interval, done)
+			close(done)
+			time.Sleep(interval * 2)
+			ioutil.WriteFile(path, nil, 0644)
+			g.Assert(cancelled(cancel)).IsFalse("expects no cancellation")
+		})
+	})
+}
+
+// cancelled returns true if the channel is closed within a short timeout.
+func cancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	case <-time.After(time.Millisecond * 200):
+		return false
+	}
+}
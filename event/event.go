@@ -0,0 +1,92 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-exec/build"
+)
+
+// Event types.
+const (
+	StepStarted  = "step_started"
+	StepFinished = "step_finished"
+	StepLine     = "line"
+	BuildDone    = "build_done"
+)
+
+// Event is a structured build event.
+type Event struct {
+	Type     string      `json:"type"`
+	Job      int64       `json:"job"`
+	Time     int64       `json:"time"`
+	Step     string      `json:"step,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Skipped  bool        `json:"skipped,omitempty"`
+	ExitCode int         `json:"exit_code,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Line     *build.Line `json:"line,omitempty"`
+}
+
+// writeTimeout defines the maximum duration of an event write to the socket,
+// so a consumer that stops reading cannot block the build.
+const writeTimeout = time.Second
+
+// Writer writes events as newline-delimited JSON. It is safe to use
+// concurrently. Once a write to the socket times out, the event and all
+// later events are dropped.
+type Writer struct {
+	sync.Mutex
+	w    io.Writer
+	c    io.Closer
+	conn net.Conn
+	err  error
+}
+
+// NewWriter returns a new Writer that writes events to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Dial returns a new Writer that writes events to the Unix domain socket at
+// the given path.
+func Dial(path string) (*Writer, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: conn, c: conn, conn: conn}, nil
+}
+
+// Write writes the event followed by a newline.
+func (w *Writer) Write(e *Event) error {
+	out, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.conn != nil {
+		w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	_, err = w.w.Write(append(out, '\n'))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		w.err = err
+	}
+	return err
+}
+
+// Close closes the socket of a Writer returned by Dial. The writer of a
+// Writer returned by NewWriter is not closed.
+func (w *Writer) Close() error {
+	if w.c == nil {
+		return nil
+	}
+	return w.c.Close()
+}
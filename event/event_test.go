@@ -0,0 +1,116 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/franela/goblin"
+)
+
+func TestWriter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Event writer", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_event_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should stream events to a unix socket", func() {
+			path := filepath.Join(dir, "events.sock")
+			l, err := net.Listen("unix", path)
+			if err != nil {
+				g.Fail(err)
+			}
+			defer l.Close()
+
+			events := make(chan *Event)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					e := new(Event)
+					json.Unmarshal(scanner.Bytes(), e)
+					events <- e
+				}
+				close(events)
+			}()
+
+			w, err := Dial(path)
+			g.Assert(err == nil).IsTrue()
+			w.Write(&Event{Type: StepStarted, Job: 1, Name: "test"})
+			w.Write(&Event{Type: StepLine, Job: 1, Name: "test", Line: &build.Line{Proc: "test", Out: "PASS"}})
+			w.Write(&Event{Type: StepFinished, Job: 1, Name: "test"})
+			w.Write(&Event{Type: BuildDone, Job: 1, ExitCode: 1, Error: "test : exit code 1"})
+			w.Close()
+
+			var got []*Event
+			for e := range events {
+				got = append(got, e)
+			}
+			g.Assert(len(got)).Equal(4)
+			g.Assert(got[0].Type).Equal(StepStarted)
+			g.Assert(got[0].Name).Equal("test")
+			g.Assert(got[1].Type).Equal(StepLine)
+			g.Assert(got[1].Line.Out).Equal("PASS")
+			g.Assert(got[2].Type).Equal(StepFinished)
+			g.Assert(got[3].Type).Equal(BuildDone)
+			g.Assert(got[3].ExitCode).Equal(1)
+			g.Assert(got[3].Error).Equal("test : exit code 1")
+		})
+
+		g.It("should drop events when the socket is not read", func() {
+			path := filepath.Join(dir, "stalled.sock")
+			l, err := net.Listen("unix", path)
+			if err != nil {
+				g.Fail(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err == nil {
+					defer conn.Close()
+					time.Sleep(5 * time.Second)
+				}
+			}()
+
+			w, err := Dial(path)
+			g.Assert(err == nil).IsTrue()
+			defer w.Close()
+
+			line := &build.Line{Proc: "test", Out: strings.Repeat("x", 64*1024)}
+			start := time.Now()
+			for i := 0; i < 1000 && err == nil; i++ {
+				err = w.Write(&Event{Type: StepLine, Job: 1, Name: "test", Line: line})
+			}
+			g.Assert(err != nil).IsTrue("expects write timeout")
+			g.Assert(time.Since(start) < 4*time.Second).IsTrue("expects write deadline")
+
+			start = time.Now()
+			err = w.Write(&Event{Type: BuildDone, Job: 1})
+			g.Assert(err != nil).IsTrue("expects later events dropped")
+			g.Assert(time.Since(start) < 100*time.Millisecond).IsTrue("expects later events dropped without waiting")
+		})
+
+		g.It("should error when the socket does not exist", func() {
+			_, err := Dial(filepath.Join(dir, "missing.sock"))
+			g.Assert(err != nil).IsTrue("expects dial error")
+		})
+	})
+}
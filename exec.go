@@ -2,30 +2,90 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/drone/drone-exec/agent"
-	"github.com/drone/drone-exec/build/docker"
+	"github.com/drone/drone-exec/archive"
+	"github.com/drone/drone-exec/build"
 	"github.com/drone/drone-exec/client"
-
-	"github.com/samalba/dockerclient"
+	"github.com/drone/drone-exec/control"
+	"github.com/drone/drone-exec/event"
+	"github.com/drone/drone-exec/lock"
+	"github.com/drone/drone-exec/lockfile"
+	"github.com/drone/drone-exec/metrics"
+	"github.com/drone/drone-exec/record"
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-exec/yaml/matrix"
+	"github.com/drone/drone-exec/yaml/transform"
+	"github.com/drone/drone-go/drone"
+	"golang.org/x/net/context"
 )
 
 type config struct {
 	platform   string
 	namespace  string
+	clone      string
+	ambassador string
+	image      string
+	retries    int
+	backoff    time.Duration
+	rebuilds   int
+	yaml       string
+	checksum   string
+	once       bool
+	locks      string
+	control    string
+	record     string
+	secrets    bool
+	print      bool
+	color      bool
 	privileged []string
 	pull       bool
 	logs       int64
+	lines      int
+	rate       int
+	maxLines   int
+	preserve   []string
+	mtu        int
+	timestamps string
+	prefix     string
+	detached   string
+	events     string
+	prune      bool
+	keep       []string
+	stop       bool
+	strict     bool
+	stats      bool
+	nodes      []string
+	matrix     bool
+	parallel   int
+	insecure   bool
 	timeout    time.Duration
+
+	// digests maps the image references to the digests to which the build
+	// images are pinned, loaded from the image lockfile.
+	digests map[string]string
+
+	// archive defines the destination of the workspace archive of failed
+	// builds, and archiveSize and archiveExclude the maximum size in bytes
+	// and the excluded file patterns.
+	archive        string
+	archiveSize    int64
+	archiveExclude []string
 }
 
 type pipeline struct {
-	drone  client.Client
-	docker dockerclient.Client
-	config config
+	drone   client.Client
+	engine  build.Engine
+	config  config
+	metrics *metrics.Pusher
+	events  *event.Writer
 }
 
 func (r *pipeline) run() error {
@@ -34,11 +94,23 @@ func (r *pipeline) run() error {
 		return err
 	}
 
+	// refuse to run the build if it is already running on this host, to
+	// prevent duplicate containers from colliding.
+	if r.config.once {
+		l, err := lock.Acquire(r.lockfile(w))
+		if err != nil {
+			logrus.Errorf("Refusing to start build %s/%s#%d.%d. %s",
+				w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number, err)
+			return nil
+		}
+		defer l.Release()
+	}
+
 	logrus.Infof("Starting build %s/%s#%d.%d",
 		w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
 
-	cancel := make(chan bool, 1)
-	engine := docker.NewClient(r.docker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// streaming the logs
 	// rc, wc := io.Pipe()
@@ -54,16 +126,13 @@ func (r *pipeline) run() error {
 		return err
 	}
 
-	a := agent.Agent{
-		Update: agent.NewClientUpdater(r.drone),
-		// Logger:    agent.NewClientLogger(r.drone, w.Job.ID, rc, wc, r.config.logs),
-		Logger:    agent.NewStreamLogger(stream, &buf, r.config.logs),
-		Engine:    engine,
-		Timeout:   r.config.timeout,
-		Platform:  r.config.platform,
-		Namespace: r.config.namespace,
-		Escalate:  r.config.privileged,
-		Pull:      r.config.pull,
+	a := r.agent()
+	a.Update = agent.NewClientUpdater(r.drone)
+	// a.Logger = agent.NewClientLogger(r.drone, w.Job.ID, rc, wc, r.config.logs)
+	a.Logger = agent.NewStreamLogger(stream, &buf, r.config.logs)
+
+	if r.metrics != nil {
+		a.Report = r.report
 	}
 
 	// signal for canceling the build.
@@ -71,13 +140,29 @@ func (r *pipeline) run() error {
 	defer wait.Cancel()
 	go func() {
 		if _, err := wait.Done(); err == nil {
-			cancel <- true
+			cancel()
 			logrus.Infof("Cancel build %s/%s#%d.%d",
 				w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
 		}
 	}()
 
-	a.Run(w, cancel)
+	// signal for canceling the build using the control file, for
+	// orchestrators that cannot signal the agent process.
+	if r.config.control != "" {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-control.Watch(r.config.control, time.Second, done):
+				cancel()
+				logrus.Infof("Cancel build %s/%s#%d.%d using control file",
+					w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
+			case <-done:
+			}
+		}()
+	}
+
+	agent.Run(ctx, w, agent.Options{Agent: a})
 
 	if err := r.drone.LogPost(w.Job.ID, ioutil.NopCloser(&buf)); err != nil {
 		logrus.Errorf("Error sending logs for %s/%s#%d.%d",
@@ -90,3 +175,254 @@ func (r *pipeline) run() error {
 
 	return nil
 }
+
+// replay runs the recorded build payload, writing the build output to the
+// terminal instead of the drone server.
+func (r *pipeline) replay(path string) error {
+	w, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Replaying build %s/%s#%d.%d",
+		w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
+
+	a := r.agent()
+	a.Logger = agent.NewTermLogger(r.config.timestamps)
+	if r.config.prefix != "" {
+		a.Logger = agent.NewPrefixLogger(r.config.prefix)
+	}
+	a.Replay = true
+
+	// expand the matrix of the recorded Yaml and run each combination, if
+	// enabled, instead of the recorded matrix job.
+	if r.config.matrix {
+		axes, err := matrix.ParseString(w.Yaml)
+		if err != nil {
+			return err
+		}
+		if len(axes) != 0 {
+			results, err := agent.RunMatrix(context.Background(), w, axes, r.config.parallel, agent.Options{Agent: a})
+			for i, res := range results {
+				fmt.Printf("%s\n", axes[i])
+				fmt.Print(build.Banner(res.Steps, res.Err, r.config.color))
+			}
+			if err != nil {
+				logrus.Errorf("Matrix build %s/%s#%d failed. %s",
+					w.Repo.Owner, w.Repo.Name, w.Build.Number, err)
+			}
+			return err
+		}
+	}
+
+	res, err := agent.Run(context.Background(), w, agent.Options{Agent: a})
+
+	fmt.Print(build.Banner(res.Steps, err, r.config.color))
+	return err
+}
+
+// tree writes the parsed and transformed configuration of the recorded build
+// payload to stdout as JSON, with secrets redacted, without executing the
+// build.
+func (r *pipeline) tree(path string) error {
+	w, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+
+	a := r.agent()
+	a.Replay = true
+	conf, err := a.Tree(w)
+	if err != nil {
+		return err
+	}
+	out, err := record.Tree(w, conf)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", out)
+	return err
+}
+
+// list writes the steps of the transformed configuration of the recorded
+// build payload to stdout, without executing the build.
+func (r *pipeline) list(path string) error {
+	w, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+
+	a := r.agent()
+	a.Replay = true
+	conf, err := a.Tree(w)
+	if err != nil {
+		return err
+	}
+	return build.List(os.Stdout, conf)
+}
+
+// lint writes the problems found in the Yaml file to stdout, without
+// executing the build. An error is returned if any problem is an error.
+func lint(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	results, err := yaml.Lint(data)
+	if err != nil {
+		return err
+	}
+	var errs int
+	for _, result := range results {
+		fmt.Fprintln(os.Stdout, result)
+		if result.Severity == yaml.LintError {
+			errs++
+		}
+	}
+	if errs != 0 {
+		return fmt.Errorf("Found %d errors in %s", errs, path)
+	}
+	return nil
+}
+
+// explain writes the changes made to the steps of the recorded build payload
+// by the Yaml transforms to stdout, without executing the build.
+func (r *pipeline) explain(path string) error {
+	w, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+
+	a := r.agent()
+	a.Replay = true
+	a.Explain = func(change *transform.Change) {
+		fmt.Fprintln(os.Stdout, change)
+	}
+	_, err = a.Tree(w)
+	return err
+}
+
+// lock resolves the digests of the images of the recorded build payload and
+// writes the image lockfile, without executing the build.
+func (r *pipeline) lock(path, lock string) error {
+	w, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+	resolver, ok := r.engine.(build.ImageResolver)
+	if !ok {
+		return build.ErrImageResolver
+	}
+
+	a := r.agent()
+	a.Replay = true
+	a.ImageDigests = nil
+	conf, err := a.Tree(w)
+	if err != nil {
+		return err
+	}
+
+	digests := map[string]string{}
+	for _, c := range append(conf.Services, conf.Pipeline...) {
+		if _, ok := digests[c.Image]; ok || strings.Contains(c.Image, "@") {
+			continue
+		}
+		digest, err := resolver.ImageDigest(c.Image)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Pinned %s to %s", c.Image, digest)
+		digests[c.Image] = digest
+	}
+	return lockfile.Save(lock, digests)
+}
+
+// agent returns a build agent for the pipeline configuration. The caller is
+// responsible for setting the updater and logger.
+func (r *pipeline) agent() *agent.Agent {
+	a := &agent.Agent{
+		Engine:    r.engine,
+		Timeout:   r.config.timeout,
+		Platform:  r.config.platform,
+		Namespace: r.config.namespace,
+		Clone:     r.config.clone,
+		Pod:       r.config.ambassador,
+		Image:     r.config.image,
+		Escalate:  r.config.privileged,
+		Pull:      r.config.pull,
+		LineSize:  r.config.lines,
+		LineRate:  r.config.rate,
+		MaxLines:  r.config.maxLines,
+		Preserve:  r.config.preserve,
+		MTU:       r.config.mtu,
+
+		CloneRetries: r.config.retries,
+		CloneBackoff: r.config.backoff,
+		BuildRetries: r.config.rebuilds,
+		YamlURL:      r.config.yaml,
+		YamlChecksum: r.config.checksum,
+		DetachedLogs: r.config.detached,
+		PruneImages:  r.config.prune,
+		KeepImages:   r.config.keep,
+		ImageDigests: r.config.digests,
+		DisableNodes: r.config.nodes,
+		StopDetached: r.config.stop,
+		StrictImages: r.config.strict,
+		StepStats:    r.config.stats,
+
+		InsecureSkipVerify: r.config.insecure,
+	}
+	if r.config.archive != "" {
+		a.Archiver = &archive.Archiver{
+			Engine:   r.engine,
+			Uploader: archive.NewUploader(r.config.archive),
+			Exclude:  r.config.archiveExclude,
+			MaxSize:  r.config.archiveSize,
+			Image:    r.config.ambassador,
+		}
+	}
+	if r.config.record != "" || r.config.print {
+		a.Record = r.save
+	}
+	if r.events != nil {
+		a.Events = func(e *event.Event) {
+			if err := r.events.Write(e); err != nil {
+				logrus.Debugf("Error writing build event. %s", err)
+			}
+		}
+	}
+	return a
+}
+
+// save records the resolved build payload and prints the resolved Yaml
+// configuration, if enabled. Recording is best effort and failures are
+// logged, but never fail the build.
+func (r *pipeline) save(w *drone.Payload) {
+	if r.config.print {
+		fmt.Fprintf(os.Stdout, "# %s/%s#%d.%d\n%s\n",
+			w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number, record.Yaml(w))
+	}
+	if r.config.record == "" {
+		return
+	}
+	if err := record.Save(r.config.record, w, r.config.secrets); err != nil {
+		logrus.Warnf("Error recording %s/%s#%d.%d. %s",
+			w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number, err)
+	}
+}
+
+// lockfile returns the path of the lock file for the build job.
+func (r *pipeline) lockfile(w *drone.Payload) string {
+	name := fmt.Sprintf("drone_%s_%s_%d_%d.lock",
+		w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number)
+	return filepath.Join(r.config.locks, name)
+}
+
+// report pushes the build metrics to the Prometheus Pushgateway. Metrics are
+// best effort and failures are logged, but never fail the build.
+func (r *pipeline) report(w *drone.Payload, results []*build.Result) {
+	if err := r.metrics.Push(w, results); err != nil {
+		logrus.Warnf("Error pushing metrics for %s/%s#%d.%d. %s",
+			w.Repo.Owner, w.Repo.Name, w.Build.Number, w.Job.Number, err)
+	}
+}
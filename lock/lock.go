@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrLocked is returned when the lock is held by another running process.
+var ErrLocked = errors.New("lock is held by another process")
+
+// Lock is a lock file containing the process identifier of the holder.
+type Lock struct {
+	path string
+}
+
+// Acquire acquires the lock file at the given path. If the lock file is held
+// by a process that is no longer running, the stale lock is removed and the
+// lock is acquired.
+func Acquire(path string) (*Lock, error) {
+	err := create(path)
+	if os.IsExist(err) && stale(path) {
+		os.Remove(path)
+		err = create(path)
+	}
+	if os.IsExist(err) {
+		return nil, ErrLocked
+	} else if err != nil {
+		return nil, err
+	}
+	return &Lock{path}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
+
+// create creates the lock file and writes the current process identifier.
+// It returns an error if the lock file already exists.
+func create(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// stale returns true if the lock file is held by a process that is no longer
+// running, or the lock file does not contain a valid process identifier. An
+// empty lock file is not stale, since the holder may not have written the
+// process identifier yet.
+func stale(path string) bool {
+	out, err := ioutil.ReadFile(path)
+	if err != nil || len(strings.TrimSpace(string(out))) == 0 {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid <= 0 {
+		return true
+	}
+	// signal 0 checks for the existence of the process without sending a
+	// signal. The process exists if permission to signal it is denied.
+	err = syscall.Kill(pid, 0)
+	return err == syscall.ESRCH
+}
@@ -0,0 +1,76 @@
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestLock(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Lock file", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_lock_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should acquire and release the lock", func() {
+			path := filepath.Join(dir, "acquire.lock")
+			l, err := Acquire(path)
+			g.Assert(err == nil).IsTrue("expects lock acquired")
+
+			out, _ := ioutil.ReadFile(path)
+			g.Assert(string(out)).Equal(strconv.Itoa(os.Getpid()) + "\n")
+
+			g.Assert(l.Release() == nil).IsTrue("expects lock released")
+			_, err = os.Stat(path)
+			g.Assert(os.IsNotExist(err)).IsTrue("expects lock file removed")
+		})
+
+		g.It("should not acquire a held lock", func() {
+			path := filepath.Join(dir, "held.lock")
+			l, err := Acquire(path)
+			g.Assert(err == nil).IsTrue("expects lock acquired")
+			defer l.Release()
+
+			_, err = Acquire(path)
+			g.Assert(err).Equal(ErrLocked)
+		})
+
+		g.It("should acquire a stale lock", func() {
+			cmd := exec.Command("true")
+			if err := cmd.Run(); err != nil {
+				g.Fail(err)
+			}
+			path := filepath.Join(dir, "stale.lock")
+			ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", cmd.ProcessState.Pid())), 0644)
+
+			l, err := Acquire(path)
+			g.Assert(err == nil).IsTrue("expects stale lock acquired")
+			defer l.Release()
+
+			out, _ := ioutil.ReadFile(path)
+			g.Assert(string(out)).Equal(strconv.Itoa(os.Getpid()) + "\n")
+		})
+
+		g.It("should acquire a corrupt lock", func() {
+			path := filepath.Join(dir, "corrupt.lock")
+			ioutil.WriteFile(path, []byte("garbage"), 0644)
+
+			l, err := Acquire(path)
+			g.Assert(err == nil).IsTrue("expects corrupt lock acquired")
+			l.Release()
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package lockfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// header is written at the top of the generated lockfile.
+const header = "# image digests pinned with drone-exec --update-lock\n"
+
+// Load reads the lockfile at path, which maps each image reference to the
+// digest of the image, for example:
+//
+//	golang:1.5: sha256:4e2a6d8c3f1b
+func Load(path string) (map[string]string, error) {
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	digests := map[string]string{}
+	if err := yaml.Unmarshal(out, &digests); err != nil {
+		return nil, fmt.Errorf("Invalid lockfile %s. %s", path, err)
+	}
+	for image, digest := range digests {
+		if !strings.HasPrefix(digest, "sha256:") {
+			return nil, fmt.Errorf("Invalid lockfile %s. Invalid digest %q for %s", path, digest, image)
+		}
+	}
+	return digests, nil
+}
+
+// Save writes the image digests to the lockfile at path, sorted by image
+// reference.
+func Save(path string, digests map[string]string) error {
+	out, err := yaml.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(header), out...), 0644)
+}
@@ -0,0 +1,56 @@
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestLockfile(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Lockfile", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_lockfile_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should save and load the image digests", func() {
+			path := filepath.Join(dir, "images.lock")
+			digests := map[string]string{
+				"plugins/docker:latest": "sha256:fedcba9876543210",
+				"golang:1.5":            "sha256:0123456789abcdef",
+			}
+			err := Save(path, digests)
+			g.Assert(err == nil).IsTrue("expects lockfile saved")
+
+			out, _ := ioutil.ReadFile(path)
+			g.Assert(string(out)).Equal(header +
+				"golang:1.5: sha256:0123456789abcdef\n" +
+				"plugins/docker:latest: sha256:fedcba9876543210\n")
+
+			got, err := Load(path)
+			g.Assert(err == nil).IsTrue("expects lockfile loaded")
+			g.Assert(got).Equal(digests)
+		})
+
+		g.It("should error on invalid digests", func() {
+			path := filepath.Join(dir, "invalid.lock")
+			ioutil.WriteFile(path, []byte("golang:1.5: latest\n"), 0644)
+			_, err := Load(path)
+			g.Assert(err.Error()).Equal("Invalid lockfile " + path + `. Invalid digest "latest" for golang:1.5`)
+		})
+
+		g.It("should error on a missing lockfile", func() {
+			_, err := Load(filepath.Join(dir, "missing.lock"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+	})
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-exec/build/docker"
 	"github.com/drone/drone-exec/client"
+	"github.com/drone/drone-exec/event"
+	"github.com/drone/drone-exec/lockfile"
+	"github.com/drone/drone-exec/metrics"
+	"github.com/drone/drone-exec/profile"
 	"github.com/drone/drone-exec/token"
+	"github.com/drone/drone-exec/yaml"
 	"github.com/samalba/dockerclient"
 
 	"github.com/Sirupsen/logrus"
@@ -21,10 +32,14 @@ func main() {
 	app.Action = start
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
-			EnvVar: "DOCKER_HOST",
-			Name:   "docker-host",
-			Usage:  "docker deamon address",
-			Value:  "unix:///var/run/docker.sock",
+			EnvVar: "DRONE_ENGINE",
+			Name:   "engine",
+			Usage:  "container engine",
+			Value:  "docker",
+		},
+		cli.StringFlag{
+			Name:  "docker-host",
+			Usage: "docker deamon address, defaults to DOCKER_HOST, the rootless docker socket or the default socket",
 		},
 		cli.BoolFlag{
 			EnvVar: "DOCKER_TLS_VERIFY",
@@ -43,6 +58,57 @@ func main() {
 			Usage:  "limit number of running docker processes",
 			Value:  2,
 		},
+		cli.IntFlag{
+			EnvVar: "DOCKER_WAIT_RETRIES",
+			Name:   "docker-wait-retries",
+			Usage:  "retry waiting for a container when the docker daemon connection is lost",
+			Value:  docker.DefaultWaitRetries,
+		},
+		cli.DurationFlag{
+			EnvVar: "DOCKER_WAIT_BACKOFF",
+			Name:   "docker-wait-backoff",
+			Usage:  "docker daemon reconnect backoff interval",
+			Value:  docker.DefaultWaitBackoff,
+		},
+		cli.DurationFlag{
+			EnvVar: "DOCKER_WAIT_WATCHDOG",
+			Name:   "docker-wait-watchdog",
+			Usage:  "inspect a container when waiting for it is stuck for this interval, 0 to disable",
+			Value:  docker.DefaultWaitWatchdog,
+		},
+		cli.IntFlag{
+			EnvVar: "DOCKER_PULL_RETRIES",
+			Name:   "docker-pull-retries",
+			Usage:  "retry pulling an image that is not found, for images that have not propagated to the registry",
+			Value:  docker.DefaultPullRetries,
+		},
+		cli.DurationFlag{
+			EnvVar: "DOCKER_PULL_BACKOFF",
+			Name:   "docker-pull-backoff",
+			Usage:  "delay between retries pulling an image that is not found",
+			Value:  docker.DefaultPullBackoff,
+		},
+		cli.IntFlag{
+			EnvVar: "DOCKER_MAX_IMAGE_SIZE",
+			Name:   "docker-max-image-size",
+			Usage:  "maximum size in megabytes of the images pulled for unprivileged steps, 0 to disable",
+		},
+		cli.StringFlag{
+			EnvVar: "DOCKER_CREDENTIAL_HELPER",
+			Name:   "docker-credential-helper",
+			Usage:  "docker credential helper used to authenticate image pulls",
+		},
+		cli.DurationFlag{
+			EnvVar: "DOCKER_CREDENTIAL_TTL",
+			Name:   "docker-credential-ttl",
+			Usage:  "docker credential helper cache duration",
+			Value:  time.Minute * 10,
+		},
+		cli.StringSliceFlag{
+			EnvVar: "DOCKER_REGISTRY_MIRRORS",
+			Name:   "docker-registry-mirror",
+			Usage:  "registry mirrors, tried in order, from which images are pulled when the image registry fails",
+		},
 		cli.StringFlag{
 			EnvVar: "DOCKER_OS",
 			Name:   "docker-os",
@@ -94,6 +160,11 @@ func main() {
 			Name:   "debug",
 			Usage:  "start the agent in debug mode",
 		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_TRACE",
+			Name:   "trace",
+			Usage:  "start the agent in debug mode and trace every container engine operation",
+		},
 		cli.DurationFlag{
 			EnvVar: "DRONE_TIMEOUT",
 			Name:   "timeout",
@@ -106,6 +177,27 @@ func main() {
 			Usage:  "drone maximum log size in megabytes",
 			Value:  5,
 		},
+		cli.IntFlag{
+			EnvVar: "DRONE_MAX_LOG_LINES",
+			Name:   "max-log-lines",
+			Usage:  "cancel the build when the log exceeds the maximum number of lines",
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_MAX_LINE_SIZE",
+			Name:   "max-line-size",
+			Usage:  "drone maximum log line size in kilobytes",
+			Value:  64,
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_MAX_LINE_RATE",
+			Name:   "max-line-rate",
+			Usage:  "drone maximum log lines per second for each step",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_METRICS_PUSHGATEWAY",
+			Name:   "metrics-pushgateway",
+			Usage:  "prometheus pushgateway address",
+		},
 		cli.StringSliceFlag{
 			EnvVar: "DRONE_PLUGIN_PRIVILEGED",
 			Name:   "privileged",
@@ -119,12 +211,239 @@ func main() {
 				"plugins/ecr:*",
 			},
 		},
+		cli.StringSliceFlag{
+			EnvVar: "DRONE_PRESERVE_ON_FAILURE",
+			Name:   "preserve-on-failure",
+			Usage:  "node types, services or build, whose containers are kept when the build fails",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_DETACHED_LOGS",
+			Name:   "detached-logs",
+			Usage:  "directory to which detached container output is written, in a subdirectory per build with one file per container, which can be tailed during the build",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_PRUNE_IMAGES",
+			Name:   "prune-images",
+			Usage:  "remove the images pulled for the build once the build completes",
+		},
+		cli.StringSliceFlag{
+			EnvVar: "DRONE_PRUNE_IMAGES_KEEP",
+			Name:   "prune-images-keep",
+			Usage:  "patterns of the pulled images that are never removed, such as busybox:*",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_STEP_STATS",
+			Name:   "step-stats",
+			Usage:  "sample the memory and cpu usage of each step and report the peak usage in the build summary",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_STRICT_IMAGES",
+			Name:   "strict-images",
+			Usage:  "require the yaml images to define an explicit tag or digest instead of defaulting to latest",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_STOP_DETACHED",
+			Name:   "stop-detached",
+			Usage:  "stop the service containers, except the ambassador, as soon as a step fails instead of on teardown",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_EVENT_SOCKET",
+			Name:   "event-socket",
+			Usage:  "unix socket to which build events are streamed as newline-delimited json",
+		},
 		cli.StringFlag{
 			EnvVar: "DRONE_PLUGIN_NAMESPACE",
 			Name:   "namespace",
 			Value:  "plugins",
 			Usage:  "default plugin image namespace",
 		},
+		cli.StringFlag{
+			EnvVar: "DRONE_CLONE_IMAGE",
+			Name:   "clone-image",
+			Usage:  "default clone plugin image",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_AMBASSADOR_IMAGE",
+			Name:   "ambassador-image",
+			Usage:  "ambassador and helper container image",
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_NETWORK_MTU",
+			Name:   "docker-network-mtu",
+			Usage:  "build network mtu, defaults to the docker network mtu",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_DEFAULT_IMAGE",
+			Name:   "default-image",
+			Usage:  "default image for command steps without an image",
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_CLONE_RETRIES",
+			Name:   "clone-retries",
+			Usage:  "number of times to retry a failed clone",
+		},
+		cli.DurationFlag{
+			EnvVar: "DRONE_CLONE_BACKOFF",
+			Name:   "clone-backoff",
+			Usage:  "clone retry backoff interval",
+			Value:  time.Second * 5,
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_BUILD_RETRIES",
+			Name:   "build-retries",
+			Usage:  "number of times to retry the build from scratch after an infrastructure failure",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_RECORD",
+			Name:   "record",
+			Usage:  "record the resolved build payload to a file",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_RECORD_SECRETS",
+			Name:   "record-secrets",
+			Usage:  "record secrets in the build payload",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_REPLAY",
+			Name:   "replay",
+			Usage:  "replay a recorded build payload",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_COLOR",
+			Name:   "color",
+			Usage:  "colorize the replayed build summary",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_NO_COLOR",
+			Name:   "no-color",
+			Usage:  "disable colorized output",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_LOG_TIMESTAMPS",
+			Name:   "log-timestamps",
+			Usage:  "replayed build output timestamps, either relative or rfc3339",
+			Value:  build.TimeRelative,
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_LOG_PREFIX",
+			Name:   "log-prefix",
+			Usage:  "prefix the replayed build output lines with the step name instead of the line metadata",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_LOG_PREFIX_FORMAT",
+			Name:   "log-prefix-format",
+			Usage:  "format of the step name prefix, with %s replaced by the step name",
+			Value:  build.DefaultPrefix,
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_PRINT_YAML",
+			Name:   "print-yaml",
+			Usage:  "print the resolved yaml configuration with secrets redacted",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_PRINT_TREE",
+			Name:   "print-tree",
+			Usage:  "print the transformed configuration of the replayed payload as json, with secrets redacted, and exit",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_LINT",
+			Name:   "lint",
+			Usage:  "lint the yaml file for common mistakes and exit, failing if errors are found",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_EXPLAIN",
+			Name:   "explain",
+			Usage:  "print the changes made to each step of the replayed payload by the yaml transforms and exit",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_LIST_STEPS",
+			Name:   "list-steps",
+			Usage:  "list the name, node type and image of the replayed payload steps and exit",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_IMAGE_LOCK",
+			Name:   "image-lock",
+			Usage:  "lockfile of image digests to which the build images are pinned",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_MATRIX",
+			Name:   "matrix",
+			Usage:  "expand the matrix of the replayed payload and run every combination",
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_MATRIX_PARALLEL",
+			Name:   "matrix-parallel",
+			Usage:  "maximum number of matrix combinations run in parallel",
+			Value:  1,
+		},
+		cli.StringSliceFlag{
+			EnvVar: "DRONE_DISABLE_NODES",
+			Name:   "disable-node",
+			Usage:  "node types disabled for every build, either clone, cache, services or plugins",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_UPDATE_LOCK",
+			Name:   "update-lock",
+			Usage:  "resolve the image digests of the replayed payload, write the image lockfile and exit",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_CONTROL_FILE",
+			Name:   "control-file",
+			Usage:  "cancel the build when the control file is created or contains cancel",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_ONCE",
+			Name:   "once",
+			Usage:  "refuse to run a build already running on this host",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_LOCK_DIR",
+			Name:   "lock-dir",
+			Usage:  "build lock file directory",
+			Value:  os.TempDir(),
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_YAML_URL",
+			Name:   "yaml-url",
+			Usage:  "remote yaml configuration url",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_YAML_CHECKSUM",
+			Name:   "yaml-checksum",
+			Usage:  "remote yaml configuration sha256 checksum",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_PROFILE",
+			Name:   "profile",
+			Usage:  "profile the agent process, either cpu or mem",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_PROFILE_FILE",
+			Name:   "profile-file",
+			Usage:  "profile output file",
+			Value:  "drone-exec.pprof",
+		},
+		cli.StringFlag{
+			EnvVar: "DRONE_ARCHIVE_WORKSPACE",
+			Name:   "archive-workspace",
+			Usage:  "upload the workspace of failed builds to a directory or http url",
+		},
+		cli.IntFlag{
+			EnvVar: "DRONE_ARCHIVE_MAX_SIZE",
+			Name:   "archive-max-size",
+			Usage:  "maximum workspace archive size in megabytes",
+			Value:  100,
+		},
+		cli.StringSliceFlag{
+			EnvVar: "DRONE_ARCHIVE_EXCLUDE",
+			Name:   "archive-exclude",
+			Usage:  "workspace file patterns excluded from the archive",
+		},
+		cli.BoolFlag{
+			EnvVar: "DRONE_INSECURE_SKIP_SHASUM",
+			Name:   "insecure-skip-shasum",
+			Usage:  "DANGEROUS: load secrets for builds that fail yaml verification, for local testing only",
+		},
 		cli.BoolTFlag{
 			EnvVar: "DRONE_PLUGIN_PULL",
 			Name:   "pull",
@@ -140,12 +459,30 @@ func main() {
 func start(c *cli.Context) error {
 
 	// debug level if requested by user
-	if c.Bool("debug") {
+	if c.Bool("debug") || c.Bool("trace") {
 		logrus.SetLevel(logrus.DebugLevel)
 	} else {
 		logrus.SetLevel(logrus.WarnLevel)
 	}
 
+	// profile the agent process until it returns or is interrupted, since
+	// the agent otherwise runs until it is killed.
+	if mode := c.String("profile"); mode != "" {
+		p, err := profile.Start(mode, c.String("profile-file"))
+		if err != nil {
+			return err
+		}
+		defer p.Stop()
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			p.Stop()
+			os.Exit(1)
+		}()
+	}
+
 	var accessToken string
 	if c.String("drone-secret") != "" {
 		accessToken, _ = token.New(c.String("drone-secret"))
@@ -163,14 +500,157 @@ func start(c *cli.Context) error {
 		accessToken,
 	)
 
-	tls, err := dockerclient.TLSConfigFromCertPath(c.String("docker-cert-path"))
-	if err == nil {
-		tls.InsecureSkipVerify = c.Bool("docker-tls-verify")
+	conf := config{
+		platform:   c.String("docker-os") + "/" + c.String("docker-arch"),
+		timeout:    c.Duration("timeout"),
+		namespace:  c.String("namespace"),
+		clone:      c.String("clone-image"),
+		ambassador: c.String("ambassador-image"),
+		image:      c.String("default-image"),
+		retries:    c.Int("clone-retries"),
+		backoff:    c.Duration("clone-backoff"),
+		rebuilds:   c.Int("build-retries"),
+		yaml:       c.String("yaml-url"),
+		checksum:   c.String("yaml-checksum"),
+		once:       c.Bool("once"),
+		locks:      c.String("lock-dir"),
+		control:    c.String("control-file"),
+		record:     c.String("record"),
+		secrets:    c.Bool("record-secrets"),
+		print:      c.Bool("print-yaml"),
+		color:      color(c),
+		privileged: c.StringSlice("privileged"),
+		pull:       c.BoolT("pull"),
+		logs:       int64(c.Int("max-log-size")) * 1000000,
+		lines:      c.Int("max-line-size") * 1024,
+		rate:       c.Int("max-line-rate"),
+		maxLines:   c.Int("max-log-lines"),
+		preserve:   c.StringSlice("preserve-on-failure"),
+		mtu:        c.Int("docker-network-mtu"),
+		timestamps: c.String("log-timestamps"),
+		detached:   c.String("detached-logs"),
+		events:     c.String("event-socket"),
+		prune:      c.Bool("prune-images"),
+		keep:       c.StringSlice("prune-images-keep"),
+		stop:       c.Bool("stop-detached"),
+		strict:     c.Bool("strict-images"),
+		stats:      c.Bool("step-stats"),
+		nodes:      c.StringSlice("disable-node"),
+		matrix:     c.Bool("matrix"),
+		parallel:   c.Int("matrix-parallel"),
+		insecure:   c.Bool("insecure-skip-shasum"),
+
+		archive:        c.String("archive-workspace"),
+		archiveSize:    int64(c.Int("archive-max-size")) * 1000000,
+		archiveExclude: c.StringSlice("archive-exclude"),
+	}
+
+	if conf.insecure {
+		logrus.Errorf("INSECURE: yaml verification is disabled and secrets are " +
+			"loaded for unverified builds. Never use --insecure-skip-shasum in production")
+	}
+
+	switch conf.timestamps {
+	case build.TimeRelative, build.TimeRFC3339:
+	default:
+		return fmt.Errorf("Invalid log timestamp format %s", conf.timestamps)
 	}
-	docker, err := dockerclient.NewDockerClient(c.String("docker-host"), tls)
+
+	for _, node := range conf.preserve {
+		switch node {
+		case yaml.NodeServices, yaml.NodeBuild:
+		default:
+			return fmt.Errorf("Invalid preserve on failure node %s, expected services or build", node)
+		}
+	}
+
+	if c.Bool("log-prefix") {
+		conf.prefix = c.String("log-prefix-format")
+		if strings.Count(conf.prefix, "%") != 1 || !strings.Contains(conf.prefix, "%s") {
+			return fmt.Errorf("Invalid log prefix format %s, expected a single %%s", conf.prefix)
+		}
+	}
+
+	// pin the build images to the digests of the image lockfile, unless the
+	// lockfile is updated.
+	if path := c.String("image-lock"); path != "" && !c.Bool("update-lock") {
+		digests, err := lockfile.Load(path)
+		if err != nil {
+			return err
+		}
+		conf.digests = digests
+	}
+
+	// print the transformed configuration of the recorded build payload
+	// without connecting to the docker daemon or the server.
+	if c.Bool("print-tree") {
+		path := c.String("replay")
+		if path == "" {
+			return fmt.Errorf("Cannot print tree without a replay payload")
+		}
+		r := pipeline{config: conf}
+		return r.tree(path)
+	}
+	if path := c.String("lint"); path != "" {
+		return lint(path)
+	}
+	if c.Bool("explain") {
+		path := c.String("replay")
+		if path == "" {
+			return fmt.Errorf("Cannot explain the transforms without a replay payload")
+		}
+		r := pipeline{config: conf}
+		return r.explain(path)
+	}
+	if c.Bool("list-steps") {
+		path := c.String("replay")
+		if path == "" {
+			return fmt.Errorf("Cannot list steps without a replay payload")
+		}
+		r := pipeline{config: conf}
+		return r.list(path)
+	}
+
+	engine, err := newEngine(c)
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if c.Bool("trace") {
+		engine = build.Trace(engine, logrus.Debugf)
+	}
+
+	// resolve the image digests of the recorded build payload and write the
+	// image lockfile without connecting to the server.
+	if c.Bool("update-lock") {
+		path := c.String("replay")
+		if path == "" || c.String("image-lock") == "" {
+			return fmt.Errorf("Cannot update the image lockfile without a replay payload and an image lockfile")
+		}
+		r := pipeline{engine: engine, config: conf}
+		return r.lock(path, c.String("image-lock"))
+	}
+
+	// build events are streamed to the event socket, or to stdout if the
+	// socket is unavailable.
+	var events *event.Writer
+	if conf.events != "" {
+		events, err = event.Dial(conf.events)
+		if err != nil {
+			logrus.Warnf("Cannot connect to the event socket %s, writing events to stdout. %s", conf.events, err)
+			events = event.NewWriter(os.Stdout)
+		}
+		defer events.Close()
+	}
+
+	// replay the recorded build payload without connecting to the server.
+	if path := c.String("replay"); path != "" {
+		r := pipeline{
+			engine: engine,
+			config: conf,
+			events: events,
+		}
+		return r.replay(path)
+	}
 
 	go func() {
 		for {
@@ -181,21 +661,24 @@ func start(c *cli.Context) error {
 		}
 	}()
 
+	// metrics are opt-in and pushed to the pushgateway after every build,
+	// grouped by the agent hostname.
+	var pusher *metrics.Pusher
+	if addr := c.String("metrics-pushgateway"); addr != "" {
+		hostname, _ := os.Hostname()
+		pusher = metrics.New(addr, "drone_agent", hostname)
+	}
+
 	var wg sync.WaitGroup
 	for i := 0; i < c.Int("docker-max-procs"); i++ {
 		wg.Add(1)
 		go func() {
 			r := pipeline{
-				drone:  client,
-				docker: docker,
-				config: config{
-					platform:   c.String("docker-os") + "/" + c.String("docker-arch"),
-					timeout:    c.Duration("timeout"),
-					namespace:  c.String("namespace"),
-					privileged: c.StringSlice("privileged"),
-					pull:       c.BoolT("pull"),
-					logs:       int64(c.Int("max-log-size")) * 1000000,
-				},
+				drone:   client,
+				engine:  engine,
+				metrics: pusher,
+				config:  conf,
+				events:  events,
 			}
 			for {
 				if err := r.run(); err != nil {
@@ -209,3 +692,59 @@ func start(c *cli.Context) error {
 	wg.Wait()
 	return nil
 }
+
+// color returns true if the output is colorized, which is the default when
+// stdout is a terminal, unless disabled with the no-color flag.
+func color(c *cli.Context) bool {
+	if c.Bool("no-color") {
+		return false
+	}
+	if c.Bool("color") {
+		return true
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newEngine returns the container engine selected by the engine flag.
+func newEngine(c *cli.Context) (build.Engine, error) {
+	switch c.String("engine") {
+	case "docker":
+		tls, err := dockerclient.TLSConfigFromCertPath(c.String("docker-cert-path"))
+		if err == nil {
+			tls.InsecureSkipVerify = c.Bool("docker-tls-verify")
+		}
+		host := docker.Host(
+			c.String("docker-host"),
+			os.Getenv("DOCKER_HOST"),
+			os.Getenv("XDG_RUNTIME_DIR"),
+		)
+		client, err := dockerclient.NewDockerClient(host, tls)
+		if err != nil {
+			return nil, err
+		}
+		if err := docker.Preflight(client, host); err != nil {
+			return nil, err
+		}
+		opts := []docker.Option{
+			docker.WithWaitWatchdog(c.Duration("docker-wait-watchdog")),
+			docker.WithPullRetries(c.Int("docker-pull-retries"), c.Duration("docker-pull-backoff")),
+			docker.WithMaxImageSize(int64(c.Int("docker-max-image-size")) * 1000000),
+			docker.WithHelperImage(c.String("ambassador-image")),
+		}
+		if name := c.String("docker-credential-helper"); name != "" {
+			helper := docker.NewCredentialHelper(name, c.Duration("docker-credential-ttl"))
+			opts = append(opts, docker.WithCredentialHelper(helper))
+		}
+		if mirrors := c.StringSlice("docker-registry-mirror"); len(mirrors) != 0 {
+			opts = append(opts, docker.WithMirrors(mirrors))
+		}
+		return docker.NewClientRetry(client,
+			c.Int("docker-wait-retries"),
+			c.Duration("docker-wait-backoff"),
+			opts...,
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported container engine %q", c.String("engine"))
+	}
+}
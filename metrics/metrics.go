@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-go/drone"
+)
+
+// Pusher pushes build metrics to a Prometheus Pushgateway using the text
+// exposition format.
+type Pusher struct {
+	sync.Mutex
+
+	addr     string
+	job      string
+	instance string
+	client   *http.Client
+
+	// counts tracks the number of completed builds by status for the
+	// lifetime of the agent.
+	counts map[string]int64
+
+	// builds and steps map each build and step duration series, by its
+	// labels, to the duration of the last build. Every push replaces the
+	// metrics of the group, so the series of earlier and concurrent builds
+	// are pushed again with each build.
+	builds map[string]string
+	steps  map[string]string
+}
+
+// New returns a new Pusher that pushes metrics to the Pushgateway at the
+// given address, grouped by job and instance name.
+func New(addr, job, instance string) *Pusher {
+	return &Pusher{
+		addr:     strings.TrimRight(addr, "/"),
+		job:      job,
+		instance: instance,
+		client:   http.DefaultClient,
+		counts:   map[string]int64{},
+		builds:   map[string]string{},
+		steps:    map[string]string{},
+	}
+}
+
+// Push pushes the duration and status metrics for the completed build and
+// its steps to the Pushgateway, together with the metrics of the earlier
+// builds of the agent. The metrics are grouped by job and instance, so the
+// number of groups does not grow with the number of builds.
+func (p *Pusher) Push(w *drone.Payload, results []*build.Result) error {
+	// the lock is held for the push, so a push never replaces the metrics
+	// of a later build with an older snapshot.
+	p.Lock()
+	defer p.Unlock()
+	p.counts[w.Job.Status]++
+	p.record(w, results)
+
+	uri := fmt.Sprintf("%s/metrics/job/%s/instance/%s",
+		p.addr,
+		url.QueryEscape(p.job),
+		url.QueryEscape(p.instance),
+	)
+	req, err := http.NewRequest("PUT", uri, p.encode())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > http.StatusAccepted {
+		return fmt.Errorf("pushgateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// record records the build and step durations of the completed build. The
+// caller must hold the lock.
+func (p *Pusher) record(w *drone.Payload, results []*build.Result) {
+	labels := fmt.Sprintf("repo=\"%s\",status=\"%s\"",
+		escape(w.Repo.FullName),
+		escape(w.Job.Status),
+	)
+	p.builds[labels] = fmt.Sprint(w.Job.Finished - w.Job.Started)
+
+	for _, result := range results {
+		if result.Skipped {
+			continue
+		}
+		status := drone.StatusSuccess
+		if result.Err != nil {
+			status = drone.StatusFailure
+		}
+		labels := fmt.Sprintf("repo=\"%s\",step=\"%s\",status=\"%s\"",
+			escape(w.Repo.FullName),
+			escape(result.Name),
+			escape(status),
+		)
+		p.steps[labels] = fmt.Sprint(result.Duration().Seconds())
+	}
+}
+
+// encode encodes the build metrics in the text exposition format. The caller
+// must hold the lock.
+func (p *Pusher) encode() *bytes.Buffer {
+	var buf bytes.Buffer
+
+	buf.WriteString("# TYPE drone_build_duration_seconds gauge\n")
+	for _, labels := range sortedKeys(p.builds) {
+		fmt.Fprintf(&buf, "drone_build_duration_seconds{%s} %s\n", labels, p.builds[labels])
+	}
+
+	buf.WriteString("# TYPE drone_step_duration_seconds gauge\n")
+	for _, labels := range sortedKeys(p.steps) {
+		fmt.Fprintf(&buf, "drone_step_duration_seconds{%s} %s\n", labels, p.steps[labels])
+	}
+
+	buf.WriteString("# TYPE drone_builds_total counter\n")
+	for _, status := range []string{
+		drone.StatusSuccess,
+		drone.StatusFailure,
+		drone.StatusKilled,
+		drone.StatusError,
+	} {
+		fmt.Fprintf(&buf, "drone_builds_total{status=\"%s\"} %d\n", escape(status), p.counts[status])
+	}
+	return &buf
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escape escapes backslashes, quotes and newlines in the label value.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-exec/build"
+	"github.com/drone/drone-go/drone"
+	"github.com/franela/goblin"
+)
+
+func TestPusher(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Metrics pusher", func() {
+
+		g.It("should push build and step metrics", func() {
+			var method, path, body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				out, _ := ioutil.ReadAll(r.Body)
+				method, path, body = r.Method, r.URL.Path, string(out)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			now := time.Now()
+			results := []*build.Result{
+				{Name: "clone", Started: now, Finished: now.Add(time.Second)},
+				{Name: "test", Started: now, Finished: now.Add(time.Second * 2), Err: errors.New("exit code 1")},
+				{Name: "deploy", Skipped: true},
+			}
+
+			pusher := New(server.URL, "drone_agent", "agent01")
+			err := pusher.Push(samplePayload(drone.StatusFailure), results)
+			g.Assert(err == nil).IsTrue("expects push to succeed")
+			g.Assert(method).Equal("PUT")
+			g.Assert(path).Equal("/metrics/job/drone_agent/instance/agent01")
+
+			want := []string{
+				`drone_build_duration_seconds{repo="octocat/hello-world",status="failure"} 30`,
+				`drone_step_duration_seconds{repo="octocat/hello-world",step="clone",status="success"} 1`,
+				`drone_step_duration_seconds{repo="octocat/hello-world",step="test",status="failure"} 2`,
+				`drone_builds_total{status="success"} 0`,
+				`drone_builds_total{status="failure"} 1`,
+			}
+			for _, line := range want {
+				g.Assert(strings.Contains(body, line)).IsTrue(line)
+			}
+			g.Assert(strings.Contains(body, `step="deploy"`)).IsFalse()
+		})
+
+		g.It("should push the metrics of earlier builds", func() {
+			var paths []string
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				out, _ := ioutil.ReadAll(r.Body)
+				paths = append(paths, r.URL.Path)
+				body = string(out)
+			}))
+			defer server.Close()
+
+			other := samplePayload(drone.StatusSuccess)
+			other.Repo = &drone.Repo{FullName: "octocat/spoon-knife"}
+
+			pusher := New(server.URL, "drone_agent", "agent01")
+			pusher.Push(samplePayload(drone.StatusFailure), nil)
+			pusher.Push(other, nil)
+			g.Assert(paths).Equal([]string{
+				"/metrics/job/drone_agent/instance/agent01",
+				"/metrics/job/drone_agent/instance/agent01",
+			})
+			g.Assert(strings.Contains(body, `drone_build_duration_seconds{repo="octocat/hello-world",status="failure"} 30`)).IsTrue()
+			g.Assert(strings.Contains(body, `drone_build_duration_seconds{repo="octocat/spoon-knife",status="success"} 30`)).IsTrue()
+		})
+
+		g.It("should accumulate build counters", func() {
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				out, _ := ioutil.ReadAll(r.Body)
+				body = string(out)
+			}))
+			defer server.Close()
+
+			pusher := New(server.URL, "drone_agent", "agent01")
+			pusher.Push(samplePayload(drone.StatusSuccess), nil)
+			pusher.Push(samplePayload(drone.StatusSuccess), nil)
+			pusher.Push(samplePayload(drone.StatusFailure), nil)
+			g.Assert(strings.Contains(body, `drone_builds_total{status="success"} 2`)).IsTrue()
+			g.Assert(strings.Contains(body, `drone_builds_total{status="failure"} 1`)).IsTrue()
+		})
+
+		g.It("should return an error when the push fails", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer server.Close()
+
+			pusher := New(server.URL, "drone_agent", "agent01")
+			err := pusher.Push(samplePayload(drone.StatusSuccess), nil)
+			g.Assert(err != nil).IsTrue("expects push to fail")
+		})
+
+		g.It("should escape label values", func() {
+			g.Assert(escape(`a"b\c`)).Equal(`a\"b\\c`)
+		})
+	})
+}
+
+func samplePayload(status string) *drone.Payload {
+	return &drone.Payload{
+		Repo: &drone.Repo{FullName: "octocat/hello-world"},
+		Job: &drone.Job{
+			Status:   status,
+			Started:  1000,
+			Finished: 1030,
+		},
+	}
+}
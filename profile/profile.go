@@ -0,0 +1,50 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Profile is a pprof profile of the running process written to a file.
+type Profile struct {
+	mode string
+	file *os.File
+}
+
+// Start starts profiling the running process and writes the profile to the
+// file at the given path. The mode is either cpu or mem. The cpu profile is
+// collected until the profile is stopped, and the heap profile is written
+// when the profile is stopped.
+func Start(mode, path string) (*Profile, error) {
+	if mode != "cpu" && mode != "mem" {
+		return nil, fmt.Errorf("Invalid profile mode %s", mode)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "cpu" {
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &Profile{mode, file}, nil
+}
+
+// Stop stops profiling and closes the profile file.
+func (p *Profile) Stop() error {
+	if p.mode == "cpu" {
+		pprof.StopCPUProfile()
+	} else {
+		// collect garbage so the heap profile reflects live objects.
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(p.file); err != nil {
+			p.file.Close()
+			return err
+		}
+	}
+	return p.file.Close()
+}
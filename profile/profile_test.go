@@ -0,0 +1,52 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestProfile(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Profile", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_profile_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		for _, mode := range []string{"cpu", "mem"} {
+			mode := mode
+			g.It("should write a "+mode+" profile", func() {
+				path := filepath.Join(dir, mode+".pprof")
+				p, err := Start(mode, path)
+				g.Assert(err == nil).IsTrue()
+
+				// allocate and burn some cycles so the profile has samples.
+				var buf [][]byte
+				for i := 0; i < 1000; i++ {
+					buf = append(buf, make([]byte, 1024))
+				}
+				_ = buf
+
+				g.Assert(p.Stop() == nil).IsTrue()
+				info, err := os.Stat(path)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(info.Size() > 0).IsTrue()
+			})
+		}
+
+		g.It("should error when the mode is unknown", func() {
+			_, err := Start("block", filepath.Join(dir, "block.pprof"))
+			g.Assert(err != nil).IsTrue()
+			g.Assert(err.Error()).Equal("Invalid profile mode block")
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-go/drone"
+)
+
+// redacted replaces sensitive values in the recorded payload.
+const redacted = "[redacted]"
+
+// Save writes the payload to the file at path so that the build can be
+// replayed. Secret values and netrc credentials are redacted unless secrets
+// is true.
+func Save(path string, w *drone.Payload, secrets bool) error {
+	if !secrets {
+		w = redact(w)
+	}
+	out, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0600)
+}
+
+// Load reads the recorded payload from the file at path.
+func Load(path string) (*drone.Payload, error) {
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w := new(drone.Payload)
+	err = json.Unmarshal(out, w)
+	return w, err
+}
+
+// Yaml returns the resolved Yaml configuration of the payload, with secret
+// values and netrc credentials redacted.
+func Yaml(w *drone.Payload) string {
+	return redact(w).Yaml
+}
+
+// Tree returns the transformed configuration of the payload as indented JSON,
+// with the netrc credentials in the step environment and any occurrence of the
+// secret values redacted.
+func Tree(w *drone.Payload, conf *yaml.Config) ([]byte, error) {
+	out := *conf
+	out.Pipeline = redactEnviron(conf.Pipeline)
+	out.Services = redactEnviron(conf.Services)
+	data, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values(w) {
+		quoted, _ := json.Marshal(value)
+		data = bytes.Replace(data, quoted[1:len(quoted)-1], []byte(redacted), -1)
+	}
+	return data, nil
+}
+
+// netrcEnviron are the environment variables of the netrc credentials, which
+// are injected into the clone step.
+var netrcEnviron = []string{"DRONE_NETRC_USERNAME", "DRONE_NETRC_PASSWORD"}
+
+// redactEnviron returns copies of the containers with the netrc credentials
+// in the environment replaced.
+func redactEnviron(containers []*yaml.Container) []*yaml.Container {
+	var out []*yaml.Container
+	for _, container := range containers {
+		c := *container
+		c.Environment = map[string]string{}
+		for k, v := range container.Environment {
+			c.Environment[k] = v
+		}
+		for _, k := range netrcEnviron {
+			if _, ok := c.Environment[k]; ok {
+				c.Environment[k] = redacted
+			}
+		}
+		out = append(out, &c)
+	}
+	return out
+}
+
+// values returns the secret values and the netrc password of the payload.
+func values(w *drone.Payload) []string {
+	var values []string
+	for _, secret := range w.Secrets {
+		if secret.Value != "" {
+			values = append(values, secret.Value)
+		}
+	}
+	if w.Netrc != nil && w.Netrc.Password != "" {
+		values = append(values, w.Netrc.Password)
+	}
+	return values
+}
+
+// redact returns a copy of the payload with secret values, netrc credentials
+// and any occurrence of the secret values in the Yaml replaced.
+func redact(w *drone.Payload) *drone.Payload {
+	out := *w
+	out.Secrets = nil
+	for _, secret := range w.Secrets {
+		s := *secret
+		s.Value = redacted
+		out.Secrets = append(out.Secrets, &s)
+	}
+	if w.Netrc != nil {
+		netrc := *w.Netrc
+		netrc.Login = redacted
+		netrc.Password = redacted
+		out.Netrc = &netrc
+	}
+	for _, value := range values(w) {
+		out.Yaml = strings.Replace(out.Yaml, value, redacted, -1)
+	}
+	return &out
+}
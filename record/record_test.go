@@ -0,0 +1,144 @@
+package record
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-exec/yaml/expander"
+	"github.com/drone/drone-go/drone"
+	"github.com/franela/goblin"
+)
+
+func TestRecord(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Record", func() {
+
+		var dir string
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "drone_record_")
+		})
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should replay the same parse tree", func() {
+			path := filepath.Join(dir, "payload.json")
+			err := Save(path, samplePayload(), false)
+			g.Assert(err == nil).IsTrue("expects payload recorded")
+
+			w, err := Load(path)
+			g.Assert(err == nil).IsTrue("expects payload loaded")
+			g.Assert(w.Repo.FullName).Equal("octocat/hello-world")
+			g.Assert(w.Build.Number).Equal(42)
+
+			want, _ := yaml.ParseString(samplePayload().Yaml)
+			got, err := yaml.ParseString(w.Yaml)
+			g.Assert(err == nil).IsTrue("expects recorded yaml to parse")
+			g.Assert(reflect.DeepEqual(got, want)).IsTrue("expects the same parse tree")
+		})
+
+		g.It("should redact secrets", func() {
+			path := filepath.Join(dir, "redacted.json")
+			Save(path, samplePayload(), false)
+
+			w, _ := Load(path)
+			g.Assert(w.Secrets[0].Name).Equal("DOCKER_PASSWORD")
+			g.Assert(w.Secrets[0].Value).Equal(redacted)
+			g.Assert(w.Netrc.Machine).Equal("github.com")
+			g.Assert(w.Netrc.Login).Equal(redacted)
+			g.Assert(w.Netrc.Password).Equal(redacted)
+		})
+
+		g.It("should redact secrets in the yaml", func() {
+			in := samplePayload()
+			in.Yaml = in.Yaml + "\n    password: correct-horse-battery-staple\n"
+
+			path := filepath.Join(dir, "redacted_yaml.json")
+			Save(path, in, false)
+
+			w, _ := Load(path)
+			g.Assert(w.Yaml).Equal(samplePayload().Yaml + "\n    password: " + redacted + "\n")
+		})
+
+		g.It("should not modify the recorded payload", func() {
+			in := samplePayload()
+			Save(filepath.Join(dir, "unmodified.json"), in, false)
+			g.Assert(in.Secrets[0].Value).Equal("correct-horse-battery-staple")
+			g.Assert(in.Netrc.Password).Equal("x-oauth-basic")
+		})
+
+		g.It("should print the resolved yaml with secrets redacted", func() {
+			in := samplePayload()
+			in.Yaml = expander.ExpandString(in.Yaml+"\n    branch: ${DRONE_BRANCH}\n    password: ${DOCKER_PASSWORD}\n", map[string]string{
+				"DRONE_BRANCH":    "master",
+				"DOCKER_PASSWORD": "correct-horse-battery-staple",
+			})
+			g.Assert(Yaml(in)).Equal(samplePayload().Yaml + "\n    branch: master\n    password: " + redacted + "\n")
+		})
+
+		g.It("should print the transformed configuration with secrets redacted", func() {
+			in := samplePayload()
+			in.Netrc.Login = "octocat"
+			conf := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{
+						Name: "clone",
+						Environment: map[string]string{
+							"DRONE_NETRC_MACHINE":  "github.com",
+							"DRONE_NETRC_USERNAME": "octocat",
+							"DRONE_NETRC_PASSWORD": "x-oauth-basic",
+						},
+					},
+					{
+						Name:        "publish",
+						Environment: map[string]string{"DOCKER_PASSWORD": "correct-horse-battery-staple"},
+						Commands:    []string{"echo correct-horse-battery-staple"},
+					},
+				},
+			}
+			out, err := Tree(in, conf)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(strings.Contains(string(out), "correct-horse-battery-staple")).IsFalse()
+			g.Assert(strings.Contains(string(out), "x-oauth-basic")).IsFalse()
+			g.Assert(strings.Contains(string(out), `"DRONE_NETRC_USERNAME": "[redacted]"`)).IsTrue()
+			g.Assert(strings.Contains(string(out), `"DRONE_NETRC_MACHINE": "github.com"`)).IsTrue()
+			g.Assert(conf.Pipeline[0].Environment["DRONE_NETRC_USERNAME"]).Equal("octocat")
+		})
+
+		g.It("should record secrets when enabled", func() {
+			path := filepath.Join(dir, "secrets.json")
+			Save(path, samplePayload(), true)
+
+			w, _ := Load(path)
+			g.Assert(w.Secrets[0].Value).Equal("correct-horse-battery-staple")
+			g.Assert(w.Netrc.Password).Equal("x-oauth-basic")
+		})
+	})
+}
+
+func samplePayload() *drone.Payload {
+	return &drone.Payload{
+		Yaml: `
+pipeline:
+  test:
+    image: golang:1.6
+    commands:
+      - go test
+  publish:
+    image: docker
+    repo: octocat/hello-world`,
+		Repo:  &drone.Repo{Owner: "octocat", Name: "hello-world", FullName: "octocat/hello-world"},
+		Build: &drone.Build{Number: 42, Event: drone.EventPush, Branch: "master"},
+		Job:   &drone.Job{Number: 1},
+		Netrc: &drone.Netrc{Machine: "github.com", Login: "octocat", Password: "x-oauth-basic"},
+		Secrets: []*drone.Secret{
+			{Name: "DOCKER_PASSWORD", Value: "correct-horse-battery-staple"},
+		},
+	}
+}
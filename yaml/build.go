@@ -2,9 +2,9 @@ package yaml
 
 // Build represents Docker image build instructions.
 type Build struct {
-	Context    string
-	Dockerfile string
-	Args       map[string]string
+	Context    string            `json:"context,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
 }
 
 // UnmarshalYAML implements custom Yaml unmarshaling.
@@ -24,3 +24,34 @@ func (b *Build) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	b.Dockerfile = out.Dockerfile
 	return err
 }
+
+// ImageBuild represents instructions to build an image from a Dockerfile in
+// the workspace. The image is tagged with the step image name so that it can
+// be used by subsequent steps, and is removed when the build completes
+// unless Keep is true.
+type ImageBuild struct {
+	Context    string            `json:"context,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
+	Keep       bool              `json:"keep,omitempty"`
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling.
+func (b *ImageBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	err := unmarshal(&b.Context)
+	if err == nil {
+		return nil
+	}
+	out := struct {
+		Context    string
+		Dockerfile string
+		Args       map[string]string
+		Keep       bool
+	}{}
+	err = unmarshal(&out)
+	b.Context = out.Context
+	b.Args = out.Args
+	b.Dockerfile = out.Dockerfile
+	b.Keep = out.Keep
+	return err
+}
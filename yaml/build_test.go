@@ -36,3 +36,36 @@ func TestBuild(t *testing.T) {
 		})
 	})
 }
+
+func TestImageBuild(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("ImageBuild", func() {
+		g.Describe("given a yaml file", func() {
+
+			g.It("should unmarshal", func() {
+				in := []byte("docker")
+				out := ImageBuild{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Context).Equal("docker")
+				g.Assert(out.Keep).IsFalse()
+			})
+
+			g.It("should unmarshal shorthand", func() {
+				in := []byte("{ context: docker, dockerfile: Dockerfile.build, args: { GO_VERSION: 1.6 }, keep: true }")
+				out := ImageBuild{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Context).Equal("docker")
+				g.Assert(out.Dockerfile).Equal("Dockerfile.build")
+				g.Assert(out.Args).Equal(map[string]string{"GO_VERSION": "1.6"})
+				g.Assert(out.Keep).IsTrue()
+			})
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package yaml
+
+import "github.com/drone/drone-exec/yaml/types"
+
+// Cache represents the build cache configuration.
+type Cache struct {
+	Mount    []string `json:"mount,omitempty"`
+	Fallback string   `json:"fallback,omitempty"`
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling.
+func (c *Cache) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mount types.StringOrSlice
+	err := unmarshal(&mount)
+	if err == nil {
+		c.Mount = mount.Slice()
+		return nil
+	}
+	out := struct {
+		Mount    types.StringOrSlice
+		Fallback string
+	}{}
+	err = unmarshal(&out)
+	c.Mount = out.Mount.Slice()
+	c.Fallback = out.Fallback
+	return err
+}
@@ -0,0 +1,39 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCache(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Cache", func() {
+		g.Describe("given a yaml file", func() {
+
+			g.It("should unmarshal", func() {
+				in := []byte("{ mount: [ node_modules ], fallback: develop }")
+				out := Cache{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Mount).Equal([]string{"node_modules"})
+				g.Assert(out.Fallback).Equal("develop")
+			})
+
+			g.It("should unmarshal shorthand", func() {
+				in := []byte("[ node_modules, .cache ]")
+				out := Cache{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Mount).Equal([]string{"node_modules", ".cache"})
+				g.Assert(out.Fallback).Equal("")
+			})
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package yaml
+
+import "fmt"
+
+// Clone represents the clone configuration of the build.
+type Clone struct {
+	Disable bool `json:"disable,omitempty"`
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling. The shorthand string
+// disable disables the clone step.
+func (c *Clone) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		if s != "disable" {
+			return fmt.Errorf("Invalid clone setting %s", s)
+		}
+		c.Disable = true
+		return nil
+	}
+	out := struct {
+		Disable bool
+	}{}
+	err := unmarshal(&out)
+	c.Disable = out.Disable
+	return err
+}
@@ -0,0 +1,42 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"gopkg.in/yaml.v2"
+)
+
+func TestClone(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Clone", func() {
+		g.Describe("given a yaml file", func() {
+
+			g.It("should unmarshal", func() {
+				out := Clone{}
+				err := yaml.Unmarshal([]byte("{ disable: true }"), &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Disable).IsTrue()
+			})
+
+			g.It("should unmarshal shorthand", func() {
+				out := Clone{}
+				err := yaml.Unmarshal([]byte("disable"), &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.Disable).IsTrue()
+			})
+
+			g.It("should error on an invalid shorthand", func() {
+				out := Clone{}
+				err := yaml.Unmarshal([]byte("enable"), &out)
+				g.Assert(err != nil).IsTrue("expects invalid clone setting")
+				g.Assert(err.Error()).Equal("Invalid clone setting enable")
+			})
+		})
+	})
+}
@@ -1,22 +1,37 @@
 package yaml
 
-import "gopkg.in/yaml.v2"
+import (
+	"fmt"
+
+	"github.com/drone/drone-exec/yaml/types"
+	"gopkg.in/yaml.v2"
+)
 
 // Workspace represents the build workspace.
 type Workspace struct {
-	Base string
-	Path string
+	Base string `json:"base"`
+	Path string `json:"path"`
 }
 
 // Config represents the build configuration Yaml document.
 type Config struct {
-	Image     string
-	Build     *Build
-	Workspace *Workspace
-	Pipeline  []*Container
-	Services  []*Container
-	Volumes   []*Volume
-	Networks  []*Network
+	Image     string       `json:"image,omitempty"`
+	Build     *Build       `json:"build,omitempty"`
+	Workspace *Workspace   `json:"workspace,omitempty"`
+	Cache     *Cache       `json:"cache,omitempty"`
+	Clone     *Clone       `json:"clone,omitempty"`
+	Pipeline  []*Container `json:"pipeline"`
+	Services  []*Container `json:"services"`
+	Volumes   []*Volume    `json:"volumes,omitempty"`
+	Networks  []*Network   `json:"networks,omitempty"`
+
+	// Disable defines the node types disabled for the build, in addition
+	// to the node types disabled by the agent.
+	Disable []string `json:"disable,omitempty"`
+
+	// Secrets defines additional restrictions of the repository secrets,
+	// by secret name.
+	Secrets []*Secret `json:"secrets,omitempty"`
 }
 
 // ParseString parses the Yaml configuration document.
@@ -30,10 +45,14 @@ func Parse(data []byte) (*Config, error) {
 		Image     string
 		Build     *Build
 		Workspace *Workspace
+		Cache     *Cache
+		Clone     *Clone
 		Services  containerList
 		Pipeline  containerList
 		Networks  networkList
 		Volumes   volumeList
+		Disable   types.StringOrSlice
+		Secrets   secretList
 	}{}
 
 	err := yaml.Unmarshal(data, &v)
@@ -45,17 +64,41 @@ func Parse(data []byte) (*Config, error) {
 		c.Detached = true
 	}
 
+	if err := uniqueNames("services", v.Services.containers); err != nil {
+		return nil, err
+	}
+	if err := uniqueNames("pipeline", v.Pipeline.containers); err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		Image:     v.Image,
 		Build:     v.Build,
 		Workspace: v.Workspace,
+		Cache:     v.Cache,
+		Clone:     v.Clone,
 		Services:  v.Services.containers,
 		Pipeline:  v.Pipeline.containers,
 		Networks:  v.Networks.networks,
 		Volumes:   v.Volumes.volumes,
+		Disable:   v.Disable.Slice(),
+		Secrets:   v.Secrets.secrets,
 	}, nil
 }
 
+// uniqueNames returns an error if two containers of the section share the
+// same name, since steps are selected and referenced by name.
+func uniqueNames(section string, containers []*Container) error {
+	names := map[string]bool{}
+	for _, c := range containers {
+		if names[c.Name] {
+			return fmt.Errorf("Duplicate name %s in the %s section", c.Name, section)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
 type config struct {
 	Image     string
 	Build     *Build
@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/franela/goblin"
@@ -22,6 +23,7 @@ func TestParse(t *testing.T) {
 				g.Assert(out.Workspace.Path).Equal("src/github.com/octocat/hello-world")
 				g.Assert(out.Build.Context).Equal(".")
 				g.Assert(out.Build.Dockerfile).Equal("Dockerfile")
+				g.Assert(out.Cache.Mount).Equal([]string{"node_modules"})
 				g.Assert(out.Volumes[0].Name).Equal("custom")
 				g.Assert(out.Volumes[0].Driver).Equal("blockbridge")
 				g.Assert(out.Networks[0].Name).Equal("custom")
@@ -37,10 +39,72 @@ func TestParse(t *testing.T) {
 				g.Assert(out.Pipeline[2].Name).Equal("notify")
 				g.Assert(out.Pipeline[2].Image).Equal("slack")
 			})
+
+			g.It("Should reject duplicate step names", func() {
+				_, err := ParseString(duplicateYaml)
+				g.Assert(err != nil).IsTrue("expects duplicate name error")
+				g.Assert(err.Error()).Equal("Duplicate name test in the pipeline section")
+			})
+
+			g.It("Should allow the same name in different sections", func() {
+				_, err := ParseString(sectionsYaml)
+				g.Assert(err == nil).IsTrue()
+			})
+
+			g.It("Should unmarshal the disabled node types", func() {
+				out, err := ParseString("disable: [ cache, plugins ]")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(out.Disable).Equal([]string{"cache", "plugins"})
+
+				out, err = ParseString("disable: services")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(out.Disable).Equal([]string{"services"})
+			})
+
+			g.It("Should unmarshal the secret restrictions", func() {
+				out, err := ParseString("secrets: { DEPLOY_KEY: { branch: master } }")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(len(out.Secrets)).Equal(1)
+				g.Assert(out.Secrets[0].Name).Equal("DEPLOY_KEY")
+				g.Assert(out.Secrets[0].Branch.Include).Equal([]string{"master"})
+			})
+
+			g.It("Should encode the tree as json", func() {
+				out, err := ParseString(treeYaml)
+				if err != nil {
+					g.Fail(err)
+				}
+				out.Pipeline[0].ID = "drone_1"
+				out.Pipeline[0].Step = "pipeline_1_test"
+				raw, err := json.MarshalIndent(out, "", "  ")
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(string(raw)).Equal(treeJSON)
+			})
 		})
 	})
 }
 
+var duplicateYaml = `
+pipeline:
+  test:
+    image: golang
+  integration:
+    name: test
+    image: golang
+`
+
+var sectionsYaml = `
+pipeline:
+  redis:
+    image: redis
+    commands: [ redis-cli -h cache ping ]
+services:
+  redis:
+    image: redis
+`
+
 var sampleYaml = `
 image: hello-world
 build:
@@ -51,6 +115,9 @@ workspace:
   path: src/github.com/octocat/hello-world
   base: /go
 
+cache:
+  mount: node_modules
+
 pipeline:
   test:
     image: golang
@@ -81,3 +148,118 @@ volumes:
   custom:
     driver: blockbridge
 `
+
+var treeYaml = `
+workspace:
+  base: /go
+  path: src/github.com/octocat/hello-world
+
+pipeline:
+  test:
+    image: golang:1.6
+    environment:
+      - GOOS=linux
+    commands:
+      - go test
+    when:
+      branch: master
+  publish:
+    image: docker
+    repo: octocat/hello-world
+    tags: [ latest, "1.0" ]
+    build_args:
+      version: "1.0"
+    when:
+      event: [ push, tag ]
+
+services:
+  database:
+    image: mysql
+`
+
+var treeJSON = `{
+  "workspace": {
+    "base": "/go",
+    "path": "src/github.com/octocat/hello-world"
+  },
+  "pipeline": [
+    {
+      "id": "drone_1",
+      "step": "pipeline_1_test",
+      "name": "test",
+      "image": "golang:1.6",
+      "auth_config": {},
+      "environment": {
+        "GOOS": "linux"
+      },
+      "commands": [
+        "go test"
+      ],
+      "when": {
+        "platform": {},
+        "environment": {},
+        "event": {},
+        "branch": {
+          "include": [
+            "master"
+          ]
+        },
+        "status": {},
+        "matrix": {},
+        "repo": {},
+        "target_branch": {},
+        "success_of": {}
+      }
+    },
+    {
+      "name": "publish",
+      "image": "docker",
+      "auth_config": {},
+      "when": {
+        "platform": {},
+        "environment": {},
+        "event": {
+          "include": [
+            "push",
+            "tag"
+          ]
+        },
+        "branch": {},
+        "status": {},
+        "matrix": {},
+        "repo": {},
+        "target_branch": {},
+        "success_of": {}
+      },
+      "vargs": {
+        "build_args": {
+          "version": "1.0"
+        },
+        "repo": "octocat/hello-world",
+        "tags": [
+          "latest",
+          "1.0"
+        ]
+      }
+    }
+  ],
+  "services": [
+    {
+      "name": "database",
+      "image": "mysql",
+      "auth_config": {},
+      "detached": true,
+      "when": {
+        "platform": {},
+        "environment": {},
+        "event": {},
+        "branch": {},
+        "status": {},
+        "matrix": {},
+        "repo": {},
+        "target_branch": {},
+        "success_of": {}
+      }
+    }
+  ]
+}`
@@ -8,12 +8,26 @@ import (
 
 // Constraints define constraints for container execution.
 type Constraints struct {
-	Platform    Constraint
-	Environment Constraint
-	Event       Constraint
-	Branch      Constraint
-	Status      Constraint
-	Matrix      ConstraintMap
+	Platform    Constraint    `json:"platform"`
+	Environment Constraint    `json:"environment"`
+	Event       Constraint    `json:"event"`
+	Branch      Constraint    `json:"branch"`
+	Status      Constraint    `json:"status"`
+	Matrix      ConstraintMap `json:"matrix"`
+
+	// Repo constrains the container to the matching repositories. The
+	// constraint is evaluated when the Yaml is transformed, since the
+	// repository is known before the build starts.
+	Repo Constraint `json:"repo"`
+
+	// TargetBranch constrains the container to pull requests targeting the
+	// matching branches. The constraint is evaluated when the Yaml is
+	// transformed, and is ignored for builds that are not pull requests.
+	TargetBranch Constraint `json:"target_branch" yaml:"target_branch"`
+
+	// SuccessOf constrains the container to run only if the named prior
+	// steps succeeded. The constraint is evaluated when the step runs.
+	SuccessOf Constraint `json:"success_of" yaml:"success_of"`
 }
 
 // Match returns true if all constraints match the given input. If a single constraint
@@ -27,10 +41,21 @@ func (c *Constraints) Match(arch, target, event, branch, status string, matrix m
 		c.Matrix.Match(matrix)
 }
 
+// MatchSuccess returns true if every step named by the success_of constraint
+// succeeded. Steps that were skipped or have not run did not succeed.
+func (c *Constraints) MatchSuccess(succeeded map[string]bool) bool {
+	for _, name := range c.SuccessOf.Include {
+		if !succeeded[name] {
+			return false
+		}
+	}
+	return true
+}
+
 // Constraint defines an individual constraint.
 type Constraint struct {
-	Include []string
-	Exclude []string
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // Match returns true if the string matches the include patterns and does not
@@ -91,8 +116,8 @@ func (c *Constraint) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // ConstraintMap defines an individual constraint for key value structures.
 type ConstraintMap struct {
-	Include map[string]string
-	Exclude map[string]string
+	Include map[string]string `json:"include,omitempty"`
+	Exclude map[string]string `json:"exclude,omitempty"`
 }
 
 // Match returns true if the params matches the include key values and does not
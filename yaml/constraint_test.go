@@ -12,6 +12,54 @@ func TestConstraint(t *testing.T) {
 	g := goblin.Goblin(t)
 	g.Describe("Constraint", func() {
 
+		g.It("Should parse repo constraints", func() {
+			out := Constraints{}
+			err := yaml.Unmarshal([]byte("{ repo: [ octocat/*, drone/drone ] }"), &out)
+			if err != nil {
+				g.Fail(err)
+			}
+			g.Assert(out.Repo.Include).Equal([]string{"octocat/*", "drone/drone"})
+		})
+
+		g.It("Should parse target_branch constraints", func() {
+			out := Constraints{}
+			err := yaml.Unmarshal([]byte("{ target_branch: master }"), &out)
+			if err != nil {
+				g.Fail(err)
+			}
+			g.Assert(out.TargetBranch.Include).Equal([]string{"master"})
+		})
+
+		g.It("Should parse success_of constraints", func() {
+			out := Constraints{}
+			err := yaml.Unmarshal([]byte("{ success_of: unit }"), &out)
+			if err != nil {
+				g.Fail(err)
+			}
+			g.Assert(out.SuccessOf.Include).Equal([]string{"unit"})
+		})
+
+		g.It("Should match success_of constraints", func() {
+			c := Constraints{}
+			c.SuccessOf.Include = []string{"unit", "lint"}
+			g.Assert(c.MatchSuccess(map[string]bool{"unit": true, "lint": true})).IsTrue()
+			g.Assert(c.MatchSuccess(map[string]bool{"unit": true})).IsFalse()
+			g.Assert(c.MatchSuccess(map[string]bool{})).IsFalse()
+
+			empty := Constraints{}
+			g.Assert(empty.MatchSuccess(map[string]bool{})).IsTrue()
+		})
+
+		g.It("Should match cron event constraints", func() {
+			out := Constraints{}
+			err := yaml.Unmarshal([]byte("{ event: cron }"), &out)
+			if err != nil {
+				g.Fail(err)
+			}
+			g.Assert(out.Match("linux/amd64", "", "cron", "master", "success", nil)).IsTrue()
+			g.Assert(out.Match("linux/amd64", "", "push", "master", "success", nil)).IsFalse()
+		})
+
 		g.It("Should parse and match emtpy", func() {
 			c := parseConstraint("")
 			g.Assert(c.Match("master")).IsTrue()
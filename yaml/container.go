@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/drone/drone-exec/yaml/types"
@@ -9,43 +10,92 @@ import (
 
 // Auth defines Docker authentication credentials.
 type Auth struct {
-	Username string
-	Password string
-	Email    string
+	Username string `json:"username,omitempty"`
+	Password string `json:"-"`
+	Email    string `json:"email,omitempty"`
 }
 
 // Container defines a Docker container.
 type Container struct {
-	ID             string
-	Name           string
-	Image          string
-	Build          string
-	Pull           bool
-	AuthConfig     Auth
-	Detached       bool
-	Disabled       bool
-	Privileged     bool
-	WorkingDir     string
-	Environment    map[string]string
-	Entrypoint     []string
-	Command        []string
-	Commands       []string
-	ExtraHosts     []string
-	Volumes        []string
-	VolumesFrom    []string
-	Devices        []string
-	Network        string
-	DNS            []string
-	DNSSearch      []string
-	MemSwapLimit   int64
-	MemLimit       int64
-	CPUQuota       int64
-	CPUShares      int64
-	CPUSet         string
-	OomKillDisable bool
-	Constraints    Constraints
-
-	Vargs map[string]interface{}
+	ID             string            `json:"id,omitempty"`
+	Step           string            `json:"step,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Image          string            `json:"image,omitempty"`
+	Build          string            `json:"build,omitempty"`
+	ImageBuild     *ImageBuild       `json:"image_build,omitempty"`
+	Pull           bool              `json:"pull,omitempty"`
+	AuthConfig     Auth              `json:"auth_config"`
+	Detached       bool              `json:"detached,omitempty"`
+	Disabled       bool              `json:"disabled,omitempty"`
+	Privileged     bool              `json:"privileged,omitempty"`
+	DockerSocket   bool              `json:"docker_socket,omitempty"`
+	User           string            `json:"user,omitempty"`
+	Platform       string            `json:"platform,omitempty"`
+	Init           bool              `json:"init,omitempty"`
+	WorkingDir     string            `json:"working_dir,omitempty"`
+	Environment    map[string]string `json:"environment,omitempty"`
+	EnvFile        string            `json:"env_file,omitempty"`
+	Entrypoint     []string          `json:"entrypoint,omitempty"`
+	Command        []string          `json:"command,omitempty"`
+	Commands       []string          `json:"commands,omitempty"`
+	BeforeScript   []string          `json:"before_script,omitempty"`
+	AfterScript    []string          `json:"after_script,omitempty"`
+	OnSuccess      []string          `json:"on_success,omitempty"`
+	OnFailure      []string          `json:"on_failure,omitempty"`
+	ExtraHosts     []string          `json:"extra_hosts,omitempty"`
+	Volumes        []string          `json:"volumes,omitempty"`
+	VolumesFrom    []string          `json:"volumes_from,omitempty"`
+	Devices        []string          `json:"devices,omitempty"`
+	Network        string            `json:"network_mode,omitempty"`
+	Alias          string            `json:"alias,omitempty"`
+	Links          []string          `json:"links,omitempty"`
+	DNS            []string          `json:"dns,omitempty"`
+	DNSSearch      []string          `json:"dns_search,omitempty"`
+	MemSwapLimit   int64             `json:"memswap_limit,omitempty"`
+	MemSwappiness  *int64            `json:"mem_swappiness,omitempty"`
+	MemLimit       int64             `json:"mem_limit,omitempty"`
+	CPUQuota       int64             `json:"cpu_quota,omitempty"`
+	CPUShares      int64             `json:"cpu_shares,omitempty"`
+	CPUSet         string            `json:"cpuset,omitempty"`
+	OomKillDisable bool              `json:"oom_kill_disable,omitempty"`
+	Sysctls        map[string]string `json:"sysctls,omitempty"`
+	SecretFiles    map[string]string `json:"secret_files,omitempty"`
+	Cache          []string          `json:"cache,omitempty"`
+	DependsOn      Dependencies      `json:"depends_on,omitempty"`
+	Constraints    Constraints       `json:"when"`
+
+	// Retries defines the number of times the container is re-run when it
+	// exits with a non-zero exit code. The Reset container, if defined, is
+	// run before each retry.
+	Retries int        `json:"retries,omitempty"`
+	Reset   *Container `json:"reset,omitempty"`
+
+	// EnvironRefs defines the environment variables that reference other
+	// variables, such as ${PATH}, which are expanded when the container is
+	// started against the container and image environment. A $$ escapes a
+	// literal $ in these variables.
+	EnvironRefs map[string]string `json:"environ_refs,omitempty"`
+
+	// Files defines the contents of the secret files, keyed by the file
+	// path, which are written to the container before it is started.
+	Files map[string]string `json:"-"`
+
+	// ExecIn defines the name of the running service container in which
+	// the step commands are executed, instead of starting a container for
+	// the step.
+	ExecIn string `json:"exec_in,omitempty"`
+
+	// CacheVolumes defines the named cache volumes mounted by the container,
+	// which are set by the cache transforms. Whether each cache is restored
+	// or empty is reported when the container is started.
+	CacheVolumes []string `json:"cache_volumes,omitempty"`
+
+	// CacheFallbacks maps the cache volumes mounted by the container to the
+	// cache volumes of the fallback branch, from which an empty cache volume
+	// is restored by the cache restore step.
+	CacheFallbacks map[string]string `json:"cache_fallbacks,omitempty"`
+
+	Vargs map[string]interface{} `json:"vargs,omitempty"`
 }
 
 // container is an intermediate type used for decoding a container in a format
@@ -58,25 +108,43 @@ type container struct {
 	Name           string              `yaml:"name"`
 	Image          string              `yaml:"image"`
 	Build          string              `yaml:"build"`
+	ImageBuild     *ImageBuild         `yaml:"image_build"`
 	Pull           bool                `yaml:"pull"`
 	Privileged     bool                `yaml:"privileged"`
+	DockerSocket   bool                `yaml:"docker_socket"`
+	User           string              `yaml:"user"`
+	Platform       string              `yaml:"platform"`
+	Init           bool                `yaml:"init"`
 	Environment    types.MapEqualSlice `yaml:"environment"`
+	EnvFile        string              `yaml:"env_file"`
 	Entrypoint     types.StringOrSlice `yaml:"entrypoint"`
 	Command        types.StringOrSlice `yaml:"command"`
 	Commands       types.StringOrSlice `yaml:"commands"`
+	BeforeScript   types.StringOrSlice `yaml:"before_script"`
+	AfterScript    types.StringOrSlice `yaml:"after_script"`
+	OnSuccess      types.StringOrSlice `yaml:"on_success"`
+	OnFailure      types.StringOrSlice `yaml:"on_failure"`
 	ExtraHosts     types.StringOrSlice `yaml:"extra_hosts"`
 	Volumes        types.StringOrSlice `yaml:"volumes"`
 	VolumesFrom    types.StringOrSlice `yaml:"volumes_from"`
 	Devices        types.StringOrSlice `yaml:"devices"`
 	Network        string              `yaml:"network_mode"`
+	Alias          string              `yaml:"alias"`
+	Links          types.StringOrSlice `yaml:"links"`
 	DNS            types.StringOrSlice `yaml:"dns"`
 	DNSSearch      types.StringOrSlice `yaml:"dns_search"`
 	MemSwapLimit   int64               `yaml:"memswap_limit"`
+	MemSwappiness  *int64              `yaml:"mem_swappiness"`
 	MemLimit       int64               `yaml:"mem_limit"`
 	CPUQuota       int64               `yaml:"cpu_quota"`
 	CPUShares      int64               `yaml:"cpu_shares"`
 	CPUSet         string              `yaml:"cpuset"`
 	OomKillDisable bool                `yaml:"oom_kill_disable"`
+	Sysctls        types.MapEqualSlice `yaml:"sysctls"`
+	SecretFiles    types.MapEqualSlice `yaml:"secret_files"`
+	Cache          types.StringOrSlice `yaml:"cache"`
+	DependsOn      Dependencies        `yaml:"depends_on"`
+	ExecIn         string              `yaml:"exec_in"`
 
 	AuthConfig struct {
 		Username string `yaml:"username"`
@@ -104,11 +172,24 @@ func (c *containerList) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
+	// the containers are decoded a second time to a map, since merge keys
+	// are not resolved when decoding nested values of a yaml.MapSlice. The
+	// slice is only used to preserve the container order.
+	values := map[interface{}]interface{}{}
+	err = unmarshal(&values)
+	if err != nil {
+		return err
+	}
+
 	for _, s := range slice {
 		cc := container{}
 
-		out, merr := yaml.Marshal(s.Value)
-		if err != nil {
+		value, xerr := extend(values, s.Key, nil)
+		if xerr != nil {
+			return xerr
+		}
+		out, merr := yaml.Marshal(value)
+		if merr != nil {
 			return merr
 		}
 
@@ -119,32 +200,52 @@ func (c *containerList) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if cc.Name == "" {
 			cc.Name = fmt.Sprintf("%v", s.Key)
 		}
-		if cc.Image == "" {
+		// the image defaults to the step name, except for command steps,
+		// which default to the build image.
+		if cc.Image == "" && len(cc.Commands.Slice()) == 0 {
 			cc.Image = fmt.Sprintf("%v", s.Key)
 		}
 		c.containers = append(c.containers, &Container{
 			Name:           cc.Name,
 			Image:          cc.Image,
 			Build:          cc.Build,
+			ImageBuild:     cc.ImageBuild,
 			Pull:           cc.Pull,
 			Privileged:     cc.Privileged,
+			DockerSocket:   cc.DockerSocket,
+			User:           cc.User,
+			Platform:       cc.Platform,
+			Init:           cc.Init,
 			Environment:    cc.Environment.Map(),
+			EnvFile:        cc.EnvFile,
 			Entrypoint:     cc.Entrypoint.Slice(),
 			Command:        cc.Command.Slice(),
 			Commands:       cc.Commands.Slice(),
+			BeforeScript:   cc.BeforeScript.Slice(),
+			AfterScript:    cc.AfterScript.Slice(),
+			OnSuccess:      cc.OnSuccess.Slice(),
+			OnFailure:      cc.OnFailure.Slice(),
 			ExtraHosts:     cc.ExtraHosts.Slice(),
 			Volumes:        cc.Volumes.Slice(),
 			VolumesFrom:    cc.VolumesFrom.Slice(),
 			Devices:        cc.Devices.Slice(),
 			Network:        cc.Network,
+			Alias:          cc.Alias,
+			Links:          cc.Links.Slice(),
 			DNS:            cc.DNS.Slice(),
 			DNSSearch:      cc.DNSSearch.Slice(),
 			MemSwapLimit:   cc.MemSwapLimit,
+			MemSwappiness:  cc.MemSwappiness,
 			MemLimit:       cc.MemLimit,
 			CPUQuota:       cc.CPUQuota,
 			CPUShares:      cc.CPUShares,
 			CPUSet:         cc.CPUSet,
 			OomKillDisable: cc.OomKillDisable,
+			Sysctls:        cc.Sysctls.Map(),
+			SecretFiles:    cc.SecretFiles.Map(),
+			Cache:          cc.Cache.Slice(),
+			DependsOn:      cc.DependsOn,
+			ExecIn:         cc.ExecIn,
 			Vargs:          cc.Vargs,
 			AuthConfig: Auth{
 				Username: cc.AuthConfig.Username,
@@ -156,3 +257,96 @@ func (c *containerList) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	return err
 }
+
+// extend returns the container values with the values inherited from the
+// base container defined by the extends keyword. The base container is the
+// name of another container in the list, or a mapping, usually a reference
+// to an anchor. Values defined by the container take precedence over the
+// inherited values.
+func extend(values map[interface{}]interface{}, key interface{}, seen []interface{}) (interface{}, error) {
+	value, ok := values[key].(map[interface{}]interface{})
+	if !ok {
+		return values[key], nil
+	}
+	base, ok := value["extends"]
+	if !ok {
+		return value, nil
+	}
+
+	var inherited map[interface{}]interface{}
+	switch v := base.(type) {
+	case map[interface{}]interface{}:
+		inherited = v
+	case string:
+		for _, s := range seen {
+			if s == v {
+				return nil, fmt.Errorf("Cannot extend %v, circular reference to %s", key, v)
+			}
+		}
+		if _, ok := values[v]; !ok {
+			return nil, fmt.Errorf("Cannot extend %v, %s is not defined", key, v)
+		}
+		out, err := extend(values, v, append(seen, key))
+		if err != nil {
+			return nil, err
+		}
+		inherited, _ = out.(map[interface{}]interface{})
+	default:
+		return nil, fmt.Errorf("Cannot extend %v, extends must be a name or mapping", key)
+	}
+
+	merged := map[interface{}]interface{}{}
+	for k, v := range inherited {
+		if k == "name" {
+			continue
+		}
+		merged[k] = v
+	}
+	for k, v := range value {
+		merged[k] = v
+	}
+	delete(merged, "extends")
+	return merged, nil
+}
+
+// MarshalJSON implements custom JSON marshaling. The plugin arguments decoded
+// from the Yaml are converted to types supported by the JSON encoding.
+func (c *Container) MarshalJSON() ([]byte, error) {
+	type plain Container
+	out := plain(*c)
+	if c.Vargs != nil {
+		out.Vargs = toJSONMap(c.Vargs)
+	}
+	return json.Marshal(&out)
+}
+
+// toJSONMap returns a copy of the map with nested Yaml mappings converted to
+// maps with string keys.
+func toJSONMap(in map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		out[k] = toJSONValue(v)
+	}
+	return out
+}
+
+func toJSONValue(in interface{}) interface{} {
+	switch v := in.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			out[fmt.Sprintf("%v", k)] = toJSONValue(vv)
+		}
+		return out
+	case map[string]interface{}:
+		return toJSONMap(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, vv := range v {
+			out[i] = toJSONValue(vv)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -28,20 +28,35 @@ func TestContainerNode(t *testing.T) {
 				g.Assert(c.Build).Equal(".")
 				g.Assert(c.Pull).Equal(true)
 				g.Assert(c.Privileged).Equal(true)
+				g.Assert(c.User).Equal("1000:1000")
+				g.Assert(c.Platform).Equal("linux/arm64")
+				g.Assert(c.Init).IsTrue()
 				g.Assert(c.Entrypoint).Equal([]string{"/bin/sh"})
 				g.Assert(c.Command).Equal([]string{"yes"})
 				g.Assert(c.Commands).Equal([]string{"whoami"})
+				g.Assert(c.BeforeScript).Equal([]string{"echo before"})
+				g.Assert(c.AfterScript).Equal([]string{"echo after"})
+				g.Assert(c.OnSuccess).Equal([]string{"echo success"})
+				g.Assert(c.OnFailure).Equal([]string{"echo failure"})
 				g.Assert(c.ExtraHosts).Equal([]string{"foo.com"})
 				g.Assert(c.Volumes).Equal([]string{"/foo:/bar"})
 				g.Assert(c.VolumesFrom).Equal([]string{"foo"})
 				g.Assert(c.Devices).Equal([]string{"/dev/tty0"})
 				g.Assert(c.Network).Equal("bridge")
+				g.Assert(c.Alias).Equal("database")
+				g.Assert(c.Links).Equal([]string{"redis:cache"})
 				g.Assert(c.DNS).Equal([]string{"8.8.8.8"})
 				g.Assert(c.MemSwapLimit).Equal(int64(1))
+				g.Assert(*c.MemSwappiness).Equal(int64(10))
 				g.Assert(c.MemLimit).Equal(int64(2))
 				g.Assert(c.CPUQuota).Equal(int64(3))
 				g.Assert(c.CPUSet).Equal("1,2")
 				g.Assert(c.OomKillDisable).Equal(true)
+				g.Assert(c.Sysctls["net.core.somaxconn"]).Equal("1024")
+				g.Assert(c.SecretFiles["SSH_KEY"]).Equal("/root/.ssh/id_rsa")
+				g.Assert(c.Cache).Equal([]string{"~/.m2", "/go/pkg"})
+				g.Assert(c.DependsOn).Equal(Dependencies{"bar": DependAlways})
+				g.Assert(c.ExecIn).Equal("database")
 				g.Assert(c.AuthConfig.Username).Equal("octocat")
 				g.Assert(c.AuthConfig.Password).Equal("password")
 				g.Assert(c.AuthConfig.Email).Equal("octocat@github.com")
@@ -60,6 +75,86 @@ func TestContainerNode(t *testing.T) {
 				g.Assert(out.containers[0].Name).Equal("bar")
 			})
 
+			g.It("should unmarshal without swappiness", func() {
+				in := []byte("foo: { image: golang }")
+				out := containerList{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.containers[0].MemSwappiness == nil).IsTrue()
+			})
+
+			g.It("should merge anchors", func() {
+				conf, err := ParseString(sampleAnchors)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(len(conf.Pipeline)).Equal(2)
+				g.Assert(conf.Pipeline[0].Name).Equal("test")
+				g.Assert(conf.Pipeline[0].Image).Equal("golang:1.6")
+				g.Assert(conf.Pipeline[0].Environment).Equal(map[string]string{"CGO_ENABLED": "0"})
+				g.Assert(conf.Pipeline[0].Commands).Equal([]string{"go test"})
+				g.Assert(conf.Pipeline[1].Name).Equal("build")
+				g.Assert(conf.Pipeline[1].Image).Equal("golang:1.5")
+				g.Assert(conf.Pipeline[1].Environment).Equal(map[string]string{"CGO_ENABLED": "0"})
+			})
+
+			g.It("should inherit from the extended step", func() {
+				conf, err := ParseString(sampleExtends)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(len(conf.Pipeline)).Equal(3)
+				g.Assert(conf.Pipeline[1].Name).Equal("integration")
+				g.Assert(conf.Pipeline[1].Image).Equal("golang:1.6")
+				g.Assert(conf.Pipeline[1].Environment).Equal(map[string]string{"GOOS": "linux"})
+				g.Assert(conf.Pipeline[1].Commands).Equal([]string{"go test -tags integration"})
+				g.Assert(conf.Pipeline[2].Name).Equal("build")
+				g.Assert(conf.Pipeline[2].Image).Equal("golang:1.6")
+				g.Assert(conf.Pipeline[2].Environment).Equal(map[string]string{"GOOS": "darwin"})
+				g.Assert(conf.Pipeline[2].Commands).Equal([]string{"go build"})
+			})
+
+			g.It("should not default the image of command steps", func() {
+				in := []byte("build: { commands: [ make ] }\nslack: { channel: dev }")
+				out := containerList{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.containers[0].Image).Equal("")
+				g.Assert(out.containers[1].Image).Equal("slack")
+			})
+
+			g.It("should inherit from an extended anchor", func() {
+				in := []byte("foo: { extends: { image: golang, privileged: true }, privileged: false }")
+				out := containerList{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out.containers[0].Name).Equal("foo")
+				g.Assert(out.containers[0].Image).Equal("golang")
+				g.Assert(out.containers[0].Privileged).IsFalse()
+				g.Assert(out.containers[0].Vargs["extends"] == nil).IsTrue()
+			})
+
+			g.It("should error when the extended step is not defined", func() {
+				in := []byte("foo: { extends: bar }")
+				out := containerList{}
+				err := yaml.Unmarshal(in, &out)
+				g.Assert(err != nil).IsTrue("expects an error")
+				g.Assert(err.Error()).Equal("Cannot extend foo, bar is not defined")
+			})
+
+			g.It("should error when the extended steps are circular", func() {
+				in := []byte("foo: { extends: bar }\nbar: { extends: foo }")
+				out := containerList{}
+				err := yaml.Unmarshal(in, &out)
+				g.Assert(err != nil).IsTrue("expects an error")
+				g.Assert(err.Error()).Equal("Cannot extend bar, circular reference to foo")
+			})
 		})
 	})
 }
@@ -70,22 +165,39 @@ foo:
   build: .
   pull: true
   privileged: true
+  user: 1000:1000
+  platform: linux/arm64
+  init: true
   environment:
     FOO: BAR
   entrypoint: /bin/sh
   command: "yes"
   commands: whoami
+  before_script: echo before
+  after_script: [ echo after ]
+  on_success: echo success
+  on_failure: [ echo failure ]
   extra_hosts: foo.com
   volumes: /foo:/bar
   volumes_from: foo
   devices: /dev/tty0
   network_mode: bridge
+  alias: database
+  links: redis:cache
   dns: 8.8.8.8
   memswap_limit: 1
+  mem_swappiness: 10
   mem_limit: 2
   cpu_quota: 3
   cpuset: 1,2
   oom_kill_disable: true
+  sysctls:
+    net.core.somaxconn: 1024
+  cache: [ ~/.m2, /go/pkg ]
+  depends_on: { bar: always }
+  exec_in: database
+  secret_files:
+    SSH_KEY: /root/.ssh/id_rsa
 
   auth_config:
     username: octocat
@@ -95,3 +207,36 @@ foo:
   access_key: 970d28f4dd477bc184fbd10b376de753
   secret_key: 9c5785d3ece6a9cdefa42eb99b58986f9095ff1c
 `
+
+var sampleAnchors = `
+defaults: &defaults
+  image: golang:1.6
+  environment:
+    - CGO_ENABLED=0
+
+pipeline:
+  test:
+    <<: *defaults
+    commands: go test
+  build:
+    <<: *defaults
+    image: golang:1.5
+    commands: go build
+`
+
+var sampleExtends = `
+pipeline:
+  test:
+    image: golang:1.6
+    environment:
+      - GOOS=linux
+    commands: go test
+  integration:
+    extends: test
+    commands: go test -tags integration
+  build:
+    extends: integration
+    environment:
+      - GOOS=darwin
+    commands: go build
+`
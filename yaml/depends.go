@@ -0,0 +1,44 @@
+package yaml
+
+import "github.com/drone/drone-exec/yaml/types"
+
+// Dependency modes.
+const (
+	DependSuccess = "success" // run only if the dependency succeeded
+	DependAlways  = "always"  // run once the dependency ran, regardless of status
+)
+
+// Dependencies defines the prior steps a step depends on, keyed by step name,
+// and the mode of each dependency.
+type Dependencies map[string]string
+
+// Match returns true if every dependency that requires success succeeded.
+// Dependencies in always mode do not prevent the step from running.
+func (d Dependencies) Match(succeeded map[string]bool) bool {
+	for name, mode := range d {
+		if mode != DependAlways && !succeeded[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling. The dependencies are
+// either a list of step names that must succeed, or a map of step names to
+// the dependency mode.
+func (d *Dependencies) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var modes map[string]string
+	if err := unmarshal(&modes); err == nil {
+		*d = modes
+		return nil
+	}
+	var names types.StringOrSlice
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+	*d = Dependencies{}
+	for _, name := range names.Slice() {
+		(*d)[name] = DependSuccess
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDependencies(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Dependencies", func() {
+		g.Describe("given a yaml file", func() {
+
+			g.It("should unmarshal", func() {
+				in := []byte("{ build: success, test: always }")
+				out := Dependencies{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out).Equal(Dependencies{"build": DependSuccess, "test": DependAlways})
+			})
+
+			g.It("should unmarshal shorthand", func() {
+				in := []byte("[ build, test ]")
+				out := Dependencies{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(out).Equal(Dependencies{"build": DependSuccess, "test": DependSuccess})
+			})
+		})
+
+		g.It("should match when success dependencies succeeded", func() {
+			d := Dependencies{"build": DependSuccess, "test": DependAlways}
+			g.Assert(d.Match(map[string]bool{"build": true})).IsTrue()
+			g.Assert(d.Match(map[string]bool{"build": true, "test": true})).IsTrue()
+			g.Assert(d.Match(map[string]bool{"test": true})).IsFalse()
+		})
+
+		g.It("should match without dependencies", func() {
+			g.Assert(Dependencies(nil).Match(map[string]bool{})).IsTrue()
+		})
+	})
+}
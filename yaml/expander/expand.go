@@ -31,3 +31,17 @@ func ExpandString(config string, envs map[string]string) string {
 	}
 	return expanded
 }
+
+// ExpandStringSafe injects the variables into the Yaml configuration string
+// using a ${key} template parameter, excluding any variable not included in
+// the list of safe variables. Excluded variables are left unchanged, allowing
+// the shell to expand them at runtime without altering the Yaml structure.
+func ExpandStringSafe(config string, envs map[string]string, safe []string) string {
+	filtered := map[string]string{}
+	for _, k := range safe {
+		if v, ok := envs[k]; ok {
+			filtered[k] = v
+		}
+	}
+	return ExpandString(config, filtered)
+}
@@ -38,6 +38,20 @@ func TestExpand(t *testing.T) {
 			g.Assert("tag: f36cbf54").Equal(ExpandString(s, m))
 		})
 
+		g.It("Should only replace safe vars", func() {
+			s := "echo ${DRONE_COMMIT_SHA} ${DRONE_COMMIT_MESSAGE}"
+			m := map[string]string{}
+			m["DRONE_COMMIT_SHA"] = "f36cbf54"
+			m["DRONE_COMMIT_MESSAGE"] = "}\nprivileged: true"
+			safe := []string{"DRONE_COMMIT_SHA"}
+			g.Assert("echo f36cbf54 ${DRONE_COMMIT_MESSAGE}").Equal(ExpandStringSafe(s, m, safe))
+		})
+
+		g.It("Should ignore missing safe vars", func() {
+			s := "echo ${FOO}"
+			g.Assert(s).Equal(ExpandStringSafe(s, map[string]string{}, []string{"FOO"}))
+		})
+
 		g.It("Should handle nested substitution operations", func() {
 			s := `echo "${TAG##v}"`
 			m := map[string]string{}
@@ -0,0 +1,159 @@
+package yaml
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Lint result severities.
+const (
+	LintError   = "error"   // the build fails or the step never runs
+	LintWarning = "warning" // the configuration is likely a mistake
+)
+
+// sections are the top-level sections of the Yaml configuration document.
+var sections = map[string]bool{
+	"image":     true,
+	"build":     true,
+	"workspace": true,
+	"cache":     true,
+	"clone":     true,
+	"services":  true,
+	"pipeline":  true,
+	"networks":  true,
+	"volumes":   true,
+	"disable":   true,
+	"matrix":    true,
+	"secrets":   true,
+}
+
+// events and statuses are the values matched by the event and status
+// constraints. The change and changed statuses are replaced with the
+// opposite of the prior build status by the ChangeFilter transform.
+var (
+	events   = []string{"push", "pull_request", "tag", "deployment"}
+	statuses = []string{"success", "failure", "change", "changed"}
+)
+
+// LintResult defines a problem found in the Yaml configuration document.
+type LintResult struct {
+	Severity string // result severity, either LintError or LintWarning
+	Step     string // step name, if the result applies to a step
+	Message  string // actionable description of the problem
+}
+
+func (r *LintResult) String() string {
+	if r.Step == "" {
+		return fmt.Sprintf("%s: %s", r.Severity, r.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", r.Severity, r.Step, r.Message)
+}
+
+// Lint checks the Yaml configuration document for common mistakes, such as
+// empty sections and steps caused by the indentation, steps without an image
+// or commands, which run the image named after the step, and steps with
+// conditions that can never match. An error is returned if the document
+// cannot be parsed.
+func Lint(data []byte) ([]*LintResult, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	conf, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*LintResult
+	add := func(severity, step, format string, args ...interface{}) {
+		results = append(results, &LintResult{
+			Severity: severity,
+			Step:     step,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, item := range doc {
+		key := fmt.Sprint(item.Key)
+		switch {
+		case key == "pipeline" || key == "services":
+			if item.Value == nil {
+				add(LintWarning, "", "The %s section is empty. Check the indentation of its steps", key)
+				continue
+			}
+			steps, _ := item.Value.(yaml.MapSlice)
+			for _, step := range steps {
+				name := fmt.Sprint(step.Key)
+				switch {
+				case step.Value == nil:
+					add(LintError, name, "The step is empty. Check the indentation of its settings")
+				case key == "pipeline" && !hasKey(step.Value, "image", "commands", "build"):
+					add(LintWarning, name, "The step has neither an image nor commands, and runs the %s image named after the step", name)
+				}
+			}
+		case !sections[key] && hasKey(item.Value, "image", "commands"):
+			add(LintWarning, "", "Unknown section %s looks like a step. Check the indentation of the pipeline steps", key)
+		}
+	}
+
+	var containers []*Container
+	containers = append(containers, conf.Services...)
+	containers = append(containers, conf.Pipeline...)
+	for _, c := range containers {
+		lintConstraint(add, c.Name, "event", c.Constraints.Event, events)
+		lintConstraint(add, c.Name, "status", c.Constraints.Status, statuses)
+		lintConstraint(add, c.Name, "branch", c.Constraints.Branch, nil)
+		lintConstraint(add, c.Name, "environment", c.Constraints.Environment, nil)
+		lintConstraint(add, c.Name, "platform", c.Constraints.Platform, nil)
+	}
+	return results, nil
+}
+
+// lintConstraint reports a constraint that can never match, because every
+// included value is excluded, or is not one of the valid values, if defined.
+func lintConstraint(add func(string, string, string, ...interface{}), step, name string, c Constraint, valid []string) {
+	if len(c.Include) == 0 {
+		return
+	}
+	reachable := false
+	for _, include := range c.Include {
+		if valid != nil && !matchAny(include, valid) {
+			add(LintWarning, step, "The %s condition %s never matches. Valid values are %v", name, include, valid)
+			continue
+		}
+		if !c.Excludes(include) {
+			reachable = true
+		}
+	}
+	if !reachable {
+		add(LintError, step, "The %s condition never matches, so the step never runs", name)
+	}
+}
+
+// matchAny returns true if the pattern matches any of the values.
+func matchAny(pattern string, values []string) bool {
+	c := Constraint{Include: []string{pattern}}
+	for _, v := range values {
+		if c.Includes(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKey returns true if the value is a mapping that defines any of the keys.
+func hasKey(value interface{}, keys ...string) bool {
+	m, ok := value.(yaml.MapSlice)
+	if !ok {
+		return false
+	}
+	for _, item := range m {
+		for _, key := range keys {
+			if fmt.Sprint(item.Key) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
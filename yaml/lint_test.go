@@ -0,0 +1,99 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestLint(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Lint", func() {
+
+		g.It("should not report a valid document", func() {
+			results, err := Lint([]byte(sampleLint))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(0)
+		})
+
+		g.It("should report an empty section", func() {
+			results, err := Lint([]byte("pipeline:\nbuild:\n  image: golang\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].String()).Equal("warning: The pipeline section is empty. Check the indentation of its steps")
+		})
+
+		g.It("should report a section that looks like a step", func() {
+			results, err := Lint([]byte("pipeline:\n  test:\n    image: golang\ndeploy:\n  image: plugins/docker\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].Severity).Equal(LintWarning)
+			g.Assert(results[0].Message).Equal("Unknown section deploy looks like a step. Check the indentation of the pipeline steps")
+		})
+
+		g.It("should report an empty step", func() {
+			results, err := Lint([]byte("pipeline:\n  test:\n  deploy:\n    image: plugins/docker\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].String()).Equal("error: test: The step is empty. Check the indentation of its settings")
+		})
+
+		g.It("should report a step without an image or commands", func() {
+			results, err := Lint([]byte("pipeline:\n  test:\n    environment: [ GOOS=linux ]\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].String()).Equal("warning: test: The step has neither an image nor commands, and runs the test image named after the step")
+		})
+
+		g.It("should report a condition excluding every included value", func() {
+			results, err := Lint([]byte("pipeline:\n  deploy:\n    image: plugins/docker\n    when:\n      branch:\n        include: master\n        exclude: [ master, develop ]\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].String()).Equal("error: deploy: The branch condition never matches, so the step never runs")
+		})
+
+		g.It("should report a condition with invalid values", func() {
+			results, err := Lint([]byte("pipeline:\n  deploy:\n    image: plugins/docker\n    when:\n      event: [ push, pull ]\n"))
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].String()).Equal("warning: deploy: The event condition pull never matches. Valid values are [push pull_request tag deployment]")
+
+			results, _ = Lint([]byte("pipeline:\n  notify:\n    image: slack\n    when:\n      status: failed\n"))
+			g.Assert(len(results)).Equal(2)
+			g.Assert(results[1].String()).Equal("error: notify: The status condition never matches, so the step never runs")
+		})
+
+		g.It("should accept the change status conditions", func() {
+			for _, status := range []string{"change", "changed"} {
+				results, err := Lint([]byte("pipeline:\n  notify:\n    image: slack\n    when:\n      status: " + status + "\n"))
+				g.Assert(err == nil).IsTrue()
+				g.Assert(len(results)).Equal(0)
+			}
+		})
+
+		g.It("should return the parse error", func() {
+			_, err := Lint([]byte("pipeline: [ invalid"))
+			g.Assert(err != nil).IsTrue()
+		})
+	})
+}
+
+var sampleLint = `
+image: golang:1.5
+services:
+  database:
+    image: postgres
+pipeline:
+  test:
+    commands: [ go test ]
+  deploy:
+    image: plugins/docker
+    when:
+      event: [ push, tag ]
+      branch:
+        include: release/*
+        exclude: release/old
+matrix:
+  GO_VERSION: [ 1.4, 1.5 ]
+`
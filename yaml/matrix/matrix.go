@@ -0,0 +1,137 @@
+package matrix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// limit defines the maximum number of matrix combinations.
+const limit = 25
+
+// Axis represents a single combination of the matrix values.
+type Axis map[string]string
+
+// String returns the axis values in KEY=VALUE format, sorted by key.
+func (a Axis) String() string {
+	var pairs []string
+	for key, value := range a {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// ParseString parses the matrix section of the Yaml configuration and
+// returns every combination of the matrix values.
+func ParseString(data string) ([]Axis, error) {
+	return Parse([]byte(data))
+}
+
+// Parse parses the matrix section of the Yaml configuration and returns every
+// combination of the matrix values, merged with the included combinations
+// that are not already part of the matrix. No combinations are returned if
+// the Yaml does not define a matrix.
+func Parse(data []byte) ([]Axis, error) {
+	include := struct {
+		Matrix struct {
+			Include []Axis
+		}
+	}{}
+	if err := yaml.Unmarshal(data, &include); err != nil {
+		return nil, fmt.Errorf("Invalid matrix. %s", err)
+	}
+
+	matrix := struct {
+		Matrix map[string]values
+	}{}
+	if err := yaml.Unmarshal(data, &matrix); err != nil {
+		return nil, fmt.Errorf("Invalid matrix. %s", err)
+	}
+	axes := map[string][]string{}
+	for key, values := range matrix.Matrix {
+		if key == "include" {
+			continue
+		}
+		if values.err != nil {
+			return nil, fmt.Errorf("Invalid matrix. %s", values.err)
+		}
+		axes[key] = values.list
+	}
+
+	combinations, err := calc(axes)
+	if err != nil {
+		return nil, err
+	}
+	return merge(combinations, include.Matrix.Include)
+}
+
+// values defines the values of a matrix key. The values of the include key
+// are combinations instead of a list, and are parsed separately.
+type values struct {
+	list []string
+	err  error
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling.
+func (v *values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	v.err = unmarshal(&v.list)
+	return nil
+}
+
+// merge appends the included combinations that are not already part of the
+// matrix combinations, and returns an error if the merged combinations exceed
+// the limit.
+func merge(axes, include []Axis) ([]Axis, error) {
+	seen := map[string]bool{}
+	for _, axis := range axes {
+		seen[axis.String()] = true
+	}
+	for _, axis := range include {
+		if seen[axis.String()] {
+			continue
+		}
+		seen[axis.String()] = true
+		axes = append(axes, axis)
+	}
+	if len(axes) > limit {
+		return nil, fmt.Errorf("Matrix exceeds the maximum of %d combinations", limit)
+	}
+	return axes, nil
+}
+
+// calc returns every combination of the matrix values, ordered by the
+// matrix keys in alphabetical order.
+func calc(matrix map[string][]string) ([]Axis, error) {
+	if len(matrix) == 0 {
+		return nil, nil
+	}
+	var keys []string
+	total := 1
+	for key, values := range matrix {
+		keys = append(keys, key)
+		total *= len(values)
+	}
+	sort.Strings(keys)
+	if total > limit {
+		return nil, fmt.Errorf("Matrix exceeds the maximum of %d combinations", limit)
+	}
+
+	axes := []Axis{{}}
+	for _, key := range keys {
+		var next []Axis
+		for _, axis := range axes {
+			for _, value := range matrix[key] {
+				combination := Axis{key: value}
+				for k, v := range axis {
+					combination[k] = v
+				}
+				next = append(next, combination)
+			}
+		}
+		axes = next
+	}
+	return axes, nil
+}
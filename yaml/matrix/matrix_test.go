@@ -0,0 +1,116 @@
+package matrix
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestMatrix(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Matrix", func() {
+
+		g.It("should calculate every combination", func() {
+			axes, err := ParseString(fakeMatrix)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(axes)).Equal(6)
+			g.Assert(axes[0].String()).Equal("DATABASE=mysql GO_VERSION=1.4")
+			g.Assert(axes[1].String()).Equal("DATABASE=mysql GO_VERSION=1.5")
+			g.Assert(axes[2].String()).Equal("DATABASE=mysql GO_VERSION=1.6")
+			g.Assert(axes[3].String()).Equal("DATABASE=postgres GO_VERSION=1.4")
+			g.Assert(axes[5].String()).Equal("DATABASE=postgres GO_VERSION=1.6")
+		})
+
+		g.It("should return the included combinations", func() {
+			axes, err := ParseString(fakeMatrixInclude)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(axes)).Equal(2)
+			g.Assert(axes[0]).Equal(Axis{"GO_VERSION": "1.4", "REDIS_VERSION": "2.8"})
+			g.Assert(axes[1]).Equal(Axis{"GO_VERSION": "1.5"})
+		})
+
+		g.It("should merge the included combinations", func() {
+			axes, err := ParseString(fakeMatrixMerge)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(axes)).Equal(3)
+			g.Assert(axes[0]).Equal(Axis{"GO_VERSION": "1.4"})
+			g.Assert(axes[1]).Equal(Axis{"GO_VERSION": "1.5"})
+			g.Assert(axes[2]).Equal(Axis{"GO_VERSION": "1.6", "GOOS": "linux"})
+		})
+
+		g.It("should error when the included combinations are invalid", func() {
+			_, err := ParseString("matrix:\n  include: [ linux ]\n")
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.HasPrefix(err.Error(), "Invalid matrix.")).IsTrue()
+		})
+
+		g.It("should error when the merged matrix is too large", func() {
+			_, err := ParseString(fakeMatrixLargeInclude)
+			g.Assert(err.Error()).Equal("Matrix exceeds the maximum of 25 combinations")
+		})
+
+		g.It("should return no combinations without a matrix", func() {
+			axes, err := ParseString("pipeline: {}")
+			g.Assert(err == nil).IsTrue()
+			g.Assert(len(axes)).Equal(0)
+		})
+
+		g.It("should error when the matrix is too large", func() {
+			_, err := ParseString(fakeMatrixLarge)
+			g.Assert(err.Error()).Equal("Matrix exceeds the maximum of 25 combinations")
+		})
+	})
+}
+
+var fakeMatrix = `
+matrix:
+  GO_VERSION:
+    - 1.4
+    - 1.5
+    - 1.6
+  DATABASE:
+    - mysql
+    - postgres
+`
+
+var fakeMatrixInclude = `
+matrix:
+  include:
+    - GO_VERSION: 1.4
+      REDIS_VERSION: 2.8
+    - GO_VERSION: 1.5
+`
+
+var fakeMatrixMerge = `
+matrix:
+  GO_VERSION: [ 1.4, 1.5 ]
+  include:
+    - GO_VERSION: 1.5
+    - GO_VERSION: 1.6
+      GOOS: linux
+`
+
+var fakeMatrixLarge = `
+matrix:
+  A: [ 1, 2, 3 ]
+  B: [ 1, 2, 3 ]
+  C: [ 1, 2, 3 ]
+`
+
+var fakeMatrixLargeInclude = `
+matrix:
+  A: [ 1, 2, 3 ]
+  B: [ 1, 2, 3 ]
+  C: [ 1, 2 ]
+  include:
+    - { A: 4 }
+    - { A: 5 }
+    - { A: 6 }
+    - { A: 7 }
+    - { A: 8 }
+    - { A: 9 }
+    - { A: 10 }
+    - { A: 11 }
+`
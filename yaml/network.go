@@ -8,9 +8,9 @@ import (
 
 // Network defines a Docker network.
 type Network struct {
-	Name       string
-	Driver     string
-	DriverOpts map[string]string `yaml:"driver_opts"`
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty" yaml:"driver_opts"`
 }
 
 // networkList is an intermediate type used for decoding a slice of networks
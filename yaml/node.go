@@ -0,0 +1,12 @@
+package yaml
+
+// Node types of the Yaml configuration. The clone, cache, services and
+// plugins nodes can be disabled, and the containers of the services and
+// build nodes can be preserved when the build fails.
+const (
+	NodeClone    = "clone"    // clone step
+	NodeCache    = "cache"    // cached paths
+	NodeServices = "services" // service containers, including the ambassador
+	NodePlugins  = "plugins"  // plugin steps, except the clone step
+	NodeBuild    = "build"    // build and plugin steps
+)
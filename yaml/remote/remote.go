@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// maxSize defines the maximum size of the remote Yaml configuration.
+const maxSize = 1000000
+
+// Client is the http client used to fetch the remote Yaml configuration.
+var Client = http.DefaultClient
+
+// Fetch fetches the Yaml configuration from the remote url. The token, if
+// not empty, is sent as a bearer token. If the checksum is not empty, the
+// configuration is rejected unless its sha256 checksum matches.
+func Fetch(rawurl, token, checksum string) (string, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error fetching remote yaml. Status code %d", resp.StatusCode)
+	}
+
+	out, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(out) > maxSize {
+		return "", fmt.Errorf("Error fetching remote yaml. Exceeds maximum size")
+	}
+
+	if checksum != "" {
+		if sum := fmt.Sprintf("%x", sha256.Sum256(out)); sum != checksum {
+			return "", fmt.Errorf("Error fetching remote yaml. Checksum mismatch %s", sum)
+		}
+	}
+	if _, err := yaml.Parse(out); err != nil {
+		return "", fmt.Errorf("Error parsing remote yaml. %s", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+func TestFetch(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Remote yaml", func() {
+
+		var auth string
+		var server *httptest.Server
+
+		g.Before(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				switch r.URL.Path {
+				case "/octocat/hello-world.yml":
+					w.Write([]byte(sampleYaml))
+				case "/octocat/invalid.yml":
+					w.Write([]byte("pipeline: [ foo"))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+		})
+
+		g.After(func() {
+			server.Close()
+		})
+
+		g.It("should fetch and parse the yaml", func() {
+			out, err := Fetch(server.URL+"/octocat/hello-world.yml", "", "")
+			g.Assert(err == nil).IsTrue("expects fetch to succeed")
+			g.Assert(out).Equal(sampleYaml)
+
+			conf, err := yaml.ParseString(out)
+			g.Assert(err == nil).IsTrue("expects parse to succeed")
+			g.Assert(conf.Pipeline[0].Name).Equal("test")
+			g.Assert(conf.Pipeline[0].Commands).Equal([]string{"go test"})
+		})
+
+		g.It("should send the bearer token", func() {
+			Fetch(server.URL+"/octocat/hello-world.yml", "f1d2d2f9", "")
+			g.Assert(auth).Equal("Bearer f1d2d2f9")
+		})
+
+		g.It("should verify the checksum", func() {
+			sum := fmt.Sprintf("%x", sha256.Sum256([]byte(sampleYaml)))
+			_, err := Fetch(server.URL+"/octocat/hello-world.yml", "", sum)
+			g.Assert(err == nil).IsTrue("expects checksum to match")
+		})
+
+		g.It("should error when checksum mismatch", func() {
+			_, err := Fetch(server.URL+"/octocat/hello-world.yml", "", "e3b0c442")
+			g.Assert(err != nil).IsTrue("expects checksum mismatch")
+		})
+
+		g.It("should error when yaml is invalid", func() {
+			_, err := Fetch(server.URL+"/octocat/invalid.yml", "", "")
+			g.Assert(err != nil).IsTrue("expects parse error")
+		})
+
+		g.It("should error when yaml is not found", func() {
+			_, err := Fetch(server.URL+"/octocat/missing.yml", "", "")
+			g.Assert(err != nil).IsTrue("expects fetch error")
+			g.Assert(err.Error()).Equal("Error fetching remote yaml. Status code 404")
+		})
+	})
+}
+
+var sampleYaml = `
+pipeline:
+  test:
+    image: golang
+    commands:
+      - go test
+`
@@ -0,0 +1,49 @@
+package yaml
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Secret defines the restrictions of a repository secret, in addition to the
+// image and event restrictions of the secret itself.
+type Secret struct {
+	Name string `json:"name"`
+
+	// Branch restricts the secret to builds of the matching branches, such
+	// as protected branches for production credentials.
+	Branch Constraint `json:"branch"`
+}
+
+// secretList is an intermediate type used for decoding a map of secret
+// names to their restrictions.
+type secretList struct {
+	secrets []*Secret
+}
+
+// UnmarshalYAML implements custom Yaml unmarshaling.
+func (s *secretList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	slice := yaml.MapSlice{}
+	err := unmarshal(&slice)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range slice {
+		secret := Secret{}
+
+		out, merr := yaml.Marshal(item.Value)
+		if merr != nil {
+			return merr
+		}
+
+		err = yaml.Unmarshal(out, &secret)
+		if err != nil {
+			return err
+		}
+		secret.Name = fmt.Sprintf("%v", item.Key)
+		s.secrets = append(s.secrets, &secret)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecrets(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Secrets", func() {
+		g.Describe("given a yaml file", func() {
+
+			g.It("should unmarshal", func() {
+				in := []byte("DEPLOY_KEY: { branch: [ master, release/* ] }")
+				out := secretList{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(len(out.secrets)).Equal(1)
+				g.Assert(out.secrets[0].Name).Equal("DEPLOY_KEY")
+				g.Assert(out.secrets[0].Branch.Include).Equal([]string{"master", "release/*"})
+			})
+
+			g.It("should unmarshal branch exclusions", func() {
+				in := []byte("DEPLOY_KEY: { branch: { exclude: feature/* } }")
+				out := secretList{}
+				err := yaml.Unmarshal(in, &out)
+				if err != nil {
+					g.Fail(err)
+				}
+				g.Assert(len(out.secrets)).Equal(1)
+				g.Assert(out.secrets[0].Branch.Exclude).Equal([]string{"feature/*"})
+			})
+		})
+	})
+}
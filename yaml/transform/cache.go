@@ -0,0 +1,142 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/gorilla/securecookie"
+)
+
+// Cache transforms the Yaml to mount the cached paths from named volumes
+// scoped to the repository and branch. The volumes are not removed when the
+// build completes. When the branch cache is empty it is restored from the
+// cache of the fallback branch, which is never written to by other branches.
+// The restore container uses the default ambassador image when no image is
+// provided. This transform must run after the Workspace transform.
+func Cache(c *yaml.Config, repo, branch, fallback, image string) error {
+	if c.Cache == nil || len(c.Cache.Mount) == 0 {
+		return nil
+	}
+	if image == "" {
+		image = ambassadorImage
+	}
+	if c.Cache.Fallback != "" {
+		fallback = c.Cache.Fallback
+	}
+
+	rand := base64.RawURLEncoding.EncodeToString(
+		securecookie.GenerateRandomKey(8),
+	)
+	restore := &yaml.Container{
+		ID:          fmt.Sprintf("drone_cache_%s", rand),
+		Name:        "cache",
+		Image:       image,
+		Entrypoint:  []string{"/bin/sh", "-c"},
+		Environment: map[string]string{},
+	}
+
+	var script bytes.Buffer
+	for i, path := range c.Cache.Mount {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.Workspace.Path, path)
+		}
+		volume := CacheVolume(repo, branch, path)
+		for _, container := range c.Pipeline {
+			container.Volumes = append(container.Volumes, volume+":"+path)
+			container.CacheVolumes = append(container.CacheVolumes, volume)
+		}
+
+		dest := fmt.Sprintf("/cache/%d", i)
+		restore.Volumes = append(restore.Volumes, volume+":"+dest)
+		restore.CacheVolumes = append(restore.CacheVolumes, volume)
+		if fallback == "" || fallback == branch {
+			continue
+		}
+		src := fmt.Sprintf("/fallback/%d", i)
+		restore.Volumes = append(restore.Volumes, CacheVolume(repo, fallback, path)+":"+src)
+		for _, container := range c.Pipeline {
+			cacheFallback(container, volume, CacheVolume(repo, fallback, path))
+		}
+		cacheFallback(restore, volume, CacheVolume(repo, fallback, path))
+		fmt.Fprintf(&script, restoreScript, dest, src, path, fallback, src, dest)
+	}
+
+	// the restore step is only required when the cache can fallback to the
+	// cache of another branch.
+	if script.Len() == 0 {
+		return nil
+	}
+	restore.Command = []string{script.String()}
+
+	var pipeline []*yaml.Container
+	for i, container := range c.Pipeline {
+		if i == 0 && !isClone(container) {
+			pipeline = append(pipeline, restore)
+		}
+		pipeline = append(pipeline, container)
+		if i == 0 && isClone(container) {
+			pipeline = append(pipeline, restore)
+		}
+	}
+	c.Pipeline = pipeline
+	return nil
+}
+
+// cacheFallback records the fallback branch cache volume from which the cache
+// volume mounted by the container is restored.
+func cacheFallback(c *yaml.Container, volume, fallback string) {
+	if c.CacheFallbacks == nil {
+		c.CacheFallbacks = map[string]string{}
+	}
+	c.CacheFallbacks[volume] = fallback
+}
+
+// StepCache transforms the Yaml to mount the cache paths of each step from
+// named volumes scoped to the repository, which persist across builds and
+// branches. Paths prefixed with ~ are relative to the home directory, and
+// relative paths are relative to the workspace. This transform must run
+// after the Workspace transform.
+func StepCache(c *yaml.Config, repo string) error {
+	for _, container := range c.Pipeline {
+		for _, path := range container.Cache {
+			switch {
+			case path == "~" || strings.HasPrefix(path, "~/"):
+				path = filepath.Join(homeDir, path[1:])
+			case !filepath.IsAbs(path):
+				path = filepath.Join(c.Workspace.Path, path)
+			}
+			volume := StepCacheVolume(repo, path)
+			container.Volumes = append(container.Volumes, volume+":"+path)
+			container.CacheVolumes = append(container.CacheVolumes, volume)
+		}
+	}
+	return nil
+}
+
+// StepCacheVolume returns the name of the step cache volume for the
+// repository and cached path.
+func StepCacheVolume(repo, path string) string {
+	sum := sha1.Sum([]byte(repo + ":" + path))
+	return fmt.Sprintf("drone_step_cache_%x", sum[:10])
+}
+
+// CacheVolume returns the name of the cache volume for the repository,
+// branch and cached path.
+func CacheVolume(repo, branch, path string) string {
+	sum := sha1.Sum([]byte(repo + ":" + branch + ":" + path))
+	return fmt.Sprintf("drone_cache_%x", sum[:10])
+}
+
+// restoreScript is a helper script that restores an empty cache volume from
+// the cache volume of the fallback branch.
+const restoreScript = `
+if [ -z "$(ls -A %s)" ] && [ -n "$(ls -A %s)" ]; then
+echo + restoring %s from the %s branch cache
+cp -a %s/. %s/
+fi
+`
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+func Test_cache(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("cache", func() {
+
+		g.It("should scope cache volumes by branch", func() {
+			master := CacheVolume("octocat/hello-world", "master", "/go/node_modules")
+			feature := CacheVolume("octocat/hello-world", "feature", "/go/node_modules")
+			g.Assert(master == feature).IsFalse()
+			g.Assert(master).Equal(CacheVolume("octocat/hello-world", "master", "/go/node_modules"))
+		})
+
+		g.It("should mount cached paths from the branch volume", func() {
+			c := newCacheConfig("")
+			Cache(c, "octocat/hello-world", "master", "master", "")
+
+			volume := CacheVolume("octocat/hello-world", "master", "/go/src/node_modules")
+			g.Assert(len(c.Pipeline)).Equal(2)
+			g.Assert(c.Pipeline[0].Volumes).Equal([]string{volume + ":/go/src/node_modules"})
+			g.Assert(c.Pipeline[1].Volumes).Equal([]string{volume + ":/go/src/node_modules"})
+			g.Assert(c.Pipeline[0].CacheVolumes).Equal([]string{volume})
+			g.Assert(c.Pipeline[1].CacheVolumes).Equal([]string{volume})
+			g.Assert(c.Pipeline[0].CacheFallbacks == nil).IsTrue()
+		})
+
+		g.It("should restore from the fallback branch cache", func() {
+			c := newCacheConfig("")
+			Cache(c, "octocat/hello-world", "feature", "master", "")
+
+			branch := CacheVolume("octocat/hello-world", "feature", "/go/src/node_modules")
+			fallback := CacheVolume("octocat/hello-world", "master", "/go/src/node_modules")
+			g.Assert(len(c.Pipeline)).Equal(3)
+			g.Assert(c.Pipeline[0].Name).Equal("clone")
+			g.Assert(c.Pipeline[1].Name).Equal("cache")
+			g.Assert(c.Pipeline[1].Volumes).Equal([]string{
+				branch + ":/cache/0",
+				fallback + ":/fallback/0",
+			})
+			g.Assert(c.Pipeline[2].Volumes).Equal([]string{branch + ":/go/src/node_modules"})
+			g.Assert(c.Pipeline[1].CacheVolumes).Equal([]string{branch})
+			for _, container := range c.Pipeline {
+				g.Assert(container.CacheFallbacks).Equal(map[string]string{branch: fallback})
+			}
+		})
+
+		g.It("should restore with the configured ambassador image", func() {
+			c := newCacheConfig("")
+			Cache(c, "octocat/hello-world", "feature", "master", "registry.internal/library/busybox:1.25")
+			g.Assert(c.Pipeline[1].Name).Equal("cache")
+			g.Assert(c.Pipeline[1].Image).Equal("registry.internal/library/busybox:1.25")
+		})
+
+		g.It("should restore from the configured fallback branch", func() {
+			c := newCacheConfig("develop")
+			Cache(c, "octocat/hello-world", "feature", "master", "")
+
+			fallback := CacheVolume("octocat/hello-world", "develop", "/go/src/node_modules")
+			g.Assert(c.Pipeline[1].Volumes[1]).Equal(fallback + ":/fallback/0")
+		})
+
+		g.It("should mount step cache paths from repository volumes", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src"},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "build", Cache: []string{"~/.m2", "/go/pkg", "vendor"}},
+				},
+			}
+			StepCache(c, "octocat/hello-world")
+
+			g.Assert(len(c.Pipeline[0].Volumes)).Equal(0)
+			g.Assert(c.Pipeline[1].Volumes).Equal([]string{
+				StepCacheVolume("octocat/hello-world", "/root/.m2") + ":/root/.m2",
+				StepCacheVolume("octocat/hello-world", "/go/pkg") + ":/go/pkg",
+				StepCacheVolume("octocat/hello-world", "/go/src/vendor") + ":/go/src/vendor",
+			})
+			g.Assert(len(c.Pipeline[1].CacheVolumes)).Equal(3)
+			g.Assert(c.Pipeline[1].CacheVolumes[0]).Equal(StepCacheVolume("octocat/hello-world", "/root/.m2"))
+		})
+
+		g.It("should scope step cache volumes by repository", func() {
+			hello := StepCacheVolume("octocat/hello-world", "/go/pkg")
+			other := StepCacheVolume("octocat/spoon-knife", "/go/pkg")
+			g.Assert(hello == other).IsFalse()
+			g.Assert(hello == CacheVolume("octocat/hello-world", "", "/go/pkg")).IsFalse()
+		})
+
+		g.It("should ignore builds without a cache", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			Cache(c, "octocat/hello-world", "feature", "master", "")
+			g.Assert(len(c.Pipeline)).Equal(1)
+			g.Assert(len(c.Pipeline[0].Volumes)).Equal(0)
+		})
+	})
+}
+
+func newCacheConfig(fallback string) *yaml.Config {
+	return &yaml.Config{
+		Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src"},
+		Cache: &yaml.Cache{
+			Mount:    []string{"node_modules"},
+			Fallback: fallback,
+		},
+		Pipeline: []*yaml.Container{
+			{Name: "clone"},
+			{Name: "build", Commands: []string{"npm install"}},
+		},
+	}
+}
@@ -1,11 +1,21 @@
 package transform
 
-import "github.com/drone/drone-exec/yaml"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-go/drone"
+)
 
 const clone = "clone"
 
-// Clone transforms the Yaml to include a clone step.
+// Clone transforms the Yaml to include a clone step, unless the clone step is
+// disabled in the Yaml.
 func Clone(c *yaml.Config, plugin string) error {
+	if c.Clone != nil && c.Clone.Disable {
+		return nil
+	}
 	for _, p := range c.Pipeline {
 		if p.Name == clone {
 			return nil
@@ -23,3 +33,97 @@ func Clone(c *yaml.Config, plugin string) error {
 	c.Pipeline = append([]*yaml.Container{s}, c.Pipeline...)
 	return nil
 }
+
+// CloneVerify transforms the Yaml to configure the SSL verification of the
+// clone step. The skip_verify parameter disables verification, and the
+// ca_cert parameter defines the path of a certificate authority bundle used
+// to verify the git server.
+func CloneVerify(c *yaml.Config) error {
+	for _, p := range c.Pipeline {
+		if !isClone(p) {
+			continue
+		}
+		if p.Environment == nil {
+			p.Environment = map[string]string{}
+		}
+		if skipVerify(p) {
+			p.Environment["GIT_SSL_NO_VERIFY"] = "true"
+		}
+		if path, ok := p.Vargs["ca_cert"].(string); ok && path != "" {
+			p.Environment["GIT_SSL_CAINFO"] = path
+		}
+	}
+	return nil
+}
+
+// CloneNetrc transforms the Yaml to inject the repository netrc credentials
+// into the clone step, and only the clone step. The credentials are withheld
+// from pull requests opened from a fork, since the token grants access to the
+// repository beyond what the fork author is permitted.
+func CloneNetrc(c *yaml.Config, netrc *drone.Netrc, fork bool) error {
+	if netrc == nil || fork {
+		return nil
+	}
+	for _, p := range c.Pipeline {
+		if !isClone(p) {
+			continue
+		}
+		if p.Environment == nil {
+			p.Environment = map[string]string{}
+		}
+		p.Environment["DRONE_NETRC_MACHINE"] = netrc.Machine
+		p.Environment["DRONE_NETRC_USERNAME"] = netrc.Login
+		p.Environment["DRONE_NETRC_PASSWORD"] = netrc.Password
+	}
+	return nil
+}
+
+// skipVerify returns true if the clone step disables SSL verification.
+func skipVerify(c *yaml.Container) bool {
+	switch v := c.Vargs["skip_verify"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// CloneRetry transforms the Yaml to retry the clone step when it fails. The
+// workspace is reset before each retry to remove any partially cloned files,
+// by a reset container that uses the default ambassador image when no image
+// is provided. This transform must run after the Pod transform.
+func CloneRetry(c *yaml.Config, retries int, image string) error {
+	if retries <= 0 {
+		return nil
+	}
+	if image == "" {
+		image = ambassadorImage
+	}
+	for _, p := range c.Pipeline {
+		if p.Name != clone {
+			continue
+		}
+		p.Retries = retries
+		p.Reset = &yaml.Container{
+			ID:          p.ID + "_reset",
+			Name:        p.Name,
+			Image:       image,
+			Entrypoint:  []string{"/bin/sh", "-c"},
+			Command:     []string{resetScript(c.Workspace.Path)},
+			VolumesFrom: p.VolumesFrom,
+			Volumes:     p.Volumes,
+			Network:     p.Network,
+			Environment: map[string]string{},
+		}
+	}
+	return nil
+}
+
+// resetScript returns a shell script that removes the contents of the
+// workspace directory, including hidden files. The directory itself is a
+// volume and cannot be removed.
+func resetScript(path string) string {
+	path = "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+	return fmt.Sprintf("rm -rf %s/* %s/.[!.]* %s/..?*", path, path, path)
+}
@@ -1 +1,137 @@
 package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-go/drone"
+
+	"github.com/franela/goblin"
+)
+
+func Test_clone(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("clone step", func() {
+
+		g.It("should use the default plugin", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			Clone(c, "")
+			g.Assert(len(c.Pipeline)).Equal(2)
+			g.Assert(c.Pipeline[0].Name).Equal("clone")
+			g.Assert(c.Pipeline[0].Image).Equal("git")
+		})
+
+		g.It("should use the configured plugin", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			Clone(c, "registry.internal/drone/git:1.0")
+			g.Assert(c.Pipeline[0].Name).Equal("clone")
+			g.Assert(c.Pipeline[0].Image).Equal("registry.internal/drone/git:1.0")
+		})
+
+		g.It("should not add a clone step when disabled", func() {
+			c, err := yaml.ParseString("clone: disable\npipeline:\n  build:\n    image: golang\n")
+			if err != nil {
+				g.Fail(err)
+			}
+			Clone(c, "registry.internal/drone/git:1.0")
+			g.Assert(len(c.Pipeline)).Equal(1)
+			g.Assert(c.Pipeline[0].Name).Equal("build")
+		})
+
+		g.It("should not override a user-defined clone step", func() {
+			c := newConfig(&yaml.Container{Name: "clone", Image: "custom"})
+			Clone(c, "registry.internal/drone/git:1.0")
+			g.Assert(len(c.Pipeline)).Equal(1)
+			g.Assert(c.Pipeline[0].Image).Equal("custom")
+		})
+
+		g.It("should inject the netrc credentials into the clone step", func() {
+			c := newConfig(&yaml.Container{Name: "clone"})
+			c.Pipeline = append(c.Pipeline, &yaml.Container{Name: "build"})
+			CloneNetrc(c, netrc, false)
+			g.Assert(c.Pipeline[0].Environment["DRONE_NETRC_MACHINE"]).Equal("github.com")
+			g.Assert(c.Pipeline[0].Environment["DRONE_NETRC_USERNAME"]).Equal("octocat")
+			g.Assert(c.Pipeline[0].Environment["DRONE_NETRC_PASSWORD"]).Equal("x-oauth-basic")
+			g.Assert(len(c.Pipeline[1].Environment)).Equal(0)
+		})
+
+		g.It("should withhold the netrc credentials from forks", func() {
+			c := newConfig(&yaml.Container{Name: "clone"})
+			CloneNetrc(c, netrc, true)
+			g.Assert(len(c.Pipeline[0].Environment)).Equal(0)
+		})
+
+		g.It("should skip ssl verification for the clone step", func() {
+			c := newConfig(&yaml.Container{
+				Name:  "clone",
+				Vargs: map[string]interface{}{"skip_verify": true},
+			})
+			CloneVerify(c)
+			g.Assert(c.Pipeline[0].Environment["GIT_SSL_NO_VERIFY"]).Equal("true")
+		})
+
+		g.It("should configure the clone certificate authority", func() {
+			c := newConfig(&yaml.Container{
+				Name:  "clone",
+				Vargs: map[string]interface{}{"ca_cert": "/etc/ssl/certs/internal.pem"},
+			})
+			CloneVerify(c)
+			g.Assert(c.Pipeline[0].Environment["GIT_SSL_CAINFO"]).Equal("/etc/ssl/certs/internal.pem")
+			g.Assert(c.Pipeline[0].Environment["GIT_SSL_NO_VERIFY"]).Equal("")
+		})
+
+		g.It("should only configure ssl verification for the clone step", func() {
+			c := newConfig(&yaml.Container{
+				Name:  "deploy",
+				Vargs: map[string]interface{}{"skip_verify": true},
+			})
+			CloneVerify(c)
+			g.Assert(c.Pipeline[0].Environment["GIT_SSL_NO_VERIFY"]).Equal("")
+		})
+
+		g.It("should configure retries for the clone step", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Path: "/drone/src/github.com/octocat/hello-world"},
+				Pipeline: []*yaml.Container{
+					{ID: "drone_0", Name: "clone", VolumesFrom: []string{"drone_ambassador"}},
+					{ID: "drone_1", Name: "build"},
+				},
+			}
+			CloneRetry(c, 3, "")
+			g.Assert(c.Pipeline[0].Retries).Equal(3)
+			g.Assert(c.Pipeline[0].Reset.ID).Equal("drone_0_reset")
+			g.Assert(c.Pipeline[0].Reset.Image).Equal(ambassadorImage)
+			g.Assert(c.Pipeline[0].Reset.VolumesFrom).Equal([]string{"drone_ambassador"})
+			g.Assert(c.Pipeline[0].Reset.Command).Equal([]string{
+				"rm -rf '/drone/src/github.com/octocat/hello-world'/* " +
+					"'/drone/src/github.com/octocat/hello-world'/.[!.]* " +
+					"'/drone/src/github.com/octocat/hello-world'/..?*",
+			})
+			g.Assert(c.Pipeline[1].Retries).Equal(0)
+			g.Assert(c.Pipeline[1].Reset == nil).IsTrue()
+		})
+
+		g.It("should reset the clone step with the configured ambassador image", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Path: "/drone/src"},
+				Pipeline:  []*yaml.Container{{ID: "drone_0", Name: "clone"}},
+			}
+			CloneRetry(c, 3, "registry.internal/library/busybox:1.25")
+			g.Assert(c.Pipeline[0].Reset.Image).Equal("registry.internal/library/busybox:1.25")
+		})
+
+		g.It("should not configure retries when disabled", func() {
+			c := newConfig(&yaml.Container{Name: "clone"})
+			CloneRetry(c, 0, "")
+			g.Assert(c.Pipeline[0].Retries).Equal(0)
+			g.Assert(c.Pipeline[0].Reset == nil).IsTrue()
+		})
+	})
+}
+
+var netrc = &drone.Netrc{
+	Machine:  "github.com",
+	Login:    "octocat",
+	Password: "x-oauth-basic",
+}
@@ -14,7 +14,7 @@ import (
 func CommandTransform(c *yaml.Config) error {
 	for _, p := range c.Pipeline {
 
-		if isPlugin(p) {
+		if isPlugin(p) || isImageBuild(p) {
 			continue
 		}
 
@@ -27,16 +27,50 @@ func CommandTransform(c *yaml.Config) error {
 		if p.Environment == nil {
 			p.Environment = map[string]string{}
 		}
-		p.Environment["HOME"] = "/root"
+		p.Environment["HOME"] = homeDir
 		p.Environment["SHELL"] = "/bin/sh"
-		p.Environment["DRONE_SCRIPT"] = toScript(
-			p.Commands,
-		)
+		p.Environment["DRONE_SCRIPT"] = toScript(p)
 	}
 	return nil
 }
 
-func toScript(commands []string) string {
+// toScript returns the base64 encoded shell script for the step. The before
+// script is prepended to the commands, the on success or on failure commands
+// are executed depending on the exit status of the commands, and the after
+// script is executed when the commands complete, regardless of exit status.
+func toScript(c *yaml.Container) string {
+	var commands []string
+	commands = append(commands, c.BeforeScript...)
+	commands = append(commands, c.Commands...)
+
+	body := toTrace(commands)
+	if len(c.OnSuccess) != 0 || len(c.OnFailure) != 0 {
+		body = fmt.Sprintf(
+			outcomeScript,
+			body,
+			toTrace(c.OnSuccess),
+			toTrace(c.OnFailure),
+		)
+	}
+	if len(c.AfterScript) != 0 {
+		body = fmt.Sprintf(
+			afterScript,
+			body,
+			toTrace(c.AfterScript),
+		)
+	}
+
+	script := fmt.Sprintf(
+		setupScript,
+		body,
+	)
+
+	return base64.StdEncoding.EncodeToString([]byte(script))
+}
+
+// toTrace returns the shell script for the list of commands, with each
+// command prefixed with a trace statement.
+func toTrace(commands []string) string {
 	var buf bytes.Buffer
 	for _, command := range commands {
 		escaped := fmt.Sprintf("%q", command)
@@ -47,15 +81,12 @@ func toScript(commands []string) string {
 			command,
 		))
 	}
-
-	script := fmt.Sprintf(
-		setupScript,
-		buf.String(),
-	)
-
-	return base64.StdEncoding.EncodeToString([]byte(script))
+	return buf.String()
 }
 
+// homeDir is the home directory of the build steps.
+const homeDir = "/root"
+
 // setupScript is a helper script this is added to the build to ensure
 // a minimum set of environment variables are set correctly.
 const setupScript = `
@@ -74,6 +105,49 @@ unset DRONE_SCRIPT
 %s
 `
 
+// afterScript is a helper script that executes the commands in a subshell
+// and captures the exit code, ensuring the after script is executed even if
+// the commands fail. The step exits with the exit code of the commands.
+const afterScript = `
+set +e
+(
+set -e
+%s
+)
+DRONE_EXIT_CODE=$?
+set -e
+%s
+exit $DRONE_EXIT_CODE
+`
+
+// outcomeScript is a helper script that executes the commands in a subshell
+// and captures the exit code, executing the on success commands if the
+// commands succeed, or the on failure commands if they fail. A failing on
+// success command fails the step, while the step exits with the exit code of
+// the commands when they fail.
+const outcomeScript = `
+set +e
+(
+set -e
+%s
+)
+DRONE_EXIT_CODE=$?
+if [ $DRONE_EXIT_CODE -eq 0 ]; then
+(
+set -e
+%s
+)
+DRONE_EXIT_CODE=$?
+else
+(
+set -e
+%s
+)
+fi
+set -e
+exit $DRONE_EXIT_CODE
+`
+
 // traceScript is a helper script that is added to the build script
 // to trace a command.
 const traceScript = `
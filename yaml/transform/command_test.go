@@ -1,6 +1,11 @@
 package transform
 
 import (
+	"bytes"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/drone/drone-exec/yaml"
@@ -30,6 +35,17 @@ func Test_command(t *testing.T) {
 			g.Assert(c.Pipeline[0].Environment["DRONE_SCRIPT"]).Equal("")
 		})
 
+		g.It("should ignore image build steps", func() {
+			c := newConfig(&yaml.Container{
+				ImageBuild: &yaml.ImageBuild{Context: "."},
+			})
+
+			CommandTransform(c)
+			g.Assert(len(c.Pipeline[0].Entrypoint)).Equal(0)
+			g.Assert(len(c.Pipeline[0].Command)).Equal(0)
+			g.Assert(c.Pipeline[0].Environment["DRONE_SCRIPT"]).Equal("")
+		})
+
 		g.It("should set entrypoint, command and environment variables", func() {
 			c := newConfig(&yaml.Container{
 				Commands: []string{
@@ -43,5 +59,94 @@ func Test_command(t *testing.T) {
 			g.Assert(c.Pipeline[0].Command).Equal([]string{"echo $DRONE_SCRIPT | base64 -d | /bin/sh -e"})
 			g.Assert(c.Pipeline[0].Environment["DRONE_SCRIPT"] != "").IsTrue()
 		})
+
+		g.It("should prepend the before script", func() {
+			out, code := runScript(&yaml.Container{
+				BeforeScript: []string{"echo before"},
+				Commands:     []string{"echo main"},
+			})
+			g.Assert(code).Equal(0)
+			g.Assert(out).Equal([]string{"+ echo before", "before", "+ echo main", "main"})
+		})
+
+		g.It("should append the after script", func() {
+			out, code := runScript(&yaml.Container{
+				Commands:    []string{"echo main"},
+				AfterScript: []string{"echo after"},
+			})
+			g.Assert(code).Equal(0)
+			g.Assert(out).Equal([]string{"+ echo main", "main", "+ echo after", "after"})
+		})
+
+		g.It("should run the after script when commands fail", func() {
+			out, code := runScript(&yaml.Container{
+				BeforeScript: []string{"echo before"},
+				Commands:     []string{"exit 3", "echo unreachable"},
+				AfterScript:  []string{"echo after"},
+			})
+			g.Assert(code).Equal(3)
+			g.Assert(out).Equal([]string{"+ echo before", "before", "+ exit 3", "+ echo after", "after"})
+		})
+
+		g.It("should run the on success commands when commands succeed", func() {
+			out, code := runScript(&yaml.Container{
+				Commands:  []string{"echo main"},
+				OnSuccess: []string{"echo success"},
+				OnFailure: []string{"echo failure"},
+			})
+			g.Assert(code).Equal(0)
+			g.Assert(out).Equal([]string{"+ echo main", "main", "+ echo success", "success"})
+		})
+
+		g.It("should run the on failure commands when commands fail", func() {
+			out, code := runScript(&yaml.Container{
+				Commands:    []string{"exit 3", "echo unreachable"},
+				OnSuccess:   []string{"echo success"},
+				OnFailure:   []string{"echo failure", "exit 1"},
+				AfterScript: []string{"echo after"},
+			})
+			g.Assert(code).Equal(3)
+			g.Assert(out).Equal([]string{"+ exit 3", "+ echo failure", "failure", "+ exit 1", "+ echo after", "after"})
+		})
+
+		g.It("should fail the step when the on success commands fail", func() {
+			out, code := runScript(&yaml.Container{
+				Commands:    []string{"echo main"},
+				OnSuccess:   []string{"exit 2", "echo unreachable"},
+				AfterScript: []string{"echo after"},
+			})
+			g.Assert(code).Equal(2)
+			g.Assert(out).Equal([]string{"+ echo main", "main", "+ exit 2", "+ echo after", "after"})
+		})
 	})
 }
+
+// runScript is a helper function that generates and executes the step script
+// using the same shell invocation as the step Command, returning the output
+// lines and the exit code.
+func runScript(c *yaml.Container) ([]string, int) {
+	script, _ := base64.StdEncoding.DecodeString(toScript(c))
+
+	var buf bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-e")
+	cmd.Stdin = bytes.NewReader(script)
+	cmd.Stdout = &buf
+	cmd.Env = []string{"HOME=/tmp"}
+
+	code := 0
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		} else {
+			code = -1
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, code
+}
@@ -20,6 +20,21 @@ func Environ(c *yaml.Config, envs map[string]string) error {
 		if p.Environment == nil {
 			p.Environment = map[string]string{}
 		}
+
+		// variables that reference other variables with the ${NAME} syntax
+		// are expanded when the container is started, since the references
+		// may resolve to the inherited image environment. Other values are
+		// used as is, including values with a $, such as passwords.
+		for k, v := range p.Environment {
+			if !strings.Contains(v, "${") {
+				continue
+			}
+			if p.EnvironRefs == nil {
+				p.EnvironRefs = map[string]string{}
+			}
+			p.EnvironRefs[k] = v
+			delete(p.Environment, k)
+		}
 		for k, v := range envs {
 			if v == "" {
 				continue
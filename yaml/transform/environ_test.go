@@ -23,5 +23,33 @@ func Test_env(t *testing.T) {
 			Environ(c, envs)
 			g.Assert(c.Pipeline[0].Environment["CI"]).Equal("drone")
 		})
+
+		g.It("should defer variables with references", func() {
+			c := newConfig(&yaml.Container{
+				Environment: map[string]string{
+					"GOPATH": "/go",
+					"PATH":   "${GOPATH}/bin:${PATH}",
+				},
+			})
+
+			Environ(c, map[string]string{"CI": "drone"})
+			g.Assert(c.Pipeline[0].Environment["GOPATH"]).Equal("/go")
+			g.Assert(c.Pipeline[0].Environment["PATH"]).Equal("")
+			g.Assert(c.Pipeline[0].EnvironRefs).Equal(map[string]string{"PATH": "${GOPATH}/bin:${PATH}"})
+		})
+
+		g.It("should not defer literal values with a $", func() {
+			c := newConfig(&yaml.Container{
+				Environment: map[string]string{
+					"PASSWORD": "pa$$w0rd$",
+					"PATTERN":  "^v[0-9]+$",
+				},
+			})
+
+			Environ(c, nil)
+			g.Assert(c.Pipeline[0].Environment["PASSWORD"]).Equal("pa$$w0rd$")
+			g.Assert(c.Pipeline[0].Environment["PATTERN"]).Equal("^v[0-9]+$")
+			g.Assert(len(c.Pipeline[0].EnvironRefs)).Equal(0)
+		})
 	})
 }
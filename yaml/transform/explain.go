@@ -0,0 +1,166 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// Change defines a change made to a step of the Yaml configuration by a
+// transform.
+type Change struct {
+	Step      string // step name
+	Transform string // transform name, such as ImageName
+	Text      string // description of the change
+}
+
+func (c *Change) String() string {
+	return fmt.Sprintf("%s: %s by %s", c.Step, c.Text, c.Transform)
+}
+
+// Explanation records the changes the transforms make to the steps of the
+// Yaml configuration, for debugging why a step was altered. A nil
+// Explanation records nothing.
+type Explanation struct {
+	Changes []*Change
+
+	conf  *yaml.Config
+	steps map[*yaml.Container]stepState
+	order []*yaml.Container
+}
+
+// stepState is a snapshot of the step fields altered by the transforms.
+type stepState struct {
+	name         string
+	image        string
+	pull         bool
+	disabled     bool
+	privileged   bool
+	dockerSocket bool
+	entrypoint   []string
+	command      []string
+	volumes      []string
+}
+
+// Explain returns an Explanation that records the changes made to the
+// steps of the Yaml configuration from this point onwards.
+func Explain(c *yaml.Config) *Explanation {
+	e := &Explanation{conf: c}
+	e.steps, e.order = e.snapshot()
+	return e
+}
+
+// Record records the changes made to the steps since the previous call by
+// the named transform.
+func (e *Explanation) Record(transform string) {
+	if e == nil {
+		return
+	}
+	steps, order := e.snapshot()
+	for _, c := range order {
+		after := steps[c]
+		before, ok := e.steps[c]
+		if !ok {
+			e.add(after.name, transform, "step added")
+			continue
+		}
+		for _, text := range diff(before, after) {
+			e.add(after.name, transform, text)
+		}
+	}
+	for _, c := range e.order {
+		if _, ok := steps[c]; !ok {
+			e.add(e.steps[c].name, transform, "step removed")
+		}
+	}
+	e.steps, e.order = steps, order
+}
+
+func (e *Explanation) add(name, transform, text string) {
+	e.Changes = append(e.Changes, &Change{Step: name, Transform: transform, Text: text})
+}
+
+func (e *Explanation) snapshot() (map[*yaml.Container]stepState, []*yaml.Container) {
+	var order []*yaml.Container
+	order = append(order, e.conf.Services...)
+	order = append(order, e.conf.Pipeline...)
+
+	steps := map[*yaml.Container]stepState{}
+	for _, c := range order {
+		steps[c] = stepState{
+			name:         c.Name,
+			image:        c.Image,
+			pull:         c.Pull,
+			disabled:     c.Disabled,
+			privileged:   c.Privileged,
+			dockerSocket: c.DockerSocket,
+			entrypoint:   append([]string(nil), c.Entrypoint...),
+			command:      append([]string(nil), c.Command...),
+			volumes:      append([]string(nil), c.Volumes...),
+		}
+	}
+	return steps, order
+}
+
+// diff returns the descriptions of the changes between the step snapshots.
+func diff(before, after stepState) []string {
+	var changes []string
+	if before.image != after.image {
+		changes = append(changes, fmt.Sprintf("image changed from %s to %s", before.image, after.image))
+	}
+	changes = appendFlag(changes, "pull", before.pull, after.pull)
+	if !before.disabled && after.disabled {
+		changes = append(changes, "step disabled")
+	}
+	changes = appendFlag(changes, "privileged", before.privileged, after.privileged)
+	changes = appendFlag(changes, "docker socket", before.dockerSocket, after.dockerSocket)
+	if !equal(before.entrypoint, after.entrypoint) {
+		changes = append(changes, fmt.Sprintf("entrypoint changed to [%s]", strings.Join(after.entrypoint, " ")))
+	}
+	if !equal(before.command, after.command) {
+		changes = append(changes, "command changed")
+	}
+	for _, volume := range after.volumes {
+		if !contains(before.volumes, volume) {
+			changes = append(changes, fmt.Sprintf("volume %s added", volume))
+		}
+	}
+	for _, volume := range before.volumes {
+		if !contains(after.volumes, volume) {
+			changes = append(changes, fmt.Sprintf("volume %s removed", volume))
+		}
+	}
+	return changes
+}
+
+func appendFlag(changes []string, name string, before, after bool) []string {
+	switch {
+	case !before && after:
+		return append(changes, name+" enabled")
+	case before && !after:
+		return append(changes, name+" removed")
+	}
+	return changes
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
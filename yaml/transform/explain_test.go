@@ -0,0 +1,72 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+
+	"github.com/franela/goblin"
+)
+
+func TestExplain(t *testing.T) {
+	g := goblin.Goblin(t)
+	g.Describe("explain transforms", func() {
+
+		g.It("should record image normalization", func() {
+			c := newConfig(&yaml.Container{Name: "test", Image: "golang"})
+
+			x := Explain(c)
+			ImageTag(c)
+			x.Record("ImageTag")
+			ImageName(c)
+			x.Record("ImageName")
+			g.Assert(len(x.Changes)).Equal(1)
+			g.Assert(x.Changes[0].String()).Equal("test: image changed from golang to golang:latest by ImageTag")
+		})
+
+		g.It("should record sanitized steps", func() {
+			c := &yaml.Config{
+				Pipeline: []*yaml.Container{
+					{Name: "docker", Image: "plugins/docker"},
+					{Name: "slack", Image: "plugins/slack"},
+				},
+			}
+
+			x := Explain(c)
+			ImageEscalate(c, []string{"plugins/docker"})
+			x.Record("ImageEscalate")
+			PluginDisable(c, []string{"docker"})
+			x.Record("PluginDisable")
+			g.Assert(len(x.Changes)).Equal(2)
+			g.Assert(x.Changes[0].String()).Equal("docker: privileged enabled by ImageEscalate")
+			g.Assert(x.Changes[1].String()).Equal("slack: step disabled by PluginDisable")
+		})
+
+		g.It("should record added and removed steps", func() {
+			c := newConfig(&yaml.Container{Name: "test"})
+
+			x := Explain(c)
+			Clone(c, "git")
+			x.Record("Clone")
+			c.Pipeline = c.Pipeline[1:]
+			x.Record("NodeDisable")
+			g.Assert(len(x.Changes)).Equal(2)
+			g.Assert(x.Changes[0].String()).Equal("clone: step added by Clone")
+			g.Assert(x.Changes[1].String()).Equal("clone: step removed by NodeDisable")
+		})
+
+		g.It("should not record unchanged steps", func() {
+			c := newConfig(&yaml.Container{Name: "test", Image: "golang:1.5"})
+
+			x := Explain(c)
+			ImageTag(c)
+			x.Record("ImageTag")
+			g.Assert(len(x.Changes)).Equal(0)
+		})
+
+		g.It("should ignore a nil explanation", func() {
+			var x *Explanation
+			x.Record("ImageTag")
+		})
+	})
+}
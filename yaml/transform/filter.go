@@ -22,6 +22,37 @@ func ChangeFilter(conf *yaml.Config, prev string) {
 	}
 }
 
+// RepoFilter is a transform function that disables steps and services with
+// repository constraints that do not match the repository full name. This
+// allows shared configurations to gate steps to specific repositories.
+func RepoFilter(conf *yaml.Config, repo string) {
+	var containers []*yaml.Container
+	containers = append(containers, conf.Services...)
+	containers = append(containers, conf.Pipeline...)
+	for _, c := range containers {
+		if !c.Constraints.Repo.Match(repo) {
+			c.Disabled = true
+		}
+	}
+}
+
+// TargetFilter is a transform function that disables steps and services with
+// target branch constraints that do not match the target branch of the pull
+// request. Builds that are not pull requests are not filtered.
+func TargetFilter(conf *yaml.Config, event, target string) {
+	if event != drone.EventPull {
+		return
+	}
+	var containers []*yaml.Container
+	containers = append(containers, conf.Services...)
+	containers = append(containers, conf.Pipeline...)
+	for _, c := range containers {
+		if !c.Constraints.TargetBranch.Match(target) {
+			c.Disabled = true
+		}
+	}
+}
+
 // DefaultFilter is a transform function that applies default Filters to each
 // step in the Yaml specification file.
 func DefaultFilter(conf *yaml.Config) {
@@ -31,11 +62,20 @@ func DefaultFilter(conf *yaml.Config) {
 	}
 }
 
-// defaultStatus sets default status conditions.
+// defaultStatus sets default status conditions. Steps with dependencies run
+// regardless of the build status, since the dependencies are evaluated when
+// the step runs.
 func defaultStatus(c *yaml.Container) {
 	if !isEmpty(c.Constraints.Status) {
 		return
 	}
+	if len(c.DependsOn) != 0 {
+		c.Constraints.Status.Include = []string{
+			drone.StatusSuccess,
+			drone.StatusFailure,
+		}
+		return
+	}
 	c.Constraints.Status.Include = []string{
 		drone.StatusSuccess,
 	}
@@ -61,9 +101,17 @@ func isEmpty(c yaml.Constraint) bool {
 
 // helper function returns true if the step is a plugin step.
 func isPlugin(c *yaml.Container) bool {
+	if isImageBuild(c) {
+		return false
+	}
 	return len(c.Commands) == 0 || len(c.Vargs) != 0
 }
 
+// helper function returns true if the step is an image build step.
+func isImageBuild(c *yaml.Container) bool {
+	return c.ImageBuild != nil
+}
+
 // helper function returns true if the step is a command step.
 func isCommand(c *yaml.Container) bool {
 	return len(c.Commands) != 0
@@ -0,0 +1,103 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/drone/drone-go/drone"
+
+	"github.com/franela/goblin"
+)
+
+func Test_filter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("repo filter", func() {
+
+		g.It("should run steps without repo constraints", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+
+		g.It("should run steps matching the repo", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.Repo.Include = []string{"octocat/hello-world"}
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+
+		g.It("should run steps matching the repo pattern", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.Repo.Include = []string{"octocat/*"}
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+
+		g.It("should skip steps not matching the repo", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.Repo.Include = []string{"drone/*"}
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Pipeline[0].Disabled).IsTrue()
+		})
+
+		g.It("should skip steps excluding the repo", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.Repo.Exclude = []string{"octocat/hello-world"}
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Pipeline[0].Disabled).IsTrue()
+		})
+
+		g.It("should skip services not matching the repo", func() {
+			c := newConfigService(&yaml.Container{Name: "database"})
+			c.Services[0].Constraints.Repo.Include = []string{"drone/drone"}
+			RepoFilter(c, "octocat/hello-world")
+			g.Assert(c.Services[0].Disabled).IsTrue()
+		})
+	})
+
+	g.Describe("default filter", func() {
+
+		g.It("should run steps on success by default", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			DefaultFilter(c)
+			g.Assert(c.Pipeline[0].Constraints.Status.Include).Equal([]string{drone.StatusSuccess})
+		})
+
+		g.It("should run steps with dependencies regardless of status", func() {
+			c := newConfig(&yaml.Container{
+				Name:      "cleanup",
+				DependsOn: yaml.Dependencies{"build": yaml.DependAlways},
+			})
+			DefaultFilter(c)
+			g.Assert(c.Pipeline[0].Constraints.Status.Include).Equal([]string{
+				drone.StatusSuccess,
+				drone.StatusFailure,
+			})
+		})
+	})
+
+	g.Describe("target branch filter", func() {
+
+		g.It("should run pull request steps targeting a matching branch", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.TargetBranch.Include = []string{"master"}
+			TargetFilter(c, drone.EventPull, "master")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+
+		g.It("should skip pull request steps targeting another branch", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.TargetBranch.Include = []string{"master"}
+			TargetFilter(c, drone.EventPull, "develop")
+			g.Assert(c.Pipeline[0].Disabled).IsTrue()
+		})
+
+		g.It("should ignore the target branch for other events", func() {
+			c := newConfig(&yaml.Container{Name: "deploy"})
+			c.Pipeline[0].Constraints.TargetBranch.Include = []string{"master"}
+			TargetFilter(c, drone.EventPush, "develop")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+	})
+}
@@ -28,3 +28,17 @@ func Identifier(c *yaml.Config) error {
 
 	return nil
 }
+
+// StepIdentifier transforms the container steps in the Yaml and assigns a
+// step identifier derived from the step type, position and name. Unlike the
+// container identifier, the step identifier is stable across builds of the
+// same Yaml configuration, and can be used to correlate steps between builds.
+func StepIdentifier(c *yaml.Config) error {
+	for i, step := range c.Services {
+		step.Step = fmt.Sprintf("service_%d_%s", i, step.Name)
+	}
+	for i, step := range c.Pipeline {
+		step.Step = fmt.Sprintf("pipeline_%d_%s", i, step.Name)
+	}
+	return nil
+}
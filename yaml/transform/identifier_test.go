@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+
+	"github.com/franela/goblin"
+)
+
+func Test_identifier(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("step identifier", func() {
+
+		load := func() *yaml.Config {
+			c, err := yaml.ParseString(sampleIdentifierYaml)
+			if err != nil {
+				g.Fail(err)
+			}
+			Clone(c, "git")
+			Identifier(c)
+			StepIdentifier(c)
+			return c
+		}
+
+		g.It("should assign identifiers from the type, position and name", func() {
+			c := load()
+			g.Assert(c.Services[0].Step).Equal("service_0_database")
+			g.Assert(c.Pipeline[0].Step).Equal("pipeline_0_clone")
+			g.Assert(c.Pipeline[1].Step).Equal("pipeline_1_test")
+			g.Assert(c.Pipeline[2].Step).Equal("pipeline_2_notify")
+		})
+
+		g.It("should assign identical identifiers across loads", func() {
+			a, b := load(), load()
+			for i := range a.Pipeline {
+				g.Assert(a.Pipeline[i].Step).Equal(b.Pipeline[i].Step)
+			}
+			for i := range a.Services {
+				g.Assert(a.Services[i].Step).Equal(b.Services[i].Step)
+			}
+
+			// container identifiers remain unique to each build.
+			g.Assert(a.Pipeline[0].ID == b.Pipeline[0].ID).IsFalse()
+		})
+	})
+}
+
+var sampleIdentifierYaml = `
+pipeline:
+  test:
+    image: golang
+    commands:
+      - go test
+  notify:
+    image: slack
+services:
+  database:
+    image: postgres
+`
@@ -1,12 +1,34 @@
 package transform
 
 import (
+	"fmt"
 	"path/filepath"
 	"strings"
 
 	"github.com/drone/drone-exec/yaml"
+	"github.com/gorilla/securecookie"
 )
 
+// ImageDefault transforms the Yaml to run command steps without an image
+// using the build image defined in the Yaml, or the default image if the
+// Yaml does not define a build image. An error is returned if a command step
+// has no image to run in.
+func ImageDefault(conf *yaml.Config, image string) error {
+	if conf.Image != "" {
+		image = conf.Image
+	}
+	for _, c := range conf.Pipeline {
+		if c.Image != "" || len(c.Commands) == 0 {
+			continue
+		}
+		if image == "" {
+			return fmt.Errorf("Cannot run step %s without an image", c.Name)
+		}
+		c.Image = image
+	}
+	return nil
+}
+
 // ImagePull transforms the Yaml to automatically pull the latest image.
 func ImagePull(conf *yaml.Config, pull bool) error {
 	for _, plugin := range conf.Pipeline {
@@ -21,21 +43,40 @@ func ImagePull(conf *yaml.Config, pull bool) error {
 // ImageTag transforms the Yaml to use the :latest image tag when empty.
 func ImageTag(conf *yaml.Config) error {
 	for _, image := range conf.Pipeline {
-		if !strings.Contains(image.Image, ":") {
+		if !imageHasTag(image.Image) {
 			image.Image = image.Image + ":latest"
 		}
 	}
 	for _, image := range conf.Services {
-		if !strings.Contains(image.Image, ":") {
+		if !imageHasTag(image.Image) {
 			image.Image = image.Image + ":latest"
 		}
 	}
 	return nil
 }
 
-// ImageName transforms the Yaml to replace underscores with dashes.
+// ImageStrict returns an error if an image of the Yaml does not define an
+// explicit tag or digest, instead of defaulting to the :latest tag.
+func ImageStrict(conf *yaml.Config) error {
+	var images []*yaml.Container
+	images = append(images, conf.Services...)
+	images = append(images, conf.Pipeline...)
+
+	for _, image := range images {
+		if !imageHasTag(image.Image) {
+			return fmt.Errorf("Cannot use image %s of step %s, strict images require a tag or digest", image.Image, image.Name)
+		}
+	}
+	return nil
+}
+
+// ImageName transforms the Yaml to replace underscores with dashes. Images
+// from a non-default registry are not modified.
 func ImageName(conf *yaml.Config) error {
 	for _, image := range conf.Pipeline {
+		if imageRegistry(image.Image) != "" {
+			continue
+		}
 		image.Image = strings.Replace(image.Image, "_", "-", -1)
 	}
 	return nil
@@ -55,6 +96,57 @@ func ImageNamespace(conf *yaml.Config, namespace string) error {
 	return nil
 }
 
+// ImageDigest transforms the Yaml to pin the images to the digests defined
+// for each image reference, such as the digests of an image lockfile. Images
+// without a digest are not modified.
+func ImageDigest(conf *yaml.Config, digests map[string]string) error {
+	var images []*yaml.Container
+	images = append(images, conf.Pipeline...)
+	images = append(images, conf.Services...)
+
+	for _, image := range images {
+		digest, ok := digests[image.Image]
+		if !ok || strings.Contains(image.Image, "@") {
+			continue
+		}
+		image.Image = imageRepo(image.Image) + "@" + digest
+	}
+	return nil
+}
+
+// ImageBuildScope transforms the Yaml to tag the images built by the image
+// build steps with a name scoped to the build, and rewrites the steps that
+// reference the built images. This prevents untrusted builds from replacing
+// the images of other builds on a shared daemon. The scoped images only exist
+// on the daemon, and are never pulled. This transform must run after the
+// image names are normalized, and before the images are escalated.
+func ImageBuildScope(conf *yaml.Config) error {
+	scope := fmt.Sprintf("drone_%x", securecookie.GenerateRandomKey(8))
+
+	var images []*yaml.Container
+	images = append(images, conf.Pipeline...)
+	images = append(images, conf.Services...)
+
+	for _, c := range conf.Pipeline {
+		if c.ImageBuild == nil || strings.Contains(c.Image, "@") {
+			continue
+		}
+		image := c.Image
+		if registry := imageRegistry(image); registry != "" {
+			image = image[len(registry)+1:]
+		}
+		scoped := scope + "/" + strings.ToLower(image)
+		for _, ref := range images {
+			if ref != c && ref.Image == c.Image {
+				ref.Image = scoped
+				ref.Pull = false
+			}
+		}
+		c.Image = scoped
+	}
+	return nil
+}
+
 // ImageEscalate transforms the Yaml to automatically enable privileged mode
 // for a subset of white-listed plugins matching the given patterns.
 func ImageEscalate(conf *yaml.Config, patterns []string) error {
@@ -67,3 +159,37 @@ func ImageEscalate(conf *yaml.Config, patterns []string) error {
 	}
 	return nil
 }
+
+// imageRegistry returns the registry hostname, including the port, of the
+// image reference. An empty string is returned for the default registry.
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return ""
+	}
+	host := image[:i]
+	if host == "localhost" || strings.ContainsAny(host, ".:") {
+		return host
+	}
+	return ""
+}
+
+// imageRepo returns the image reference without the tag. The registry port is
+// not mistaken for the tag.
+func imageRepo(image string) string {
+	i := strings.LastIndex(image, "/") + 1
+	if j := strings.Index(image[i:], ":"); j != -1 {
+		return image[:i+j]
+	}
+	return image
+}
+
+// imageHasTag returns true if the image reference includes a tag or digest.
+// The registry port is not mistaken for the tag.
+func imageHasTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return true
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	return strings.Contains(name, ":")
+}
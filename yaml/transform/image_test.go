@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/drone/drone-exec/yaml"
@@ -49,6 +50,45 @@ func Test_pull(t *testing.T) {
 	})
 }
 
+func Test_default(t *testing.T) {
+	g := goblin.Goblin(t)
+	g.Describe("default image", func() {
+
+		g.It("should use the default image for command steps", func() {
+			c := newConfig(&yaml.Container{Name: "build", Commands: []string{"make"}})
+
+			err := ImageDefault(c, "alpine:3.4")
+			g.Assert(err == nil).IsTrue()
+			g.Assert(c.Pipeline[0].Image).Equal("alpine:3.4")
+		})
+
+		g.It("should prefer the yaml build image", func() {
+			c := newConfig(&yaml.Container{Name: "build", Commands: []string{"make"}})
+			c.Image = "golang:1.6"
+
+			err := ImageDefault(c, "alpine:3.4")
+			g.Assert(err == nil).IsTrue()
+			g.Assert(c.Pipeline[0].Image).Equal("golang:1.6")
+		})
+
+		g.It("should not override the step image", func() {
+			c := newConfig(&yaml.Container{Name: "build", Image: "node:6", Commands: []string{"npm test"}})
+
+			err := ImageDefault(c, "alpine:3.4")
+			g.Assert(err == nil).IsTrue()
+			g.Assert(c.Pipeline[0].Image).Equal("node:6")
+		})
+
+		g.It("should error when there is no default image", func() {
+			c := newConfig(&yaml.Container{Name: "build", Commands: []string{"make"}})
+
+			err := ImageDefault(c, "")
+			g.Assert(err != nil).IsTrue()
+			g.Assert(err.Error()).Equal("Cannot run step build without an image")
+		})
+	})
+}
+
 func Test_escalate(t *testing.T) {
 
 	g := goblin.Goblin(t)
@@ -116,6 +156,78 @@ func Test_normalize(t *testing.T) {
 				ImageTag(c)
 				g.Assert(c.Pipeline[0].Image).Equal("golang:1.5")
 			})
+
+			g.It("should append tag to registry with port", func() {
+				c := newConfig(&yaml.Container{
+					Image: "registry.internal:5000/team/image",
+				})
+				ImageTag(c)
+				g.Assert(c.Pipeline[0].Image).Equal("registry.internal:5000/team/image:latest")
+			})
+
+			g.It("should not override tag with registry port", func() {
+				c := newConfig(&yaml.Container{
+					Image: "registry.internal:5000/team/image:1.0",
+				})
+				ImageTag(c)
+				g.Assert(c.Pipeline[0].Image).Equal("registry.internal:5000/team/image:1.0")
+			})
+
+			g.It("should append tag to multi-segment paths", func() {
+				c := newConfigService(&yaml.Container{
+					Image: "gcr.io/project/group/image",
+				})
+				ImageTag(c)
+				g.Assert(c.Services[0].Image).Equal("gcr.io/project/group/image:latest")
+			})
+
+			g.It("should not append tag to digests", func() {
+				c := newConfig(&yaml.Container{
+					Image: "localhost:5000/image@sha256:0123456789abcdef",
+				})
+				ImageTag(c)
+				g.Assert(c.Pipeline[0].Image).Equal("localhost:5000/image@sha256:0123456789abcdef")
+			})
+		})
+
+		g.Describe("strict images", func() {
+
+			g.It("should reject images without a tag", func() {
+				c := newConfig(&yaml.Container{
+					Name:  "test",
+					Image: "golang",
+				})
+				err := ImageStrict(c)
+				g.Assert(err.Error()).Equal("Cannot use image golang of step test, strict images require a tag or digest")
+			})
+
+			g.It("should reject services without a tag", func() {
+				c := newConfigService(&yaml.Container{
+					Name:  "database",
+					Image: "registry.internal:5000/postgres",
+				})
+				g.Assert(ImageStrict(c) != nil).IsTrue()
+			})
+
+			g.It("should allow images with a tag or digest", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "test", Image: "golang:1.5"},
+						{Name: "build", Image: "registry.internal:5000/team/image:1.0"},
+						{Name: "deploy", Image: "plugins/docker@sha256:0123456789abcdef"},
+					},
+				}
+				g.Assert(ImageStrict(c) == nil).IsTrue()
+			})
+
+			g.It("should allow images without a tag by default", func() {
+				c := newConfig(&yaml.Container{
+					Name:  "test",
+					Image: "golang",
+				})
+				g.Assert(ImageTag(c) == nil).IsTrue()
+				g.Assert(c.Pipeline[0].Image).Equal("golang:latest")
+			})
 		})
 
 		g.Describe("plugins", func() {
@@ -146,6 +258,94 @@ func Test_normalize(t *testing.T) {
 				ImageName(c)
 				g.Assert(c.Pipeline[0].Image).Equal("gh-pages")
 			})
+
+			g.It("should not mangle images from a registry", func() {
+				c := newConfig(&yaml.Container{
+					Image: "registry.internal:5000/team/my_image:1.0",
+				})
+				ImageName(c)
+				ImageNamespace(c, "plugins")
+				ImageTag(c)
+				g.Assert(c.Pipeline[0].Image).Equal("registry.internal:5000/team/my_image:1.0")
+			})
+
+			g.It("should recognize registry hostnames", func() {
+				g.Assert(imageRegistry("registry.internal:5000/team/image")).Equal("registry.internal:5000")
+				g.Assert(imageRegistry("localhost/image")).Equal("localhost")
+				g.Assert(imageRegistry("gcr.io/project/image")).Equal("gcr.io")
+				g.Assert(imageRegistry("octocat/image")).Equal("")
+				g.Assert(imageRegistry("golang:1.5")).Equal("")
+			})
+		})
+
+		g.Describe("digests", func() {
+
+			g.It("should pin images to the locked digests", func() {
+				c := newConfig(&yaml.Container{
+					Image: "golang:1.5",
+				})
+				c.Services = append(c.Services, &yaml.Container{
+					Image: "registry.internal:5000/redis:3",
+				})
+				ImageDigest(c, map[string]string{
+					"golang:1.5":                     "sha256:0123456789abcdef",
+					"registry.internal:5000/redis:3": "sha256:fedcba9876543210",
+				})
+				g.Assert(c.Pipeline[0].Image).Equal("golang@sha256:0123456789abcdef")
+				g.Assert(c.Services[0].Image).Equal("registry.internal:5000/redis@sha256:fedcba9876543210")
+			})
+
+			g.It("should not pin images without a locked digest", func() {
+				c := newConfig(&yaml.Container{
+					Image: "golang:1.6",
+				})
+				ImageDigest(c, map[string]string{"golang:1.5": "sha256:0123456789abcdef"})
+				g.Assert(c.Pipeline[0].Image).Equal("golang:1.6")
+			})
+
+			g.It("should strip the tag but not the registry port", func() {
+				g.Assert(imageRepo("localhost:5000/image:1.0")).Equal("localhost:5000/image")
+				g.Assert(imageRepo("localhost:5000/image")).Equal("localhost:5000/image")
+				g.Assert(imageRepo("golang:1.5")).Equal("golang")
+			})
+		})
+
+		g.Describe("image build scope", func() {
+
+			g.It("should scope the built images to the build", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "build", Image: "plugins/docker:latest", ImageBuild: &yaml.ImageBuild{Context: "."}},
+						{Name: "publish", Image: "plugins/docker:latest", Pull: true},
+						{Name: "test", Image: "golang:1.5"},
+					},
+				}
+				ImageBuildScope(c)
+				scoped := c.Pipeline[0].Image
+				g.Assert(strings.HasPrefix(scoped, "drone_")).IsTrue()
+				g.Assert(strings.HasSuffix(scoped, "/plugins/docker:latest")).IsTrue()
+				g.Assert(c.Pipeline[1].Image).Equal(scoped)
+				g.Assert(c.Pipeline[1].Pull).IsFalse()
+				g.Assert(c.Pipeline[2].Image).Equal("golang:1.5")
+			})
+
+			g.It("should strip the registry of the built images", func() {
+				c := newConfig(&yaml.Container{
+					Image:      "registry.internal:5000/Octocat/app:1.0",
+					ImageBuild: &yaml.ImageBuild{Context: "."},
+				})
+				ImageBuildScope(c)
+				g.Assert(strings.HasSuffix(c.Pipeline[0].Image, "/octocat/app:1.0")).IsTrue()
+				g.Assert(strings.Contains(c.Pipeline[0].Image, "registry.internal")).IsFalse()
+			})
+
+			g.It("should use a different scope for each build", func() {
+				a := newConfig(&yaml.Container{Image: "golang:1.5", ImageBuild: &yaml.ImageBuild{}})
+				b := newConfig(&yaml.Container{Image: "golang:1.5", ImageBuild: &yaml.ImageBuild{}})
+				ImageBuildScope(a)
+				ImageBuildScope(b)
+				g.Assert(a.Pipeline[0].Image == b.Pipeline[0].Image).IsFalse()
+			})
 		})
 	})
 }
@@ -0,0 +1,43 @@
+package transform
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/gorilla/securecookie"
+)
+
+// mtuOption is the bridge network driver option that sets the MTU.
+const mtuOption = "com.docker.network.driver.mtu"
+
+// Network transforms the Yaml to attach the containers that do not join the
+// network of another container, typically the pod ambassador, to a per-build
+// bridge network with the given MTU. The Docker default network is used when
+// the MTU is not set. This transform must run after the Pod transform.
+func Network(c *yaml.Config, mtu int) error {
+	if mtu <= 0 {
+		return nil
+	}
+
+	rand := base64.RawURLEncoding.EncodeToString(
+		securecookie.GenerateRandomKey(8),
+	)
+	network := &yaml.Network{
+		Name:       fmt.Sprintf("drone_network_%s", rand),
+		Driver:     "bridge",
+		DriverOpts: map[string]string{mtuOption: strconv.Itoa(mtu)},
+	}
+
+	var containers []*yaml.Container
+	containers = append(containers, c.Services...)
+	containers = append(containers, c.Pipeline...)
+	for _, container := range containers {
+		if container.Network == "" {
+			container.Network = network.Name
+		}
+	}
+	c.Networks = append(c.Networks, network)
+	return nil
+}
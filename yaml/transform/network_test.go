@@ -0,0 +1,43 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+
+	"github.com/franela/goblin"
+)
+
+func Test_network(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("network transform", func() {
+
+		g.It("should attach the pod to a network with the mtu", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Pipeline:  []*yaml.Container{{Name: "build"}},
+				Services:  []*yaml.Container{{Name: "postgres"}},
+			}
+			Pod(c, "")
+			Network(c, 1450)
+
+			g.Assert(len(c.Networks)).Equal(1)
+			network := c.Networks[0]
+			g.Assert(network.Driver).Equal("bridge")
+			g.Assert(network.DriverOpts).Equal(map[string]string{"com.docker.network.driver.mtu": "1450"})
+
+			ambassador := c.Services[0]
+			g.Assert(ambassador.Network).Equal(network.Name)
+			g.Assert(c.Services[1].Network).Equal("container:" + ambassador.ID)
+			g.Assert(c.Pipeline[0].Network).Equal("container:" + ambassador.ID)
+		})
+
+		g.It("should use the default network when the mtu is not set", func() {
+			c := newConfig(&yaml.Container{Name: "build"})
+			Network(c, 0)
+			g.Assert(len(c.Networks)).Equal(0)
+			g.Assert(c.Pipeline[0].Network).Equal("")
+		})
+	})
+}
@@ -0,0 +1,46 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/drone/drone-exec/yaml"
+)
+
+// NodeDisable transforms the Yaml to disable the node types, which are the
+// node types disabled by the agent and the node types disabled in the Yaml.
+// A node runs only if neither disables it. This transform must run before
+// the Clone and Cache transforms. An error is returned for unknown node
+// types.
+func NodeDisable(c *yaml.Config, nodes []string) error {
+	// copy the node types of the agent, since appending to them could
+	// overwrite the backing array shared with other builds.
+	for _, node := range append(append([]string(nil), nodes...), c.Disable...) {
+		switch node {
+		case yaml.NodeClone:
+			if c.Clone == nil {
+				c.Clone = &yaml.Clone{}
+			}
+			c.Clone.Disable = true
+			for _, p := range c.Pipeline {
+				if isClone(p) {
+					p.Disabled = true
+				}
+			}
+		case yaml.NodeCache:
+			c.Cache = nil
+		case yaml.NodeServices:
+			for _, s := range c.Services {
+				s.Disabled = true
+			}
+		case yaml.NodePlugins:
+			for _, p := range c.Pipeline {
+				if isPlugin(p) && !isClone(p) {
+					p.Disabled = true
+				}
+			}
+		default:
+			return fmt.Errorf("Cannot disable unknown node %s", node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+	"github.com/franela/goblin"
+)
+
+func Test_node(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("node disable", func() {
+
+		var c *yaml.Config
+		g.BeforeEach(func() {
+			c = &yaml.Config{
+				Cache: &yaml.Cache{Mount: []string{"node_modules"}},
+				Pipeline: []*yaml.Container{
+					{Name: "test", Image: "golang", Commands: []string{"go test"}},
+					{Name: "notify", Image: "slack"},
+				},
+				Services: []*yaml.Container{{Name: "database", Image: "mysql"}},
+			}
+		})
+
+		g.It("should disable the node types of the yaml", func() {
+			c.Disable = []string{"plugins", "services"}
+			NodeDisable(c, nil)
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+			g.Assert(c.Pipeline[1].Disabled).IsTrue()
+			g.Assert(c.Services[0].Disabled).IsTrue()
+		})
+
+		g.It("should disable the node types of the agent", func() {
+			NodeDisable(c, []string{"cache"})
+			g.Assert(c.Cache == nil).IsTrue()
+			g.Assert(c.Pipeline[1].Disabled).IsFalse()
+		})
+
+		g.It("should disable a node disabled in the yaml but enabled by the agent", func() {
+			c.Disable = []string{"clone"}
+			NodeDisable(c, []string{"cache"})
+			Clone(c, "git")
+			g.Assert(c.Pipeline[0].Name).Equal("test")
+			g.Assert(c.Cache == nil).IsTrue()
+		})
+
+		g.It("should not disable the clone step with plugins", func() {
+			Clone(c, "git")
+			c.Disable = []string{"plugins"}
+			NodeDisable(c, nil)
+			g.Assert(c.Pipeline[0].Name).Equal("clone")
+			g.Assert(c.Pipeline[0].Disabled).IsFalse()
+		})
+
+		g.It("should not modify the node types of the agent", func() {
+			nodes := make([]string, 1, 2)
+			nodes[0] = "cache"
+			c.Disable = []string{"clone"}
+			NodeDisable(c, nodes)
+			g.Assert(nodes[:2][1]).Equal("")
+		})
+
+		g.It("should error on unknown node types", func() {
+			c.Disable = []string{"notify"}
+			err := NodeDisable(c, nil)
+			g.Assert(err.Error()).Equal("Cannot disable unknown node notify")
+		})
+	})
+}
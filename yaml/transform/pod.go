@@ -3,15 +3,24 @@ package transform
 import (
 	"encoding/base64"
 	"fmt"
+	"strings"
 
 	"github.com/drone/drone-exec/yaml"
 
 	"github.com/gorilla/securecookie"
 )
 
+// ambassadorImage is the default image used for the ambassador container. The
+// image must include a shell and standard unix utilities.
+const ambassadorImage = "busybox:latest"
+
 // Pod transforms the containers in the Yaml to use Pod networking, where every
-// container shares the localhost connection.
-func Pod(c *yaml.Config) error {
+// container shares the localhost connection. The ambassador container uses
+// the default image when no image is provided.
+func Pod(c *yaml.Config, image string) error {
+	if image == "" {
+		image = ambassadorImage
+	}
 
 	rand := base64.RawURLEncoding.EncodeToString(
 		securecookie.GenerateRandomKey(8),
@@ -20,7 +29,7 @@ func Pod(c *yaml.Config) error {
 	ambassador := &yaml.Container{
 		ID:          fmt.Sprintf("drone_ambassador_%s", rand),
 		Name:        "ambassador",
-		Image:       "busybox:latest",
+		Image:       image,
 		Detached:    true,
 		Entrypoint:  []string{"/bin/sleep"},
 		Command:     []string{"86400"},
@@ -40,10 +49,63 @@ func Pod(c *yaml.Config) error {
 		}
 	}
 
+	// containers in the pod share the hosts file of the ambassador, which
+	// maps the service aliases to the shared localhost connection.
+	hosts := map[string]bool{}
+	for _, service := range c.Services {
+		if service.Alias == "" || service.Network != network {
+			continue
+		}
+		hosts[service.Alias] = true
+		ambassador.ExtraHosts = append(ambassador.ExtraHosts, service.Alias+":127.0.0.1")
+	}
+
+	// links between containers in the pod are resolved by the hosts file of
+	// the ambassador. Containers outside the pod use docker links instead.
+	for _, container := range containers {
+		var links []string
+		for _, link := range container.Links {
+			name, alias := splitLink(link)
+			service := lookup(c.Services, name)
+			if service == nil {
+				continue
+			}
+			if container.Network != network || service.Network != network {
+				links = append(links, service.ID+":"+alias)
+				continue
+			}
+			if !hosts[alias] {
+				hosts[alias] = true
+				ambassador.ExtraHosts = append(ambassador.ExtraHosts, alias+":127.0.0.1")
+			}
+		}
+		container.Links = links
+	}
+
 	c.Services = append([]*yaml.Container{ambassador}, c.Services...)
 	return nil
 }
 
+// splitLink splits the link into the service name and alias. The alias
+// defaults to the service name.
+func splitLink(link string) (name, alias string) {
+	parts := strings.SplitN(link, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return link, link
+}
+
+// lookup returns the named container, or nil if not found.
+func lookup(containers []*yaml.Container, name string) *yaml.Container {
+	for _, container := range containers {
+		if container.Name == name {
+			return container
+		}
+	}
+	return nil
+}
+
 // func (v *podOp) VisitContainer(node *parse.ContainerNode) error {
 // 	if node.Container.Network == "" {
 // 		parent := fmt.Sprintf("container:%s", v.name)
@@ -0,0 +1,102 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+
+	"github.com/franela/goblin"
+)
+
+func Test_pod(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("pod networking", func() {
+
+		g.It("should join containers to the ambassador network", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Pipeline:  []*yaml.Container{{Name: "build"}},
+				Services:  []*yaml.Container{{Name: "postgres"}},
+			}
+			Pod(c, "")
+			ambassador := c.Services[0]
+			g.Assert(ambassador.Name).Equal("ambassador")
+			g.Assert(c.Pipeline[0].Network).Equal("container:" + ambassador.ID)
+			g.Assert(c.Pipeline[0].VolumesFrom).Equal([]string{ambassador.ID})
+			g.Assert(c.Services[1].Network).Equal("container:" + ambassador.ID)
+		})
+
+		g.It("should use the default ambassador image", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+			}
+			Pod(c, "")
+			g.Assert(c.Services[0].Image).Equal(ambassadorImage)
+		})
+
+		g.It("should use the configured ambassador image", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+			}
+			Pod(c, "registry.internal/library/busybox:1.25")
+			g.Assert(c.Services[0].Image).Equal("registry.internal/library/busybox:1.25")
+		})
+
+		g.It("should register service aliases with the ambassador", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Pipeline:  []*yaml.Container{{Name: "build"}},
+				Services: []*yaml.Container{
+					{Name: "postgres", Alias: "database"},
+					{Name: "redis"},
+				},
+			}
+			Pod(c, "")
+			g.Assert(c.Services[0].ExtraHosts).Equal([]string{"database:127.0.0.1"})
+			g.Assert(len(c.Pipeline[0].ExtraHosts)).Equal(0)
+		})
+
+		g.It("should not register aliases for services outside the pod", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Services: []*yaml.Container{
+					{Name: "postgres", Alias: "database", Network: "bridge"},
+				},
+			}
+			Pod(c, "")
+			g.Assert(len(c.Services[0].ExtraHosts)).Equal(0)
+		})
+
+		g.It("should resolve links in the pod with the ambassador", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Pipeline: []*yaml.Container{
+					{Name: "test", Links: []string{"postgres", "redis:cache"}},
+				},
+				Services: []*yaml.Container{
+					{ID: "drone_postgres", Name: "postgres"},
+					{ID: "drone_redis", Name: "redis"},
+				},
+			}
+			Pod(c, "")
+			g.Assert(c.Services[0].ExtraHosts).Equal([]string{"postgres:127.0.0.1", "cache:127.0.0.1"})
+			g.Assert(len(c.Pipeline[0].Links)).Equal(0)
+		})
+
+		g.It("should use docker links outside the pod", func() {
+			c := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: "/drone/src"},
+				Pipeline: []*yaml.Container{
+					{Name: "test", Network: "bridge", Links: []string{"postgres:database"}},
+				},
+				Services: []*yaml.Container{
+					{ID: "drone_postgres", Name: "postgres", Network: "bridge"},
+				},
+			}
+			Pod(c, "")
+			g.Assert(len(c.Services[0].ExtraHosts)).Equal(0)
+			g.Assert(c.Pipeline[0].Links).Equal([]string{"drone_postgres:database"})
+		})
+	})
+}
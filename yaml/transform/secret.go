@@ -2,6 +2,7 @@ package transform
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/drone/drone-exec/yaml"
 	"github.com/drone/drone-go/drone"
@@ -24,6 +25,19 @@ func imageSecrets(c *yaml.Container, secrets []*drone.Secret, event string) {
 			continue
 		}
 
+		// secrets declared as files are written to the container instead
+		// of the environment, and are never written for pull requests.
+		if path, ok := c.SecretFiles[secret.Name]; ok {
+			if event == drone.EventPull {
+				continue
+			}
+			if c.Files == nil {
+				c.Files = map[string]string{}
+			}
+			c.Files[path] = secret.Value
+			continue
+		}
+
 		switch secret.Name {
 		case "REGISTRY_USERNAME":
 			c.AuthConfig.Username = secret.Value
@@ -66,3 +80,127 @@ func matchEvent(s *drone.Secret, event string) bool {
 	}
 	return false
 }
+
+// BranchSecrets returns the secrets that are not restricted to other branches
+// by the secrets section of the Yaml configuration. The restrictions can only
+// be removed by changing the Yaml configuration, which is then no longer
+// verified, so a branch cannot gain access to a restricted secret. Branch
+// restricted secrets are withheld from pull requests, since the branch name
+// of a pull request is chosen by its author.
+func BranchSecrets(c *yaml.Config, secrets []*drone.Secret, branch, event string) []*drone.Secret {
+	restricted := map[string]*yaml.Secret{}
+	for _, secret := range c.Secrets {
+		restricted[secret.Name] = secret
+	}
+
+	var matched []*drone.Secret
+	for _, secret := range secrets {
+		r, ok := restricted[secret.Name]
+		if ok && !r.Branch.Match(branch) {
+			continue
+		}
+		if ok && event == drone.EventPull && len(r.Branch.Include)+len(r.Branch.Exclude) != 0 {
+			continue
+		}
+		matched = append(matched, secret)
+	}
+	return matched
+}
+
+// VerifiedSecrets returns the secrets if the build is verified, or nil if the
+// Yaml configuration could not be verified against the checksum. The check
+// is skipped if insecure is true, which should only be used for local
+// testing, since it exposes secrets to modified configurations.
+func VerifiedSecrets(secrets []*drone.Secret, verified, insecure bool) []*drone.Secret {
+	if !verified && !insecure {
+		return nil
+	}
+	return secrets
+}
+
+// EmptySecrets returns the names of the secrets without a value, which are
+// typically secrets the server was unable to decrypt.
+func EmptySecrets(secrets []*drone.Secret) []string {
+	var names []string
+	for _, secret := range secrets {
+		if secret.Value == "" {
+			names = append(names, secret.Name)
+		}
+	}
+	return names
+}
+
+// UnusedSecrets returns the names of the secrets that are not used by any
+// container, which typically indicates a typo in the name of the secret or
+// of the variable that references it. A secret is used by a container that
+// matches the secret restrictions if the container is a plugin or service,
+// which read secrets from the environment, declares the secret as a file, or
+// references the secret as $NAME or ${NAME} in the commands or environment.
+// Secrets restricted to other events are not reported. This must run before
+// the CommandTransform, which encodes the commands.
+func UnusedSecrets(c *yaml.Config, secrets []*drone.Secret, event string) []string {
+	var images []*yaml.Container
+	images = append(images, c.Pipeline...)
+	images = append(images, c.Services...)
+
+	var names []string
+	for _, secret := range secrets {
+		if !matchEvent(secret, event) {
+			continue
+		}
+		used := false
+		for _, image := range images {
+			if matchImage(secret, image.Image) && usesSecret(image, secret.Name) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			names = append(names, secret.Name)
+		}
+	}
+	return names
+}
+
+// usesSecret returns true if the container uses the named secret.
+func usesSecret(c *yaml.Container, name string) bool {
+	if len(c.Commands) == 0 || isPlugin(c) {
+		return true
+	}
+	if _, ok := c.SecretFiles[name]; ok {
+		return true
+	}
+	switch name {
+	case "REGISTRY_USERNAME", "REGISTRY_PASSWORD", "REGISTRY_EMAIL":
+		return true
+	}
+	for _, command := range c.Commands {
+		if references(command, name) {
+			return true
+		}
+	}
+	for _, value := range c.Environment {
+		if references(value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// references returns true if the string references the named variable as
+// $NAME or ${NAME}.
+func references(s, name string) bool {
+	for i := strings.Index(s, "$"); i != -1; i = strings.Index(s, "$") {
+		s = s[i+1:]
+		ref := strings.TrimPrefix(s, "{")
+		if strings.HasPrefix(ref, name) && (len(ref) == len(name) || !isNameChar(ref[len(name)])) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNameChar returns true if the character is valid in a variable name.
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -2,27 +2,84 @@ package transform
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/drone/drone-exec/yaml"
 )
 
 func Check(c *yaml.Config, trusted bool) error {
+	var base, workspace string
+	if c.Workspace != nil {
+		base = c.Workspace.Base
+		workspace = c.Workspace.Path
+	}
+
 	var images []*yaml.Container
 	images = append(images, c.Pipeline...)
 	images = append(images, c.Services...)
 
-	for _, image := range c.Pipeline {
+	for _, image := range images {
+		if err := CheckResources(image); err != nil {
+			return err
+		}
+		if err := CheckSysctls(image, trusted); err != nil {
+			return err
+		}
+		if err := CheckName(image); err != nil {
+			return err
+		}
+		if err := CheckAlias(image); err != nil {
+			return err
+		}
+		if err := CheckUser(image); err != nil {
+			return err
+		}
+		if err := CheckLinks(image, c.Services); err != nil {
+			return err
+		}
+		if err := CheckSecretFiles(image, base); err != nil {
+			return err
+		}
+		if err := CheckPlatform(image); err != nil {
+			return err
+		}
+	}
+	for i, image := range c.Pipeline {
+		if err := CheckSuccessOf(image, c.Pipeline[:i]); err != nil {
+			return err
+		}
+		if err := CheckDependsOn(image, c.Pipeline[:i]); err != nil {
+			return err
+		}
 		if err := CheckEntrypoint(image); err != nil {
 			return err
 		}
+		if err := CheckCache(image, workspace); err != nil {
+			return err
+		}
+		if err := CheckExecIn(image, c.Services); err != nil {
+			return err
+		}
+		if image.Alias != "" {
+			return fmt.Errorf("Cannot set alias for pipeline steps")
+		}
 		if trusted {
 			continue
 		}
 		if err := CheckTrusted(image); err != nil {
 			return err
 		}
+		if isClone(image) && skipVerify(image) {
+			return fmt.Errorf("Insufficient privileges to skip clone verification")
+		}
 	}
 	for _, image := range c.Services {
+		if image.ExecIn != "" {
+			return fmt.Errorf("Cannot set exec_in for services")
+		}
 		if trusted {
 			continue
 		}
@@ -33,6 +90,29 @@ func Check(c *yaml.Config, trusted bool) error {
 	return nil
 }
 
+// CheckEscalate returns an error if a pipeline step that is not a plugin
+// matches the patterns of the plugins automatically escalated to privileged
+// mode, unless the repository is trusted. This prevents an untrusted build
+// from gaining privileges by using a build image that matches a broad
+// pattern. This check must run before the ImageEscalate transform, once the
+// image names are normalized.
+func CheckEscalate(c *yaml.Config, patterns []string, trusted bool) error {
+	if trusted {
+		return nil
+	}
+	for _, image := range c.Pipeline {
+		if isPlugin(image) {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, image.Image); ok {
+				return fmt.Errorf("Insufficient privileges to escalate %s, only plugins may be escalated", image.Image)
+			}
+		}
+	}
+	return nil
+}
+
 // validate the plugin command and entrypoint and return an error
 // the user attempts to set or override these values.
 func CheckEntrypoint(c *yaml.Container) error {
@@ -45,12 +125,235 @@ func CheckEntrypoint(c *yaml.Container) error {
 	return nil
 }
 
+// validate the container resource limits and return an error if the limits
+// are outside the bounds accepted by the Docker daemon.
+func CheckResources(c *yaml.Container) error {
+	if c.MemSwappiness != nil && (*c.MemSwappiness < 0 || *c.MemSwappiness > 100) {
+		return fmt.Errorf("Invalid mem_swappiness, must be between 0 and 100")
+	}
+	if c.MemSwapLimit != 0 && c.MemLimit == 0 {
+		return fmt.Errorf("Cannot set memswap_limit without mem_limit")
+	}
+	if c.MemSwapLimit > 0 && c.MemSwapLimit < c.MemLimit {
+		return fmt.Errorf("Invalid memswap_limit, must be greater than mem_limit")
+	}
+	if c.CPUSet != "" && !cpusetRegexp.MatchString(c.CPUSet) {
+		return fmt.Errorf("Invalid cpuset %s", c.CPUSet)
+	}
+	return nil
+}
+
+// validate the success_of constraint and return an error if it references a
+// step that is not defined before the container.
+func CheckSuccessOf(c *yaml.Container, prior []*yaml.Container) error {
+	for _, name := range c.Constraints.SuccessOf.Include {
+		var found bool
+		for _, step := range prior {
+			if step.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Invalid success_of, %s is not a prior step", name)
+		}
+	}
+	return nil
+}
+
+// validate the depends_on dependencies and return an error if the
+// dependency is not a step defined before the container, or the dependency
+// mode is unknown.
+func CheckDependsOn(c *yaml.Container, prior []*yaml.Container) error {
+	var names []string
+	for name := range c.DependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mode := c.DependsOn[name]
+		if mode != yaml.DependSuccess && mode != yaml.DependAlways {
+			return fmt.Errorf("Invalid depends_on mode %s for %s", mode, name)
+		}
+		if lookup(prior, name) == nil {
+			return fmt.Errorf("Invalid depends_on, %s is not a prior step", name)
+		}
+	}
+	return nil
+}
+
+// validate the container sysctls and return an error if the sysctl name is
+// invalid or, for untrusted builds, outside the network namespace.
+func CheckSysctls(c *yaml.Container, trusted bool) error {
+	for name := range c.Sysctls {
+		if !sysctlRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid sysctl %s", name)
+		}
+		if !trusted && !strings.HasPrefix(name, "net.") {
+			return fmt.Errorf("Insufficient privileges to use sysctl %s", name)
+		}
+	}
+	return nil
+}
+
+// validate the container name and return an error if the name contains a
+// path separator, since the name is used to name the detached log files.
+func CheckName(c *yaml.Container) error {
+	if strings.ContainsAny(c.Name, `/\`) {
+		return fmt.Errorf("Invalid name %s", c.Name)
+	}
+	return nil
+}
+
+// validate the service alias and return an error if the alias is not a
+// valid hostname.
+func CheckAlias(c *yaml.Container) error {
+	if c.Alias != "" && !aliasRegexp.MatchString(c.Alias) {
+		return fmt.Errorf("Invalid alias %s", c.Alias)
+	}
+	return nil
+}
+
+// validate the container links and return an error if a link references a
+// service that is not defined, or uses an invalid alias.
+func CheckLinks(c *yaml.Container, services []*yaml.Container) error {
+	for _, link := range c.Links {
+		name, alias := splitLink(link)
+		if lookup(services, name) == nil {
+			return fmt.Errorf("Cannot link to %s, service is not defined", name)
+		}
+		if !aliasRegexp.MatchString(alias) {
+			return fmt.Errorf("Invalid link alias %s", alias)
+		}
+	}
+	return nil
+}
+
+// validate the exec_in service and return an error if the service is not
+// defined, or the step does not define commands to execute in the service.
+func CheckExecIn(c *yaml.Container, services []*yaml.Container) error {
+	if c.ExecIn == "" {
+		return nil
+	}
+	if lookup(services, c.ExecIn) == nil {
+		return fmt.Errorf("Cannot exec %s in %s, service is not defined", c.Name, c.ExecIn)
+	}
+	if len(c.Commands) == 0 {
+		return fmt.Errorf("Cannot exec %s in %s, step has no commands", c.Name, c.ExecIn)
+	}
+	return nil
+}
+
+// systemDirs are the directories in which secret files cannot be written,
+// since the secret files are mounted on a volume that hides the existing
+// contents of the directory.
+var systemDirs = []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/sbin", "/sys", "/usr", "/var"}
+
+// validate the secret files and return an error if the file path is not
+// an absolute path, or the directory of the file is a system directory, in
+// the workspace base or a parent of the workspace base. The directory of each
+// file is mounted on a tmpfs volume, which hides the existing contents of the
+// directory.
+func CheckSecretFiles(c *yaml.Container, base string) error {
+	for _, path := range c.SecretFiles {
+		if !filepath.IsAbs(path) || filepath.Dir(path) == "/" {
+			return fmt.Errorf("Invalid secret file path %s", path)
+		}
+		dir := filepath.Dir(path)
+		if base != "" && (dir == base || within(dir, base)) {
+			return fmt.Errorf("Invalid secret file path %s, cannot write secret files in the workspace", path)
+		}
+		if base != "" && within(base, dir) {
+			return fmt.Errorf("Invalid secret file path %s, cannot mount secret files over the workspace", path)
+		}
+		for _, system := range systemDirs {
+			if dir == system || within(dir, system) {
+				return fmt.Errorf("Invalid secret file path %s, cannot write secret files in %s", path, system)
+			}
+		}
+	}
+	return nil
+}
+
+// validate the step cache paths and return an error if the path is empty,
+// the root directory, cannot be used as a volume mount point, or the volume
+// would be mounted over the workspace. Relative paths must resolve to a path
+// in the workspace.
+func CheckCache(c *yaml.Container, workspace string) error {
+	for _, path := range c.Cache {
+		if path == "" || strings.Contains(path, ":") {
+			return fmt.Errorf("Invalid cache path %s", path)
+		}
+		target, relative := path, false
+		switch {
+		case path == "~" || strings.HasPrefix(path, "~/"):
+			target = filepath.Join(homeDir, path[1:])
+		case !filepath.IsAbs(path):
+			target, relative = filepath.Join(workspace, path), true
+		}
+		target = filepath.Clean(target)
+		if target == "/" {
+			return fmt.Errorf("Invalid cache path %s", path)
+		}
+		if workspace == "" {
+			continue
+		}
+		if relative && target != filepath.Clean(workspace) && !within(target, workspace) {
+			return fmt.Errorf("Invalid cache path %s, cannot cache paths outside the workspace", path)
+		}
+		if target == filepath.Clean(workspace) || within(workspace, target) {
+			return fmt.Errorf("Invalid cache path %s, cannot mount the cache over the workspace", path)
+		}
+	}
+	return nil
+}
+
+// validate the container platform and return an error if the platform is not
+// a known platform.
+func CheckPlatform(c *yaml.Container) error {
+	if c.Platform != "" && !platforms[c.Platform] {
+		return fmt.Errorf("Invalid platform %s", c.Platform)
+	}
+	return nil
+}
+
+// platforms defines the known container platforms.
+var platforms = map[string]bool{
+	"linux/386":     true,
+	"linux/amd64":   true,
+	"linux/arm":     true,
+	"linux/arm64":   true,
+	"linux/ppc64le": true,
+	"linux/s390x":   true,
+	"windows/amd64": true,
+}
+
+// validate the container user and return an error if the user is not in
+// the user, uid, user:group or uid:gid format.
+func CheckUser(c *yaml.Container) error {
+	if c.User != "" && !userRegexp.MatchString(c.User) {
+		return fmt.Errorf("Invalid user %s", c.User)
+	}
+	return nil
+}
+
+var userRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]*)?$`)
+
+var aliasRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+var cpusetRegexp = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+var sysctlRegexp = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_-]+)+$`)
+
 // validate the container configuration and return an error if restricted
 // configurations are used.
 func CheckTrusted(c *yaml.Container) error {
 	if c.Privileged {
 		return fmt.Errorf("Insufficient privileges to use privileged mode")
 	}
+	if c.DockerSocket {
+		return fmt.Errorf("Insufficient privileges to mount the docker socket")
+	}
 	if len(c.DNS) != 0 {
 		return fmt.Errorf("Insufficient privileges to use custom dns")
 	}
@@ -69,6 +372,15 @@ func CheckTrusted(c *yaml.Container) error {
 	if c.OomKillDisable {
 		return fmt.Errorf("Insufficient privileges to disable oom_kill")
 	}
+	if c.MemSwapLimit < 0 {
+		return fmt.Errorf("Insufficient privileges to use unlimited memswap_limit")
+	}
+	if c.CPUSet != "" {
+		return fmt.Errorf("Insufficient privileges to use cpuset")
+	}
+	if c.ImageBuild != nil && c.ImageBuild.Keep {
+		return fmt.Errorf("Insufficient privileges to keep built images")
+	}
 	if len(c.Volumes) != 0 {
 		return fmt.Errorf("Insufficient privileges to use volumes")
 	}
@@ -40,6 +40,21 @@ func Test_validate(t *testing.T) {
 				g.Assert(err.Error()).Equal("Insufficient privileges to use privileged mode")
 			})
 
+			g.It("should error when docker socket mounted", func() {
+				c := newConfig(&yaml.Container{
+					DockerSocket: true,
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to mount the docker socket")
+			})
+
+			g.It("should not error when docker socket mounted by trusted build", func() {
+				c := newConfig(&yaml.Container{DockerSocket: true})
+				err := Check(c, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
 			g.It("should error when dns configured", func() {
 				c := newConfig(&yaml.Container{
 					DNS: []string{"8.8.8.8"},
@@ -111,6 +126,561 @@ func Test_validate(t *testing.T) {
 				g.Assert(err != nil).IsTrue("error should not be nil")
 				g.Assert(err.Error()).Equal("Insufficient privileges to use volumes_from")
 			})
+
+			g.It("should error when keeping built images", func() {
+				c := newConfig(&yaml.Container{
+					ImageBuild: &yaml.ImageBuild{Keep: true},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to keep built images")
+			})
+		})
+
+		g.Describe("resource limits", func() {
+
+			g.It("should error when swappiness out of bounds", func() {
+				swappiness := int64(101)
+				c := newConfig(&yaml.Container{
+					MemSwappiness: &swappiness,
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid mem_swappiness, must be between 0 and 100")
+			})
+
+			g.It("should error when memswap_limit without mem_limit", func() {
+				c := newConfigService(&yaml.Container{
+					MemSwapLimit: 1024,
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Cannot set memswap_limit without mem_limit")
+			})
+
+			g.It("should error when memswap_limit less than mem_limit", func() {
+				c := newConfig(&yaml.Container{
+					MemLimit:     2048,
+					MemSwapLimit: 1024,
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid memswap_limit, must be greater than mem_limit")
+			})
+
+			g.It("should error when unlimited swap for untrusted build", func() {
+				c := newConfig(&yaml.Container{
+					MemLimit:     2048,
+					MemSwapLimit: -1,
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to use unlimited memswap_limit")
+			})
+
+			g.It("should not error when unlimited swap for trusted build", func() {
+				c := newConfig(&yaml.Container{
+					MemLimit:     2048,
+					MemSwapLimit: -1,
+				})
+				err := Check(c, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should not error when swap limits within bounds", func() {
+				swappiness := int64(0)
+				c := newConfig(&yaml.Container{
+					MemLimit:      1024,
+					MemSwapLimit:  2048,
+					MemSwappiness: &swappiness,
+				})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should allow cpuset for trusted builds", func() {
+				c := newConfig(&yaml.Container{
+					CPUSet: "0-2,4",
+				})
+				err := Check(c, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when cpuset for untrusted build", func() {
+				c := newConfig(&yaml.Container{
+					CPUSet: "0-2",
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to use cpuset")
+			})
+
+			g.It("should error when cpuset is invalid", func() {
+				c := newConfig(&yaml.Container{
+					CPUSet: "0-",
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid cpuset 0-")
+			})
+		})
+
+		g.Describe("sysctls", func() {
+
+			g.It("should allow network sysctls for untrusted builds", func() {
+				c := newConfig(&yaml.Container{
+					Sysctls: map[string]string{"net.core.somaxconn": "1024"},
+				})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when kernel sysctls for untrusted builds", func() {
+				c := newConfigService(&yaml.Container{
+					Sysctls: map[string]string{"kernel.shmmax": "1024"},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to use sysctl kernel.shmmax")
+			})
+
+			g.It("should allow kernel sysctls for trusted builds", func() {
+				c := newConfig(&yaml.Container{
+					Sysctls: map[string]string{"kernel.shmmax": "1024"},
+				})
+				err := Check(c, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when sysctl name is invalid", func() {
+				c := newConfig(&yaml.Container{
+					Sysctls: map[string]string{"net": "1"},
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid sysctl net")
+			})
+		})
+
+		g.Describe("success_of constraint", func() {
+
+			g.It("should allow prior steps", func() {
+				integration := &yaml.Container{Name: "integration"}
+				integration.Constraints.SuccessOf.Include = []string{"unit"}
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{{Name: "unit"}, integration},
+				}
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the step is not a prior step", func() {
+				unit := &yaml.Container{Name: "unit"}
+				unit.Constraints.SuccessOf.Include = []string{"integration"}
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{unit, {Name: "integration"}},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid success_of, integration is not a prior step")
+			})
+		})
+
+		g.Describe("depends_on dependencies", func() {
+
+			g.It("should allow prior steps", func() {
+				cleanup := &yaml.Container{Name: "cleanup", DependsOn: yaml.Dependencies{"unit": yaml.DependAlways}}
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{{Name: "unit"}, cleanup},
+				}
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the step is not a prior step", func() {
+				unit := &yaml.Container{Name: "unit", DependsOn: yaml.Dependencies{"integration": yaml.DependSuccess}}
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{unit, {Name: "integration"}},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid depends_on, integration is not a prior step")
+			})
+
+			g.It("should error when the mode is unknown", func() {
+				cleanup := &yaml.Container{Name: "cleanup", DependsOn: yaml.Dependencies{"unit": "never"}}
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{{Name: "unit"}, cleanup},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid depends_on mode never for unit")
+			})
+		})
+
+		g.Describe("container user", func() {
+
+			g.It("should allow users for untrusted builds", func() {
+				for _, user := range []string{"1000", "1000:1000", "octocat", "octocat:staff"} {
+					c := newConfig(&yaml.Container{
+						User: user,
+					})
+					err := Check(c, false)
+					g.Assert(err == nil).IsTrue("error should be nil for user " + user)
+				}
+			})
+
+			g.It("should error when user is invalid", func() {
+				c := newConfigService(&yaml.Container{
+					User: "1000:1000:1000",
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid user 1000:1000:1000")
+			})
+		})
+
+		g.Describe("container name", func() {
+
+			g.It("should error when the name contains a path separator", func() {
+				c := newConfigService(&yaml.Container{
+					Name: "../../var/log/auth",
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid name ../../var/log/auth")
+			})
+		})
+
+		g.Describe("service alias", func() {
+
+			g.It("should allow service aliases", func() {
+				c := newConfigService(&yaml.Container{
+					Alias: "database.local",
+				})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when alias is invalid", func() {
+				c := newConfigService(&yaml.Container{
+					Alias: "database:5432",
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid alias database:5432")
+			})
+
+			g.It("should error when alias is set for pipeline steps", func() {
+				c := newConfig(&yaml.Container{
+					Alias: "build",
+				})
+				err := Check(c, true)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Cannot set alias for pipeline steps")
+			})
+		})
+
+		g.Describe("clone verification", func() {
+
+			g.It("should allow skipping verification for trusted builds", func() {
+				c := newConfig(&yaml.Container{
+					Name:  "clone",
+					Vargs: map[string]interface{}{"skip_verify": true},
+				})
+				err := Check(c, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when skipping verification for untrusted builds", func() {
+				c := newConfig(&yaml.Container{
+					Name:  "clone",
+					Vargs: map[string]interface{}{"skip_verify": "true"},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to skip clone verification")
+			})
+
+			g.It("should allow a certificate authority for untrusted builds", func() {
+				c := newConfig(&yaml.Container{
+					Name:  "clone",
+					Vargs: map[string]interface{}{"ca_cert": "/etc/ssl/certs/internal.pem"},
+				})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+		})
+
+		g.Describe("plugin escalation", func() {
+
+			patterns := []string{"plugins/docker", "plugins/docker:*", "*/docker-in-docker:*"}
+
+			g.It("should allow escalating plugins", func() {
+				c := newConfig(&yaml.Container{Image: "plugins/docker:latest"})
+				err := CheckEscalate(c, patterns, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when an untrusted build image is escalated", func() {
+				c := newConfig(&yaml.Container{
+					Image:    "octocat/docker-in-docker:latest",
+					Commands: []string{"docker ps"},
+				})
+				err := CheckEscalate(c, patterns, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Insufficient privileges to escalate octocat/docker-in-docker:latest, only plugins may be escalated")
+			})
+
+			g.It("should allow escalating build images when trusted", func() {
+				c := newConfig(&yaml.Container{
+					Image:    "octocat/docker-in-docker:latest",
+					Commands: []string{"docker ps"},
+				})
+				err := CheckEscalate(c, patterns, true)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should ignore build images that are not escalated", func() {
+				c := newConfig(&yaml.Container{
+					Image:    "golang:1.5",
+					Commands: []string{"go test"},
+				})
+				err := CheckEscalate(c, patterns, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+		})
+
+		g.Describe("step cache", func() {
+
+			g.It("should allow cache paths", func() {
+				c := newConfig(&yaml.Container{Cache: []string{"~/.m2", "/go/pkg", "vendor"}})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the cache path is the root directory", func() {
+				c := newConfig(&yaml.Container{Cache: []string{"/"}})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid cache path /")
+			})
+
+			g.It("should error when the cache path includes a volume separator", func() {
+				c := newConfig(&yaml.Container{Cache: []string{"/host:/go/pkg"}})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid cache path /host:/go/pkg")
+			})
+
+			g.It("should error when the cache path is outside the workspace", func() {
+				for _, path := range []string{"..", "vendor/../../pkg"} {
+					c := newConfig(&yaml.Container{Cache: []string{path}})
+					c.Workspace = &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"}
+					err := Check(c, false)
+					g.Assert(err != nil).IsTrue("error should not be nil")
+					g.Assert(err.Error()).Equal("Invalid cache path " + path + ", cannot cache paths outside the workspace")
+				}
+			})
+
+			g.It("should error when the cache is mounted over the workspace", func() {
+				for _, path := range []string{".", "vendor/..", "/go", "/go/src/github.com/octocat/hello-world/"} {
+					c := newConfig(&yaml.Container{Cache: []string{path}})
+					c.Workspace = &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"}
+					err := Check(c, false)
+					g.Assert(err != nil).IsTrue("error should not be nil")
+					g.Assert(err.Error()).Equal("Invalid cache path " + path + ", cannot mount the cache over the workspace")
+				}
+			})
+
+			g.It("should allow cache paths in the workspace", func() {
+				c := newConfig(&yaml.Container{Cache: []string{"~/.m2", "/go/pkg", "vendor", "./node_modules"}})
+				c.Workspace = &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"}
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+		})
+
+		g.Describe("container platform", func() {
+
+			g.It("should allow known platforms", func() {
+				c := newConfig(&yaml.Container{Platform: "linux/arm64"})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the platform is unknown", func() {
+				c := newConfigService(&yaml.Container{Platform: "linux/sparc"})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid platform linux/sparc")
+			})
+		})
+
+		g.Describe("secret files", func() {
+
+			g.It("should allow absolute secret file paths", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": "/root/.ssh/id_rsa"},
+				})
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the secret file path is relative", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": ".ssh/id_rsa"},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid secret file path .ssh/id_rsa")
+			})
+
+			g.It("should error when the secret file is in the root directory", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": "/id_rsa"},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid secret file path /id_rsa")
+			})
+
+			g.It("should error when the secret file is in a system directory", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": "/usr/bin/key"},
+				})
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid secret file path /usr/bin/key, cannot write secret files in /usr")
+			})
+
+			g.It("should error when the secret file is in the workspace", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": "/drone/src/github.com/octocat/hello-world/key"},
+				})
+				c.Workspace = &yaml.Workspace{Base: "/drone", Path: "/drone/src/github.com/octocat/hello-world"}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid secret file path /drone/src/github.com/octocat/hello-world/key, cannot write secret files in the workspace")
+			})
+
+			g.It("should error when the secret file is in a parent of the workspace", func() {
+				c := newConfig(&yaml.Container{
+					SecretFiles: map[string]string{"SSH_KEY": "/srv/key"},
+				})
+				c.Workspace = &yaml.Workspace{Base: "/srv/drone", Path: "/srv/drone/src/github.com/octocat/hello-world"}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid secret file path /srv/key, cannot mount secret files over the workspace")
+			})
+		})
+
+		g.Describe("container links", func() {
+
+			g.It("should allow links to declared services", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "test", Links: []string{"postgres", "redis:cache"}},
+					},
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+						{Name: "redis"},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when linking to an undeclared service", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "test", Links: []string{"mysql"}},
+					},
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Cannot link to mysql, service is not defined")
+			})
+
+			g.It("should error when linking to a pipeline step", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "build"},
+						{Name: "test", Links: []string{"build"}},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Cannot link to build, service is not defined")
+			})
+
+			g.It("should error when the link alias is invalid", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "test", Links: []string{"postgres:db_1"}},
+					},
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err != nil).IsTrue("error should not be nil")
+				g.Assert(err.Error()).Equal("Invalid link alias db_1")
+			})
+		})
+
+		g.Describe("exec in service", func() {
+
+			g.It("should allow exec in a declared service", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "migrate", ExecIn: "postgres", Commands: []string{"psql -f schema.sql"}},
+					},
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err == nil).IsTrue("error should be nil")
+			})
+
+			g.It("should error when the service is not declared", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "build", Commands: []string{"make"}},
+						{Name: "migrate", ExecIn: "build", Commands: []string{"psql -f schema.sql"}},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err.Error()).Equal("Cannot exec migrate in build, service is not defined")
+			})
+
+			g.It("should error when the step has no commands", func() {
+				c := &yaml.Config{
+					Pipeline: []*yaml.Container{
+						{Name: "migrate", ExecIn: "postgres"},
+					},
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+					},
+				}
+				err := Check(c, false)
+				g.Assert(err.Error()).Equal("Cannot exec migrate in postgres, step has no commands")
+			})
+
+			g.It("should error when a service sets exec_in", func() {
+				c := &yaml.Config{
+					Services: []*yaml.Container{
+						{Name: "postgres"},
+						{Name: "redis", ExecIn: "postgres"},
+					},
+				}
+				err := Check(c, true)
+				g.Assert(err.Error()).Equal("Cannot set exec_in for services")
+			})
 		})
 
 		g.Describe("plugin configuration", func() {
@@ -18,3 +18,22 @@ func ImageVolume(conf *yaml.Config, volumes []string) error {
 
 	return nil
 }
+
+// dockerSocket is the path of the host docker socket.
+const dockerSocket = "/var/run/docker.sock"
+
+// DockerSocket transforms the Yaml to mount the host docker socket in the
+// containers that request it. This grants the container control of the host
+// daemon and is restricted to trusted repositories by the Check transform.
+func DockerSocket(conf *yaml.Config) error {
+	var containers []*yaml.Container
+	containers = append(containers, conf.Pipeline...)
+	containers = append(containers, conf.Services...)
+
+	for _, container := range containers {
+		if container.DockerSocket {
+			container.Volumes = append(container.Volumes, dockerSocket+":"+dockerSocket)
+		}
+	}
+	return nil
+}
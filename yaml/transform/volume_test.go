@@ -0,0 +1,24 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/drone/drone-exec/yaml"
+
+	"github.com/franela/goblin"
+)
+
+func Test_volume(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("docker socket", func() {
+
+		g.It("should mount the docker socket", func() {
+			c := newConfig(&yaml.Container{Name: "publish", DockerSocket: true})
+			c.Pipeline = append(c.Pipeline, &yaml.Container{Name: "test"})
+			DockerSocket(c)
+			g.Assert(c.Pipeline[0].Volumes).Equal([]string{"/var/run/docker.sock:/var/run/docker.sock"})
+			g.Assert(len(c.Pipeline[1].Volumes)).Equal(0)
+		})
+	})
+}
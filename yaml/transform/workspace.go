@@ -1,9 +1,13 @@
 package transform
 
 import (
+	"encoding/base64"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/drone/drone-exec/yaml"
+	"github.com/gorilla/securecookie"
 )
 
 // WorkspaceTransform transforms ...
@@ -18,6 +22,12 @@ func WorkspaceTransform(c *yaml.Config, base, path string) error {
 	if c.Workspace.Path == "" {
 		c.Workspace.Path = path
 	}
+	// the workspace path must not escape the workspace base through a
+	// parent directory element, since the clone step writes to the
+	// workspace path.
+	if escapes(c.Workspace.Path, c.Workspace.Base, c.Workspace.Base) {
+		return fmt.Errorf("Cannot use workspace path %s, path traversal is not allowed", c.Workspace.Path)
+	}
 	if !filepath.IsAbs(c.Workspace.Path) {
 		c.Workspace.Path = filepath.Join(
 			c.Workspace.Base,
@@ -27,6 +37,92 @@ func WorkspaceTransform(c *yaml.Config, base, path string) error {
 
 	for _, p := range c.Pipeline {
 		p.WorkingDir = c.Workspace.Path
+
+		// the image build context is relative to the workspace.
+		if p.ImageBuild != nil && escapes(p.ImageBuild.Context, c.Workspace.Path, c.Workspace.Base) {
+			return fmt.Errorf("Cannot use image_build context %s of step %s, path traversal is not allowed", p.ImageBuild.Context, p.Name)
+		}
+		if p.ImageBuild != nil && !filepath.IsAbs(p.ImageBuild.Context) {
+			p.ImageBuild.Context = filepath.Join(
+				c.Workspace.Path,
+				p.ImageBuild.Context,
+			)
+		}
+
+		// the env file is relative to the workspace.
+		if escapes(p.EnvFile, c.Workspace.Path, c.Workspace.Base) {
+			return fmt.Errorf("Cannot use env_file %s of step %s, path traversal is not allowed", p.EnvFile, p.Name)
+		}
+		if p.EnvFile != "" && !filepath.IsAbs(p.EnvFile) {
+			p.EnvFile = filepath.Join(c.Workspace.Path, p.EnvFile)
+		}
+	}
+	return nil
+}
+
+// escapes returns true if the path contains a parent directory element and
+// the cleaned path, resolved against the parent directory when relative, is
+// not below the directory.
+func escapes(path, parent, dir string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem != ".." {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(parent, path)
+		}
+		return !within(path, dir)
+	}
+	return false
+}
+
+// within returns true if the cleaned path is below the directory.
+func within(path, dir string) bool {
+	return strings.HasPrefix(filepath.Clean(path), filepath.Clean(dir)+"/")
+}
+
+// WorkspacePermissions transforms the Yaml to make the workspace writable by
+// steps that run as a non-root user, since the files created by the clone
+// step, and by other steps that run as root, are owned by root. The
+// permissions are updated before each step that overrides the user and
+// follows a step that runs as root. The permissions step is identified by the
+// step that follows it, and uses the default ambassador image when no image
+// is provided. This transform must run after the Workspace and StepIdentifier
+// transforms, and must not run for local builds, since the workspace is the
+// host source directory.
+func WorkspacePermissions(c *yaml.Config, image string) error {
+	if image == "" {
+		image = ambassadorImage
+	}
+	rand := base64.RawURLEncoding.EncodeToString(
+		securecookie.GenerateRandomKey(8),
+	)
+
+	var pipeline []*yaml.Container
+	root := false
+	for i, container := range c.Pipeline {
+		if container.User == "" {
+			root = true
+			pipeline = append(pipeline, container)
+			continue
+		}
+		if root {
+			permissions := &yaml.Container{
+				ID:          fmt.Sprintf("drone_permissions_%s_%d", rand, i),
+				Name:        "permissions",
+				Image:       image,
+				Entrypoint:  []string{"/bin/chmod"},
+				Command:     []string{"-R", "a+rwX", c.Workspace.Base},
+				Environment: map[string]string{},
+			}
+			if container.Step != "" {
+				permissions.Step = container.Step + "_permissions"
+			}
+			pipeline = append(pipeline, permissions)
+			root = false
+		}
+		pipeline = append(pipeline, container)
 	}
+	c.Pipeline = pipeline
 	return nil
 }
@@ -49,6 +49,81 @@ func TestWorkspace(t *testing.T) {
 			g.Assert(conf.Workspace.Path).Equal(abs)
 		})
 
+		g.It("should reject path traversal", func() {
+			paths := []string{
+				"../etc",
+				"src/../../etc",
+				"/drone/src/../../etc",
+				"..",
+			}
+			for _, path := range paths {
+				conf := &yaml.Config{
+					Workspace: &yaml.Workspace{Base: "/drone", Path: path},
+				}
+				err := WorkspaceTransform(conf, defaultBase, defaultPath)
+				g.Assert(err != nil).IsTrue()
+			}
+		})
+
+		g.It("should allow absolute workspace paths without traversal", func() {
+			paths := []string{
+				"/srv/hello-world",
+				"/go/src/../src/hello-world",
+			}
+			for _, path := range paths {
+				conf := &yaml.Config{
+					Workspace: &yaml.Workspace{Base: "/go", Path: path},
+				}
+				err := WorkspaceTransform(conf, defaultBase, defaultPath)
+				g.Assert(err == nil).IsTrue()
+			}
+
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src/../../etc"},
+			}
+			err := WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("should reject image build context and env file traversal", func() {
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"},
+				Pipeline:  []*yaml.Container{{Name: "build", ImageBuild: &yaml.ImageBuild{Context: "../../../../../etc"}}},
+			}
+			err := WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(err.Error()).Equal("Cannot use image_build context ../../../../../etc of step build, path traversal is not allowed")
+
+			conf = &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"},
+				Pipeline:  []*yaml.Container{{Name: "test", EnvFile: "../../../../../etc/passwd"}},
+			}
+			err = WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(err.Error()).Equal("Cannot use env_file ../../../../../etc/passwd of step test, path traversal is not allowed")
+
+			conf = &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/go", Path: "/go/src/github.com/octocat/hello-world"},
+				Pipeline:  []*yaml.Container{{Name: "test", EnvFile: "../world/.env"}},
+			}
+			err = WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(err == nil).IsTrue()
+			g.Assert(conf.Pipeline[0].EnvFile).Equal("/go/src/github.com/octocat/world/.env")
+		})
+
+		g.It("should allow workspace subpaths", func() {
+			paths := []string{
+				"src/github.com/octocat/hello-world",
+				"src/github.com/octocat/hello..world",
+				"./src",
+			}
+			for _, path := range paths {
+				conf := &yaml.Config{
+					Workspace: &yaml.Workspace{Base: "/drone", Path: path},
+				}
+				err := WorkspaceTransform(conf, defaultBase, defaultPath)
+				g.Assert(err == nil).IsTrue()
+			}
+		})
+
 		g.It("should set the default path", func() {
 			var base = "/go"
 			var path = "/go/src/github.com/octocat/hello-world"
@@ -78,6 +153,119 @@ func TestWorkspace(t *testing.T) {
 			g.Assert(conf.Pipeline[0].WorkingDir).Equal(path)
 		})
 
+		g.It("should resolve the image build context in the workspace", func() {
+			var base = "/drone"
+			var path = "/drone/src/github.com/octocat/hello-world"
+
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{
+					Base: base,
+					Path: path,
+				},
+				Pipeline: []*yaml.Container{
+					{ImageBuild: &yaml.ImageBuild{}},
+					{ImageBuild: &yaml.ImageBuild{Context: "docker/build"}},
+					{ImageBuild: &yaml.ImageBuild{Context: "/tmp/build"}},
+				},
+			}
+
+			WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(conf.Pipeline[0].ImageBuild.Context).Equal(path)
+			g.Assert(conf.Pipeline[1].ImageBuild.Context).Equal(path + "/docker/build")
+			g.Assert(conf.Pipeline[2].ImageBuild.Context).Equal("/tmp/build")
+		})
+
+		g.It("should resolve the env file in the workspace", func() {
+			var path = "/drone/src/github.com/octocat/hello-world"
+
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone", Path: path},
+				Pipeline: []*yaml.Container{
+					{},
+					{EnvFile: "build/.env"},
+					{EnvFile: "/tmp/.env"},
+				},
+			}
+
+			WorkspaceTransform(conf, defaultBase, defaultPath)
+			g.Assert(conf.Pipeline[0].EnvFile).Equal("")
+			g.Assert(conf.Pipeline[1].EnvFile).Equal(path + "/build/.env")
+			g.Assert(conf.Pipeline[2].EnvFile).Equal("/tmp/.env")
+		})
+
+		g.It("should update permissions before the first non-root step", func() {
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{
+					Base: "/drone",
+					Path: "/drone/src/github.com/octocat/hello-world",
+				},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "build"},
+					{Name: "test", User: "1000:1000"},
+					{Name: "deploy", User: "1000:1000"},
+				},
+			}
+
+			WorkspacePermissions(conf, "")
+			g.Assert(len(conf.Pipeline)).Equal(5)
+			g.Assert(conf.Pipeline[1].Name).Equal("build")
+			g.Assert(conf.Pipeline[2].Name).Equal("permissions")
+			g.Assert(conf.Pipeline[2].Image).Equal(ambassadorImage)
+			g.Assert(conf.Pipeline[2].Entrypoint).Equal([]string{"/bin/chmod"})
+			g.Assert(conf.Pipeline[2].Command).Equal([]string{"-R", "a+rwX", "/drone"})
+			g.Assert(conf.Pipeline[3].Name).Equal("test")
+		})
+
+		g.It("should update permissions after each root step", func() {
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone"},
+				Pipeline: []*yaml.Container{
+					{Name: "clone", Step: "pipeline_0_clone"},
+					{Name: "test", Step: "pipeline_1_test", User: "1000:1000"},
+					{Name: "build", Step: "pipeline_2_build"},
+					{Name: "package", Step: "pipeline_3_package", User: "1000:1000"},
+					{Name: "deploy", Step: "pipeline_4_deploy", User: "1000:1000"},
+				},
+			}
+
+			WorkspacePermissions(conf, "")
+			var names []string
+			for _, c := range conf.Pipeline {
+				names = append(names, c.Name)
+			}
+			g.Assert(names).Equal([]string{"clone", "permissions", "test", "build", "permissions", "package", "deploy"})
+			g.Assert(conf.Pipeline[1].Step).Equal("pipeline_1_test_permissions")
+			g.Assert(conf.Pipeline[4].Step).Equal("pipeline_3_package_permissions")
+			g.Assert(conf.Pipeline[1].ID == conf.Pipeline[4].ID).IsFalse()
+		})
+
+		g.It("should update permissions with the configured ambassador image", func() {
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone"},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "test", User: "1000:1000"},
+				},
+			}
+
+			WorkspacePermissions(conf, "registry.internal/library/busybox:1.25")
+			g.Assert(conf.Pipeline[1].Image).Equal("registry.internal/library/busybox:1.25")
+		})
+
+		g.It("should not update permissions for root steps", func() {
+			conf := &yaml.Config{
+				Workspace: &yaml.Workspace{Base: "/drone"},
+				Pipeline: []*yaml.Container{
+					{Name: "clone"},
+					{Name: "build"},
+				},
+			}
+
+			WorkspacePermissions(conf, "")
+			g.Assert(len(conf.Pipeline)).Equal(2)
+		})
+
 		g.It("should not use workspace as working_dir for services", func() {
 			var base = "/drone"
 			var path = "/drone/src/github.com/octocat/hello-world"
@@ -8,10 +8,10 @@ import (
 
 // Volume defines a Docker volume.
 type Volume struct {
-	Name       string
-	Driver     string
-	DriverOpts map[string]string `yaml:"driver_opts"`
-	External   bool
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty" yaml:"driver_opts"`
+	External   bool              `json:"external,omitempty"`
 }
 
 // volumeList is an intermediate type used for decoding a slice of volumes